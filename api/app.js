@@ -48,6 +48,9 @@ const orgName = config.org
 
 var channelName = hfc.getConfigSetting('channelName');
 var chaincodeName = hfc.getConfigSetting('chaincodeName');
+// the ID of the bank and the name of the interbank chaincode, payments to another bank are made with interbankTransfer
+var bankID = hfc.getConfigSetting('bankID');
+var interbankChaincodeName = hfc.getConfigSetting('interbankChaincodeName');
 var peers = hfc.getConfigSetting('peers');
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -142,11 +145,18 @@ app.get('/transactions/:accNumber', awaitHandler(async(req, res) => {
 	res.send(Object.values(message[0].History));
 }));
 
-// the transfer method invokes the transfer chaincode function to perform an intra or interbank transfer
+// the transfer method invokes the transfer chaincode function to perform an intrabank transfer, or the interbank
+// chaincode's interbankTransfer function to pay an account at another bank
 app.post('/transfer', awaitHandler(async(req, res) => {
 	var args = req.body;
 	var fcn = "transfer";
 
+	if (bankID && interbankChaincodeName && args['ToBankID'] != bankID) {
+		let message = await interbankTransfer(args);
+		res.send(message);
+		return;
+	}
+
 	logger.info('================ POST on transfer');
 	logger.info('##### POST for transfer - username : ' + username);
 	logger.info('##### POST for transfer - userOrg : ' + orgName);
@@ -167,6 +177,25 @@ app.post('/transfer', awaitHandler(async(req, res) => {
 }));
 
 
+// interbankTransfer pays an account at another bank, in the currency of the payer's account
+async function interbankTransfer(args) {
+	var fcn = "interbankTransfer";
+
+	let account = await query.queryChaincode(peers, channelName, chaincodeName, [args['FromAccNumber']], "queryAccount", username, orgName);
+
+	logger.info('##### POST for transfer - interbankChaincodeName : ' + interbankChaincodeName);
+	logger.info('##### POST for transfer - fcn : ' + fcn);
+
+	var array_args = []
+	array_args[0] = args['ToAccNumber']
+	array_args[1] = args['ToBankID']
+	array_args[2] = String(args['Amount'])
+	array_args[3] = account[0].currency
+	array_args[4] = bankID
+	array_args[5] = args['FromAccNumber']
+
+	return await invoke.invokeChaincode(peers, channelName, interbankChaincodeName, array_args, fcn, username, orgName);
+}
 
 app.use(function(error, req, res, next) {
 	res.status(500).json({ error: error.toString() });
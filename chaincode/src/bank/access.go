@@ -0,0 +1,240 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package bank
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"invocation"
+)
+
+// caller is the identity invoking a transaction
+//MSPID - the MSP ID of the caller's organization
+//Name - the common name of the caller's certificate
+type caller struct {
+	MSPID string
+	Name  string
+}
+
+func (c *caller) key() string {
+	return c.MSPID + "/" + c.Name
+}
+
+// getCaller returns the identity invoking the transaction
+func getCaller(stub shim.ChaincodeStubInterface) (*caller, error) {
+	identity, err := cid.New(stub)
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	mspID, err := identity.GetMSPID()
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	cert, err := identity.GetX509Certificate()
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	return &caller{MSPID: mspID, Name: cert.Subject.CommonName}, nil
+}
+
+// checkAdmin returns an error unless the caller belongs to the bank's administrator MSP
+func checkAdmin(stub shim.ChaincodeStubInterface) error {
+	adminMSP, err := stub.GetState("adminMSP")
+
+	if err != nil {
+		return errors.New("Unable to retrieve administrator from ledger " + err.Error())
+	}
+
+	if adminMSP == nil {
+		return errors.New("No administrator MSP has been set, instantiate the chaincode with the administrator MSP ID")
+	}
+
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return err
+	}
+
+	if invoker.MSPID != string(adminMSP) {
+		return errors.New(invoker.key() + " is not a member of the administrator MSP")
+	}
+
+	return nil
+}
+
+// checkInterbankSender returns an error unless a bank is authorized to send interbank payments to this bank's accounts
+func checkInterbankSender(stub shim.ChaincodeStubInterface, bankID string) error {
+	authorized, err := hasKey(stub, "interbankSender", []string{bankID})
+
+	if err != nil {
+		return err
+	}
+
+	if !authorized {
+		return errors.New("Bank " + bankID + " is not an authorized interbank sender")
+	}
+
+	return nil
+}
+
+// checkTeller returns an error unless the caller is a registered teller or, with allowAdmin set, a member of the
+// administrator MSP
+func checkTeller(stub shim.ChaincodeStubInterface, allowAdmin bool) error {
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return err
+	}
+
+	authorized, err := hasKey(stub, "teller", []string{invoker.MSPID, invoker.Name})
+
+	if err != nil {
+		return err
+	}
+
+	if authorized {
+		return nil
+	}
+
+	if allowAdmin && checkAdmin(stub) == nil {
+		return nil
+	}
+
+	if allowAdmin {
+		return errors.New(invoker.key() + " is not an authorized teller or a member of the administrator MSP")
+	}
+
+	return errors.New(invoker.key() + " is not an authorized teller")
+}
+
+// checkInterbankContract returns an error unless the transaction was submitted to the bank's interbank contract.
+// A chaincode called by another chaincode sees the signed proposal sent to the first, so only a transaction
+// entered through the interbank contract names it there
+func checkInterbankContract(stub shim.ChaincodeStubInterface, interbankContract string) error {
+	if interbankContract == "" {
+		return errors.New("Interbank payments are not accepted, the bank has no interbank contract")
+	}
+
+	contract, err := invocation.TopLevelChaincode(stub)
+
+	if err != nil {
+		return err
+	}
+
+	if contract != interbankContract {
+		return errors.New("Interbank payments must be made through the interbank contract " + interbankContract + ", not " + contract)
+	}
+
+	return nil
+}
+
+func hasKey(stub shim.ChaincodeStubInterface, objectType string, attributes []string) (bool, error) {
+	key, err := stub.CreateCompositeKey(objectType, attributes)
+
+	if err != nil {
+		return false, err
+	}
+
+	value, err := stub.GetState(key)
+
+	if err != nil {
+		return false, errors.New("Unable to retrieve " + objectType + " from ledger " + err.Error())
+	}
+
+	return value != nil, nil
+}
+
+// setKey writes, or with remove set deletes, an authorization after checking the caller is an administrator
+func setKey(stub shim.ChaincodeStubInterface, objectType string, attributes []string, remove bool) sc.Response {
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(objectType, attributes)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if remove {
+		err = stub.DelState(key)
+	} else {
+		err = stub.PutState(key, []byte{0x00})
+	}
+
+	if err != nil {
+		return shim.Error("Unable to commit " + objectType + " to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// addTeller authorizes an identity to deposit funds, only callable by the administrator MSP
+//Args:
+//	MSPID string The MSP ID of the teller's organization
+//	Name  string The common name of the teller's certificate
+func (s *BankChaincode) addTeller(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect arguments, expecting the MSP ID and common name of the teller")
+	}
+
+	return setKey(stub, "teller", args, false)
+}
+
+// removeTeller revokes an identity's authorization to deposit funds, only callable by the administrator MSP
+//Args:
+//	MSPID string The MSP ID of the teller's organization
+//	Name  string The common name of the teller's certificate
+func (s *BankChaincode) removeTeller(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect arguments, expecting the MSP ID and common name of the teller")
+	}
+
+	return setKey(stub, "teller", args, true)
+}
+
+// authorizeInterbankSender accepts interbank credits from a bank, only callable by the administrator MSP
+//Args:
+//	BankID string The ID of the sending bank, as registered with the interbank contract
+func (s *BankChaincode) authorizeInterbankSender(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect arguments, expecting the ID of the sending bank")
+	}
+
+	return setKey(stub, "interbankSender", args, false)
+}
+
+// revokeInterbankSender stops accepting interbank credits from a bank, only callable by the administrator MSP
+//Args:
+//	BankID string The ID of the sending bank, as registered with the interbank contract
+func (s *BankChaincode) revokeInterbankSender(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect arguments, expecting the ID of the sending bank")
+	}
+
+	return setKey(stub, "interbankSender", args, true)
+}
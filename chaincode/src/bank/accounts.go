@@ -18,21 +18,66 @@ package bank
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
 	"github.com/shopspring/decimal"
+	"strings"
 )
 
-// createAccount creates a new bank account at this bank
+// compositeKeyNamespace starts every composite key, as created by CreateCompositeKey
+const compositeKeyNamespace = "\x00"
+
+// reservedKeys are the keys of the bank's configuration, which can not be used as account numbers
+var reservedKeys = map[string]bool{"bank": true, "adminMSP": true, "markupTiers": true, "roundingPolicy": true}
+
+// createAccount creates a new bank account at this bank, only callable by a teller or the administrator MSP
 //Args:
 //	Name      string          The customer name
-//	AccNumber string          The account number
-//	Balance   decimal.Decimal The account balance
+//	AccNumber string          The account number, which must not already be in use
+//	Balance   decimal.Decimal The opening balance, zero or more
 //	Currency  string          The three decimal currency code for the account
+//	Segment   string          Optional, the customer segment: retail, premium or corporate. Defaults to retail,
+//	                          only the administrator MSP may open an account in another segment
 
 func (s *BankChaincode) createAccount(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if len(args) != 4 {
-		return shim.Error("Incorrect arguments, expecting customer name, account number, balance and, currency")
+	if len(args) != 4 && len(args) != 5 {
+		return shim.Error("Incorrect arguments, expecting customer name, account number, balance, currency and optionally the customer segment")
+	}
+
+	err := checkTeller(stub, true)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if !validAccountNumber(args[1]) {
+		return shim.Error("Invalid account number " + args[1])
+	}
+
+	existing, err := stub.GetState(args[1])
+
+	if err != nil {
+		return shim.Error("Unable to retrieve account from ledger " + err.Error())
+	}
+
+	if existing != nil {
+		return shim.Error("Account " + args[1] + " already exists")
+	}
+
+	segment := segmentRetail
+	if len(args) == 5 {
+		err = checkAdmin(stub)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		segment = args[4]
+	}
+
+	if !validSegment(segment) {
+		return shim.Error("Unknown segment " + segment + ", expecting retail, premium or corporate")
 	}
 
 	balance, decimalError := decimal.NewFromString(args[2])
@@ -41,7 +86,11 @@ func (s *BankChaincode) createAccount(stub shim.ChaincodeStubInterface, args []s
 		return shim.Error("Unable to parse account balance")
 	}
 
-	account := account{Name: args[0], AccNumber: args[1], Balance: balance, Currency: args[3]}
+	if balance.IsNegative() {
+		return shim.Error("The opening balance can not be negative")
+	}
+
+	account := account{Name: args[0], AccNumber: args[1], Balance: balance, Currency: args[3], Segment: segment}
 
 	//serialize account
 	accountBytes, _ := json.Marshal(account)
@@ -66,6 +115,10 @@ func (s *BankChaincode) queryAccount(stub shim.ChaincodeStubInterface, args []st
 		return shim.Error("Incorrect number of arguments. Expecting the account number")
 	}
 
+	if !validAccountNumber(args[0]) {
+		return shim.Error("Invalid account number " + args[0])
+	}
+
 	accountAsBytes, stubError := stub.GetState(args[0])
 
 	if stubError != nil {
@@ -75,3 +128,81 @@ func (s *BankChaincode) queryAccount(stub shim.ChaincodeStubInterface, args []st
 
 	return (shim.Success(accountAsBytes))
 }
+
+// validAccountNumber reports whether an account number can belong to a customer account. Customer accounts are
+// stored under their account number, so it must not be a configuration key or fall in the composite key namespace
+// that holds the bank's own accounts
+func validAccountNumber(accNum string) bool {
+	return accNum != "" && !reservedKeys[accNum] && !strings.HasPrefix(accNum, compositeKeyNamespace)
+}
+
+// internalAccountKey returns the key of one of the bank's own accounts, such as a rounding, FX income or vostro
+// account. They are stored under the composite key internalAccount~Type~..., apart from customer accounts
+func internalAccountKey(stub shim.ChaincodeStubInterface, accountType string, attributes []string) (string, error) {
+	return stub.CreateCompositeKey("internalAccount", append([]string{accountType}, attributes...))
+}
+
+// getInternalAccount returns one of the bank's own accounts, nil if it does not yet exist
+func getInternalAccount(stub shim.ChaincodeStubInterface, accountType string, attributes ...string) (*account, error) {
+	key, err := internalAccountKey(stub, accountType, attributes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	accountAsBytes, err := stub.GetState(key)
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve account " + key + " from ledger " + err.Error())
+	}
+
+	if accountAsBytes == nil {
+		return nil, nil
+	}
+
+	acc := &account{}
+	err = json.Unmarshal(accountAsBytes, acc)
+
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal account " + acc.AccNumber + " " + err.Error())
+	}
+
+	return acc, nil
+}
+
+// creditInternalAccount adds an amount to one of the bank's own accounts, creating the account if it does not yet
+// exist. The account number is the type followed by the attributes, e.g. VOSTRO-0002-USD. A negative amount debits
+// the account
+func creditInternalAccount(stub shim.ChaincodeStubInterface, name string, currency string, amount decimal.Decimal, accountType string, attributes ...string) error {
+	if amount.IsZero() {
+		return nil
+	}
+
+	acc, err := getInternalAccount(stub, accountType, attributes...)
+
+	if err != nil {
+		return err
+	}
+
+	if acc == nil {
+		accNum := strings.Join(append([]string{accountType}, attributes...), "-")
+		acc = &account{Name: name, AccNumber: accNum, Balance: decimal.Zero, Currency: currency}
+	}
+
+	acc.Balance = acc.Balance.Add(amount)
+
+	key, err := internalAccountKey(stub, accountType, attributes)
+
+	if err != nil {
+		return err
+	}
+
+	accAsBytes, _ := json.Marshal(acc)
+	err = stub.PutState(key, accAsBytes)
+
+	if err != nil {
+		return errors.New("Error trying to commit account " + acc.AccNumber + " to ledger " + err.Error())
+	}
+
+	return nil
+}
@@ -18,6 +18,7 @@ package bank
 
 import (
 	"encoding/json"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
 	"github.com/shopspring/decimal"
@@ -30,7 +31,18 @@ import (
 //	invoke - called upon invocation and calls other functions
 //	createAccount - create a bank account
 //	deposit - deposit funds into a bank account
-//	transfer - transfer funds between accounts, either interbank or intrabank
+//	receiveInterbank - book the interbank payments passing through or paid to this bank, invoked by the interbank contract
+//	transfer - transfer funds between accounts at the bank
+//	getTransfer - return the record of a transfer between accounts at the bank, with its rate and markup
+//	interbankDebit - take the funds for an interbank transfer from the payer, invoked by the interbank contract
+//	interbankRefund - return the funds of a cancelled or expired queued interbank transfer, invoked by the interbank contract
+//	setRoundingPolicy - set the rounding mode applied to currency conversions
+//	setMarkupTier - set the FX markup for a customer segment
+//	setAccountSegment - move an account to a customer segment
+//	addTeller, removeTeller - manage the identities allowed to deposit funds
+//	authorizeInterbankSender, revokeInterbankSender - manage the banks allowed to credit accounts through the interbank contract
+//	listVostroAccounts - list the accounts other banks hold with this bank
+//	reconcileCorrespondent - compare what this bank and another bank each record the two owe each other
 type BankChaincode struct {
 }
 
@@ -48,16 +60,30 @@ type bank struct {
 	InterbankContract string `json:"interbankContract"`
 }
 
+// account is a bank account, stored on the ledger under its account number
+//Segment - the customer segment used to select the FX markup: retail, premium or corporate. Empty for accounts
+//	created before segments were introduced, which are treated as retail
 type account struct {
 	Name      string          `json:"name"`
 	AccNumber string          `json:"id"`
 	Balance   decimal.Decimal `json:"balance"`
 	Currency  string          `json:"currency"`
+	Segment   string          `json:"segment,omitempty"`
 }
 
+// forexPair is the rate returned by the ForexChaincode, ValidFor is zero for rates that never expire
 type forexPair struct {
-	Pair string  `json:"pair"`
-	Rate float64 `json:"rate"`
+	Pair      string          `json:"pair"`
+	Rate      decimal.Decimal `json:"rate"`
+	Timestamp int64           `json:"timestamp"`
+	ValidFor  int64           `json:"validFor"`
+}
+
+// forexQuote is a rate locked by the ForexChaincode for a single conversion
+type forexQuote struct {
+	ID   string          `json:"id"`
+	Pair string          `json:"pair"`
+	Rate decimal.Decimal `json:"rate"`
 }
 
 //Init method is run on chaincode installation and upgrade
@@ -66,6 +92,8 @@ type forexPair struct {
 //	ID					string		The institution ID of the bank, (e.g. IBAN, SWIFT or other routing code)
 //	ForexContract		string		The name of the contract that provides Forex services to this bank
 //  InterbankContract	string	The name of the contrat providing interbank transfer to this bank
+//	AdminMSP			string		Optional, the MSP ID of the organization that administers the bank's tellers. Defaults
+//									to the MSP of the identity instantiating the chaincode. Left unchanged on upgrade if omitted.
 func (s *BankChaincode) Init(stub shim.ChaincodeStubInterface) sc.Response {
 	args := stub.GetStringArgs()
 	if len(args) < 2 {
@@ -94,6 +122,29 @@ func (s *BankChaincode) Init(stub shim.ChaincodeStubInterface) sc.Response {
 		return shim.Error(err.Error())
 	}
 
+	currentAdmin, err := stub.GetState("adminMSP")
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	adminMSP := ""
+	if len(args) > 4 {
+		adminMSP = args[4]
+	} else if currentAdmin == nil {
+		adminMSP, _ = cid.GetMSPID(stub)
+	}
+
+	if adminMSP == "" {
+		return shim.Success(nil)
+	}
+
+	err = stub.PutState("adminMSP", []byte(adminMSP))
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -107,10 +158,36 @@ func (s *BankChaincode) Invoke(stub shim.ChaincodeStubInterface) sc.Response {
 		return s.queryAccount(stub, args)
 	} else if function == "transfer" {
 		return s.transfer(stub, args)
+	} else if function == "getTransfer" {
+		return s.getTransfer(stub, args)
+	} else if function == "interbankDebit" {
+		return s.interbankDebit(stub, args)
 	} else if function == "deposit" {
 		return s.deposit(stub, args)
+	} else if function == "receiveInterbank" {
+		return s.receiveInterbank(stub, args)
+	} else if function == "interbankRefund" {
+		return s.interbankRefund(stub, args)
 	} else if function == "getTransactionHistory" {
 		return s.getTransactionHistory(stub, args)
+	} else if function == "setRoundingPolicy" {
+		return s.setRoundingPolicy(stub, args)
+	} else if function == "setMarkupTier" {
+		return s.setMarkupTier(stub, args)
+	} else if function == "setAccountSegment" {
+		return s.setAccountSegment(stub, args)
+	} else if function == "addTeller" {
+		return s.addTeller(stub, args)
+	} else if function == "removeTeller" {
+		return s.removeTeller(stub, args)
+	} else if function == "authorizeInterbankSender" {
+		return s.authorizeInterbankSender(stub, args)
+	} else if function == "revokeInterbankSender" {
+		return s.revokeInterbankSender(stub, args)
+	} else if function == "listVostroAccounts" {
+		return s.listVostroAccounts(stub, args)
+	} else if function == "reconcileCorrespondent" {
+		return s.reconcileCorrespondent(stub, args)
 	}
 
 	return shim.Error("Invalid function")
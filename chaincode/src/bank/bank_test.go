@@ -20,17 +20,26 @@ import (
 	"encoding/json"
 	"forex"
 	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"testutil"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestQueryCustomer(t *testing.T) {
-	stub := shim.NewMockStub("TestStub", new(BankChaincode))
+	stub := testutil.NewMockStub("TestStub", new(BankChaincode))
+	stub.Creator = testutil.NewIdentity("Org1MSP", "operations")
 	uid := uuid.New().String()
 
+	initResponse := stub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
+	assert.EqualValues(t, shim.OK, initResponse.GetStatus(), initResponse.Message)
+
+	uid = uuid.New().String()
 	writeResponse := stub.MockInvoke(uid, [][]byte{[]byte("createAccount"),
 		[]byte("Bob Jones"), []byte("1"), []byte("400"), []byte("USD")})
 
@@ -51,16 +60,55 @@ func TestQueryCustomer(t *testing.T) {
 
 }
 
+func TestCreateAccountAuthorization(t *testing.T) {
+	stub := testutil.NewMockStub("TestStub", new(BankChaincode))
+	stub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+
+	response := stub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"addTeller", "Org1MSP", "teller"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//a customer can not open an account for themselves
+	stub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Bob Jones", "1", "400", "USD"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "account opened by a customer")
+
+	stub.Creator = testutil.NewIdentity("Org1MSP", "teller")
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Bob Jones", "1", "400", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//an existing account, the bank's configuration and its own accounts can not be overwritten
+	vostroKey, _ := stub.CreateCompositeKey("internalAccount", []string{vostroAccount, "0002", "USD"})
+	for _, accNum := range []string{"1", "bank", "adminMSP", vostroKey, ""} {
+		response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Mallory", accNum, "1000000", "USD"}))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "account "+accNum+" overwritten")
+	}
+
+	//nor can an account be opened overdrawn
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Mallory", "2", "-1000", "USD"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "account opened with a negative balance")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1"}))
+	acc := &account{}
+	json.Unmarshal(response.GetPayload(), acc)
+	assert.Equal(t, "Bob Jones", acc.Name, "account overwritten")
+	assert.Equal(t, "400", acc.Balance.String(), "account overwritten")
+}
+
 func TestTransfer(t *testing.T) {
 
 	fx := new(forex.ForexChaincode)
 	b1 := new(BankChaincode)
 
-	forexStub := shim.NewMockStub("forex", fx)
-	bankStub := shim.NewMockStub("bank", b1)
+	forexStub := testutil.NewMockStub("forex", fx)
+	bankStub := testutil.NewMockStub("bank", b1)
 
 	uid := uuid.New().String()
+	forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
 	response := forexStub.MockInit(uid, [][]byte{})
+	testutil.RegisterRateProvider(t, forexStub)
 
 	forexStub.MockPeerChaincode("bank", bankStub)
 	bankStub.MockPeerChaincode("forex", forexStub)
@@ -71,6 +119,7 @@ func TestTransfer(t *testing.T) {
 
 	uid = uuid.New().String()
 
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
 	response = bankStub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
 	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
 
@@ -125,3 +174,112 @@ func TestTransfer(t *testing.T) {
 	validateBalance, _ = decimal.NewFromString("12")
 	assert.Equal(t, validateBalance, resonseAccount.Balance, "incorrect balance")
 }
+
+func TestTransferStaleRate(t *testing.T) {
+	forexStub := testutil.NewMockStub("forex", new(forex.ForexChaincode))
+	bankStub := testutil.NewMockStub("bank", new(BankChaincode))
+	bankStub.MockPeerChaincode("forex", forexStub)
+
+	//a rate set two days ago with the default validity of one day
+	forexStub.State["GBP:USD"] = []byte(`{"pair":"GBP:USD","bid":"1.2","ask":"1.2","mid":"1.2","rate":"1.2","timestamp":` +
+		strconv.FormatInt(time.Now().Unix()-2*24*60*60, 10) + `,"validFor":86400}`)
+
+	uid := uuid.New().String()
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response := bankStub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, [][]byte{[]byte("createAccount"),
+		[]byte("Bob Jones"), []byte("1"), []byte("0"), []byte("USD")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, [][]byte{[]byte("createAccount"),
+		[]byte("Jim Smith"), []byte("2"), []byte("100"), []byte("GBP")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, [][]byte{[]byte("transfer"), []byte("2"), []byte("0001"), []byte("1"), []byte("10")})
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "transfer executed on a stale rate")
+	assert.Contains(t, response.Message, "Stale rate for GBP:USD", "unexpected error")
+}
+
+func TestTransferWithQuote(t *testing.T) {
+	forexStub := testutil.NewMockStub("forex", new(forex.ForexChaincode))
+	bankStub := testutil.NewMockStub("bank", new(BankChaincode))
+	bankStub.MockPeerChaincode("forex", forexStub)
+
+	forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
+	forexStub.MockInit(uuid.New().String(), [][]byte{})
+	testutil.RegisterRateProvider(t, forexStub)
+
+	response := forexStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("createUpdateForexPair"), []byte("GBP"), []byte("USD"), []byte("1.20")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = forexStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("authorizeQuoteConsumer"), []byte("bank")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	quoteID := uuid.New().String()
+	response = forexStub.MockInvoke(quoteID, [][]byte{[]byte("createQuote"), []byte("GBP"), []byte("USD"), []byte("0001"), []byte("2"), []byte("10")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//the rate moves after the customer has been quoted
+	response = forexStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("createUpdateForexPair"), []byte("GBP"), []byte("USD"), []byte("1.10")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = bankStub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = bankStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("createAccount"),
+		[]byte("Bob Jones"), []byte("1"), []byte("0"), []byte("USD")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = bankStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("createAccount"),
+		[]byte("Jim Smith"), []byte("2"), []byte("100"), []byte("GBP")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = bankStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("setMarkupTier"), []byte("retail"), []byte("1")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//the quote only covers 10 GBP from account 2
+	response = bankStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("transfer"), []byte("2"), []byte("0001"), []byte("1"), []byte("20"), []byte(quoteID)})
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "quote used for more than its maximum amount")
+
+	//the quoted rate is the rate offered to the customer, so no segment markup is taken, 10 GBP at 1.20 is 12 USD
+	transferID := uuid.New().String()
+	response = bankStub.MockInvoke(transferID, [][]byte{[]byte("transfer"), []byte("2"), []byte("0001"), []byte("1"), []byte("10"), []byte(quoteID)})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	toAccount := &account{}
+	err := json.Unmarshal(bankStub.State["1"], toAccount)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "12", toAccount.Balance.String(), "transfer not converted at the quoted rate")
+
+	incomeAccount, err := getInternalAccount(bankStub, fxIncomeAccount, "USD")
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Nil(t, incomeAccount, "markup taken from the quoted rate")
+
+	response = bankStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("getTransfer"), []byte(transferID)})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	record := &transferRecord{}
+	err = json.Unmarshal(response.GetPayload(), record)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, quoteID, record.QuoteID, "quote not recorded")
+	assert.Equal(t, "1.2", record.Rate.String(), "quoted rate not recorded")
+	assert.Equal(t, "0", record.Markup.String(), "markup recorded on the quoted rate")
+
+	response = bankStub.MockInvoke(uuid.New().String(), [][]byte{[]byte("transfer"), []byte("2"), []byte("0001"), []byte("1"), []byte("10"), []byte(quoteID)})
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "quote used twice")
+}
@@ -23,11 +23,44 @@ import (
 	"github.com/shopspring/decimal"
 )
 
-// deposit adds funds to an account, this is used to receive funds from an interbank transfer
+// interbankLeg is the part of an interbank payment booked by one bank on its path. The bank received Amount from the
+// previous bank, From, and keeps Fee. An intermediary forwards the rest to the next bank, To. The beneficiary's bank
+// credits the beneficiary's Account with Credit, the rest converted to the account currency, and the bank that
+// originated the payment must be authorized as an interbank sender
+type interbankLeg struct {
+	From       string          `json:"from"`
+	To         string          `json:"to,omitempty"`
+	Amount     decimal.Decimal `json:"amount"`
+	Fee        decimal.Decimal `json:"fee"`
+	Currency   string          `json:"currency"`
+	Originator string          `json:"originator,omitempty"`
+	Account    string          `json:"account,omitempty"`
+	Credit     decimal.Decimal `json:"credit"`
+}
+
+// refund returns the amount of a queued interbank payment that was cancelled or expired to the payer's Account. Bank
+// is the first bank on the payment's path, whose vostro account was credited when the payer was debited
+type refund struct {
+	Account  string          `json:"account"`
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"`
+	Bank     string          `json:"bank"`
+}
+
+// deposit adds funds to an account, only callable by a registered teller
 //args
-// 	acc 	string 	the account number to deposit funds to
-// 	amount 	string	the amount to deposit
+// 	acc 		string 	the account number to deposit funds to
+// 	amount 		string	the amount to deposit
 func (s *BankChaincode) deposit(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of args. Expecting 2: account and amount")
+	}
+
+	err := checkTeller(stub, false)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	accNum := args[0]
 	amount, err := decimal.NewFromString(args[1])
@@ -61,3 +94,213 @@ func (s *BankChaincode) deposit(stub shim.ChaincodeStubInterface, args []string)
 	return (shim.Success(nil))
 
 }
+
+// receiveInterbank books the legs of interbank payments that pass through or end at this bank. It is invoked by the
+// interbank contract when payments are released, once per transaction with every leg for this bank, as a chaincode
+// invoked twice in a transaction does not read its own writes. Each leg debits the vostro account of the bank it was
+// received from with the amount received and books the fee as fee income. An intermediary credits the rest to the
+// vostro account of the bank it forwards the payment to, the beneficiary's bank credits the beneficiary.
+// The transaction must have been submitted to this bank's interbank contract
+//args
+// 	legs 		string 	a JSON array of interbankLeg
+func (s *BankChaincode) receiveInterbank(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of args. Expecting the legs to book")
+	}
+
+	bankAsBytes, err := stub.GetState("bank")
+
+	if err != nil {
+		return shim.Error("Unable to retrieve bank from ledger " + err.Error())
+	}
+
+	thisBank := &bank{}
+	err = json.Unmarshal(bankAsBytes, thisBank)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve bank from ledger " + err.Error())
+	}
+
+	err = checkInterbankContract(stub, thisBank.InterbankContract)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	legs := []interbankLeg{}
+	err = json.Unmarshal([]byte(args[0]), &legs)
+
+	if err != nil {
+		return shim.Error("Unable to unmarshal legs " + err.Error())
+	}
+
+	//sum the postings to each account first, so that each account is written once
+	type internalKey struct{ accountType, bankID, currency string }
+	internal := map[internalKey]decimal.Decimal{}
+	internalOrder := []internalKey{}
+	post := func(key internalKey, amount decimal.Decimal) {
+		if _, found := internal[key]; !found {
+			internalOrder = append(internalOrder, key)
+		}
+		internal[key] = internal[key].Add(amount)
+	}
+
+	credits := map[string]decimal.Decimal{}
+	creditOrder := []string{}
+
+	for _, leg := range legs {
+		if leg.Amount.IsNegative() || leg.Fee.IsNegative() || leg.Fee.GreaterThan(leg.Amount) || leg.Credit.IsNegative() {
+			return shim.Error("Invalid leg from bank " + leg.From)
+		}
+
+		if (leg.To == "") == (leg.Account == "") {
+			return shim.Error("A leg must either be forwarded to another bank or credited to an account")
+		}
+
+		post(internalKey{vostroAccount, leg.From, leg.Currency}, leg.Amount.Neg())
+		post(internalKey{feeIncomeAccount, "", leg.Currency}, leg.Fee)
+
+		if leg.To != "" {
+			post(internalKey{vostroAccount, leg.To, leg.Currency}, leg.Amount.Sub(leg.Fee))
+			continue
+		}
+
+		err = checkInterbankSender(stub, leg.Originator)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		if _, found := credits[leg.Account]; !found {
+			creditOrder = append(creditOrder, leg.Account)
+		}
+		credits[leg.Account] = credits[leg.Account].Add(leg.Credit)
+	}
+
+	for _, accNum := range creditOrder {
+		accountAsByes := s.queryAccount(stub, []string{accNum}).Payload
+		acc := &account{}
+		err = json.Unmarshal(accountAsByes, acc)
+
+		if err != nil {
+			return shim.Error("Unable to retrieve account " + accNum + " from ledger " + err.Error())
+		}
+
+		acc.Balance = acc.Balance.Add(credits[accNum])
+
+		accAsBytes, _ := json.Marshal(acc)
+		err = stub.PutState(acc.AccNumber, accAsBytes)
+		if err != nil {
+			return shim.Error("Error trying to commit account to ledger" + err.Error())
+		}
+	}
+
+	for _, key := range internalOrder {
+		if key.accountType == vostroAccount {
+			err = postToVostro(stub, key.bankID, key.currency, internal[key])
+		} else {
+			err = creditInternalAccount(stub, "Correspondent fee income "+key.currency, key.currency, internal[key], feeIncomeAccount, key.currency)
+		}
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+// interbankRefund credits payers with the amounts of queued interbank payments that were cancelled or expired,
+// reversing interbankDebit. It is invoked by the interbank contract under its own authority, once per transaction
+// with every refund for this bank. The transaction must have been submitted to this bank's interbank contract
+//args
+// 	refunds 	string 	a JSON array of refund
+func (s *BankChaincode) interbankRefund(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of args. Expecting the refunds to make")
+	}
+
+	bankAsBytes, err := stub.GetState("bank")
+
+	if err != nil {
+		return shim.Error("Unable to retrieve bank from ledger " + err.Error())
+	}
+
+	thisBank := &bank{}
+	err = json.Unmarshal(bankAsBytes, thisBank)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve bank from ledger " + err.Error())
+	}
+
+	err = checkInterbankContract(stub, thisBank.InterbankContract)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	refunds := []refund{}
+	err = json.Unmarshal([]byte(args[0]), &refunds)
+
+	if err != nil {
+		return shim.Error("Unable to unmarshal refunds " + err.Error())
+	}
+
+	//sum the refunds to each account first, so that each account is written once
+	type vostroKey struct{ bankID, currency string }
+	accounts := map[string]*account{}
+	accountOrder := []string{}
+	vostros := map[vostroKey]decimal.Decimal{}
+	vostroOrder := []vostroKey{}
+
+	for _, refunded := range refunds {
+		if !refunded.Amount.IsPositive() {
+			return shim.Error("Refund to account " + refunded.Account + " must be a positive amount")
+		}
+
+		if _, found := accounts[refunded.Account]; !found {
+			accountAsByes := s.queryAccount(stub, []string{refunded.Account}).Payload
+			acc := &account{}
+			err = json.Unmarshal(accountAsByes, acc)
+
+			if err != nil {
+				return shim.Error("Unable to retrieve account " + refunded.Account + " from ledger " + err.Error())
+			}
+
+			accounts[refunded.Account] = acc
+			accountOrder = append(accountOrder, refunded.Account)
+		}
+
+		acc := accounts[refunded.Account]
+
+		if acc.Currency != refunded.Currency {
+			return shim.Error("Account " + acc.AccNumber + " holds " + acc.Currency + ", not " + refunded.Currency)
+		}
+
+		acc.Balance = acc.Balance.Add(refunded.Amount)
+
+		key := vostroKey{refunded.Bank, refunded.Currency}
+		if _, found := vostros[key]; !found {
+			vostroOrder = append(vostroOrder, key)
+		}
+		vostros[key] = vostros[key].Sub(refunded.Amount)
+	}
+
+	for _, accNum := range accountOrder {
+		accAsBytes, _ := json.Marshal(accounts[accNum])
+		err = stub.PutState(accNum, accAsBytes)
+		if err != nil {
+			return shim.Error("Error trying to commit account to ledger" + err.Error())
+		}
+	}
+
+	for _, key := range vostroOrder {
+		err = postToVostro(stub, key.bankID, key.currency, vostros[key])
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
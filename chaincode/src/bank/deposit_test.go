@@ -19,21 +19,28 @@ package bank
 import (
 	"encoding/json"
 	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"testutil"
 )
 
 func TestPay(t *testing.T) {
 
-	bankStub := shim.NewMockStub("bank", new(BankChaincode))
+	bankStub := testutil.NewMockStub("bank", new(BankChaincode))
 
 	uid := uuid.New().String()
 
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "teller")
 	response := bankStub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
 	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
 
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"addTeller", "Org1MSP", "teller"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
 	uid = uuid.New().String()
 	response = bankStub.MockInvoke(uid, [][]byte{[]byte("createAccount"),
 		[]byte("Bob Jones"), []byte("0001"), []byte("0"), []byte("USD")})
@@ -63,3 +70,56 @@ func TestPay(t *testing.T) {
 	validateBalance, _ := decimal.NewFromString("500")
 	assert.Equal(t, validateBalance, acc.Balance, "incorrect balance")
 }
+
+func TestDepositAuthorization(t *testing.T) {
+	bankStub := testutil.NewMockStub("bank", new(BankChaincode))
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+
+	response := bankStub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex"), []byte("ibank")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	for _, args := range [][]string{{"createAccount", "Bob Jones", "0001", "0", "USD"}, {"authorizeInterbankSender", "0002"}} {
+		response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	//neither the administrator nor another organization can deposit without being a teller
+	for _, creator := range [][]byte{testutil.NewIdentity("Org1MSP", "operations"), testutil.NewIdentity("Org2MSP", "customer")} {
+		bankStub.Creator = creator
+		response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"deposit", "0001", "500"}))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "deposit accepted from a caller who is not a teller")
+	}
+
+	//interbank credits and refunds are only accepted through the interbank contract, whoever signs them
+	for _, creator := range [][]byte{testutil.NewIdentity("Org3MSP", "attacker"), testutil.NewIdentity("Org2MSP", "customer")} {
+		bankStub.Creator = creator
+		legs := `[{"from":"0002","amount":"500","fee":"0","currency":"USD","originator":"0002","account":"0001","credit":"500"}]`
+		response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"receiveInterbank", legs}))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "interbank credit accepted outside the interbank contract")
+		assert.Equal(t, "Interbank payments must be made through the interbank contract ibank, not bank", response.Message, "unexpected error")
+
+		refunds := `[{"account":"0001","amount":"500","currency":"USD","bank":"0002"}]`
+		response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankRefund", refunds}))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "interbank refund accepted outside the interbank contract")
+	}
+
+	//a proposal whose invocation spec names the interbank contract does not pass for it, the endorser runs the
+	//chaincode named in the proposal header
+	for _, function := range []string{"receiveInterbank", "interbankRefund", "interbankDebit"} {
+		args := map[string][]string{
+			"receiveInterbank": {function, `[{"from":"0002","amount":"500","fee":"0","currency":"USD","originator":"0002","account":"0001","credit":"500"}]`},
+			"interbankRefund":  {function, `[{"account":"0001","amount":"500","currency":"USD","bank":"0002"}]`},
+			"interbankDebit":   {function, "0001", "500", "USD", "0002"},
+		}[function]
+
+		proposal := testutil.NewForgedProposal("bank", "ibank", util.ArrayToChaincodeArgs(args))
+		response = bankStub.MockInvokeWithProposal(uuid.New().String(), util.ArrayToChaincodeArgs(args), proposal)
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), function+" accepted from a proposal naming the interbank contract")
+		assert.Equal(t, "Interbank payments must be made through the interbank contract ibank, not bank", response.Message, "unexpected error")
+	}
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "0001"}))
+	acc := &account{}
+	json.Unmarshal(response.GetPayload(), acc)
+	assert.True(t, acc.Balance.IsZero(), "unauthorized deposit credited")
+}
@@ -0,0 +1,184 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	segmentRetail    = "retail"
+	segmentPremium   = "premium"
+	segmentCorporate = "corporate"
+)
+
+// fxIncomeAccount is the type of the bank's accounts that hold the FX markup earned in a currency, numbered by
+// currency code, e.g. FXINCOME-USD
+const fxIncomeAccount = "FXINCOME"
+
+// markupTiers is stored on the ledger under the key "markupTiers", it maps a customer segment to the markup,
+// as a percentage of the bid rate, taken on currency conversions. Segments without a tier have no markup.
+type markupTiers map[string]decimal.Decimal
+
+func validSegment(segment string) bool {
+	return segment == segmentRetail || segment == segmentPremium || segment == segmentCorporate
+}
+
+// segmentOf returns the customer segment of an account, accounts created before segments were introduced are retail
+func segmentOf(acc *account) string {
+	if acc.Segment == "" {
+		return segmentRetail
+	}
+	return acc.Segment
+}
+
+// setMarkupTier sets the FX markup for a customer segment, only the administrator MSP may set markups
+//Args:
+//	Segment string The customer segment: retail, premium or corporate
+//	Markup  string The markup as a percentage of the bid rate, e.g. "0.5" for 0.5%
+func (s *BankChaincode) setMarkupTier(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect arguments, expecting the customer segment and markup percentage")
+	}
+
+	if !validSegment(args[0]) {
+		return shim.Error("Unknown segment " + args[0] + ", expecting retail, premium or corporate")
+	}
+
+	markup, err := decimal.NewFromString(args[1])
+
+	if err != nil || markup.IsNegative() || markup.GreaterThanOrEqual(decimal.New(100, 0)) {
+		return shim.Error("Markup must be a percentage of at least zero and less than 100")
+	}
+
+	tiers, err := getMarkupTiers(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tiers[args[0]] = markup
+
+	tiersBytes, _ := json.Marshal(tiers)
+	err = stub.PutState("markupTiers", tiersBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit markup tiers to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getMarkupTiers returns the markup tiers on the ledger, empty if none have been set
+func getMarkupTiers(stub shim.ChaincodeStubInterface) (markupTiers, error) {
+	tiers := markupTiers{}
+
+	tiersBytes, err := stub.GetState("markupTiers")
+	if err != nil {
+		return nil, errors.New("Unable to retrieve markup tiers from ledger " + err.Error())
+	}
+
+	if tiersBytes == nil {
+		return tiers, nil
+	}
+
+	err = json.Unmarshal(tiersBytes, &tiers)
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal markup tiers " + err.Error())
+	}
+
+	return tiers, nil
+}
+
+// setAccountSegment moves an account to a customer segment, only the administrator MSP may change segments
+//Args:
+//	AccNumber string The account number
+//	Segment   string The customer segment: retail, premium or corporate
+func (s *BankChaincode) setAccountSegment(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect arguments, expecting the account number and customer segment")
+	}
+
+	if !validSegment(args[1]) {
+		return shim.Error("Unknown segment " + args[1] + ", expecting retail, premium or corporate")
+	}
+
+	if !validAccountNumber(args[0]) {
+		return shim.Error("Invalid account number " + args[0])
+	}
+
+	accountAsBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Unable to retrieve account from ledger " + err.Error())
+	}
+
+	if accountAsBytes == nil {
+		return shim.Error("Unknown account " + args[0])
+	}
+
+	acc := &account{}
+	err = json.Unmarshal(accountAsBytes, acc)
+	if err != nil {
+		return shim.Error("Unable to unmarshal account " + err.Error())
+	}
+
+	acc.Segment = args[1]
+
+	accountAsBytes, _ = json.Marshal(acc)
+	err = stub.PutState(args[0], accountAsBytes)
+	if err != nil {
+		return shim.Error("Error trying to commit account to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// applyMarkup returns the rate given to a customer in the segment, the bid rate less the segment's markup
+func applyMarkup(stub shim.ChaincodeStubInterface, midRate decimal.Decimal, segment string) (decimal.Decimal, error) {
+	tiers, err := getMarkupTiers(stub)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	markup, ok := tiers[segment]
+	if !ok {
+		return midRate, nil
+	}
+
+	return midRate.Mul(decimal.New(100, 0).Sub(markup)).Div(decimal.New(100, 0)), nil
+}
+
+// bookFXIncome adds the markup taken on a conversion to the FX income account for the currency, creating the
+// account if it does not yet exist
+func (s *BankChaincode) bookFXIncome(stub shim.ChaincodeStubInterface, currency string, markup decimal.Decimal) error {
+	return creditInternalAccount(stub, "FX Income "+currency, currency, markup, fxIncomeAccount, currency)
+}
@@ -0,0 +1,114 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package bank
+
+import (
+	"encoding/json"
+	"forex"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestTransferMarkup(t *testing.T) {
+	forexStub := testutil.NewMockStub("forex", new(forex.ForexChaincode))
+	bankStub := testutil.NewMockStub("bank", new(BankChaincode))
+	bankStub.MockPeerChaincode("forex", forexStub)
+
+	forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
+	forexStub.MockInit(uuid.New().String(), [][]byte{})
+	testutil.RegisterRateProvider(t, forexStub)
+
+	response := forexStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.19", "1.21"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = bankStub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	bankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	for _, args := range [][]string{{"setMarkupTier", "retail", "0"}, {"createAccount", "Acme Ltd", "3", "1000", "GBP", "corporate"}} {
+		response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), args[0]+" accepted from outside the administrator MSP")
+	}
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	for _, args := range [][]string{{"createAccount", "Bob Jones", "1", "0", "USD"}, {"createAccount", "Jim Smith", "2", "100", "GBP"},
+		{"createAccount", "Acme Ltd", "3", "1000", "GBP", "corporate"}, {"setMarkupTier", "retail", "1"}, {"setMarkupTier", "corporate", "0.25"}} {
+		response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Jane Doe", "4", "0", "GBP", "gold"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "unknown segment accepted")
+
+	bankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setAccountSegment", "2", "corporate"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "segment changed from outside the administrator MSP")
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+
+	//retail: 10 GBP at the 1.19 bid rate less 1% is 11.781 USD, credited as 11.78
+	transferID := uuid.New().String()
+	response = bankStub.MockInvoke(transferID, util.ArrayToChaincodeArgs([]string{"transfer", "2", "0001", "1", "10"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	event := &transferEvent{}
+	err := json.Unmarshal((<-bankStub.ChaincodeEventsChannel).Payload, event)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "1.1781", event.Rate, "customer rate not reported")
+	assert.Equal(t, "0.119", event.Markup, "markup not reported")
+
+	//the rate and markup are also kept on the ledger with the record of the transfer
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getTransfer", transferID}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	record := &transferRecord{}
+	err = json.Unmarshal(response.GetPayload(), record)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "1.1781", record.Rate.String(), "customer rate not recorded")
+	assert.Equal(t, "0.119", record.Markup.String(), "markup not recorded")
+	assert.Equal(t, "11.78", record.Credited.String(), "credited amount not recorded")
+
+	//corporate: 100 GBP at the 1.19 bid rate less 0.25% is 118.7025 USD, credited as 118.70
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"transfer", "3", "0001", "1", "100"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	toAccount := &account{}
+	err = json.Unmarshal(bankStub.State["1"], toAccount)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "130.48", toAccount.Balance.String(), "incorrect balance")
+
+	incomeAccount, err := getInternalAccount(bankStub, fxIncomeAccount, "USD")
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "0.4165", incomeAccount.Balance.String(), "incorrect FX income")
+}
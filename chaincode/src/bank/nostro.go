@@ -0,0 +1,186 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+// vostroAccount is the type of the accounts other banks hold with this bank, numbered by bank ID and currency code,
+// e.g. VOSTRO-0002-USD. Outgoing interbank transfers credit the vostro account of the recipient bank and incoming
+// payments debit the vostro account of the sending bank, so a positive balance is owed to the other bank and a
+// negative balance is owed by it
+const vostroAccount = "VOSTRO"
+
+// feeIncomeAccount is the type of the accounts holding the fees this bank keeps from interbank payments it receives
+// as a correspondent, numbered by currency code, e.g. FEEINCOME-USD
+const feeIncomeAccount = "FEEINCOME"
+
+// correspondentPosition compares this bank's books with another bank's
+//Vostro - the balance of the other bank's vostro account with this bank
+//Nostro - this bank's account with the other bank, the balance of its vostro account on the other bank's books
+//Difference - Vostro plus Nostro, zero when both banks agree on what they owe each other. Each bank posts a payment
+//	against the adjacent bank on its path, in the currency of the transfer, so the two sides only differ while a
+//	queued payment has been debited from the payer but not yet released
+type correspondentPosition struct {
+	BankID     string          `json:"bankID"`
+	Currency   string          `json:"currency"`
+	Vostro     decimal.Decimal `json:"vostro"`
+	Nostro     decimal.Decimal `json:"nostro"`
+	Difference decimal.Decimal `json:"difference"`
+}
+
+// postToVostro adds an amount to the account a bank holds with this bank, creating the account if it does not yet
+// exist. A negative amount debits the account
+func postToVostro(stub shim.ChaincodeStubInterface, bankID string, currency string, amount decimal.Decimal) error {
+	return creditInternalAccount(stub, "Vostro "+bankID, currency, amount, vostroAccount, bankID, currency)
+}
+
+//listVostroAccounts returns the accounts other banks hold with this bank
+//Args:
+//	BankID	string	optional, only return the accounts of this bank
+func (s *BankChaincode) listVostroAccounts(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) > 1 {
+		return shim.Error("Incorrect arguments, expecting at most a bank ID")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("internalAccount", append([]string{vostroAccount}, args...))
+
+	if err != nil {
+		return shim.Error("Unable to query vostro accounts " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	accounts := []account{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		acc := account{}
+		err = json.Unmarshal(queryResponse.Value, &acc)
+
+		if err != nil {
+			return shim.Error("Unable to unmarshal account " + err.Error())
+		}
+
+		accounts = append(accounts, acc)
+	}
+
+	accountsAsBytes, _ := json.Marshal(accounts)
+	return shim.Success(accountsAsBytes)
+}
+
+//reconcileCorrespondent compares the vostro account another bank holds with this bank against this bank's vostro
+//account on the other bank's books, its nostro account. The other bank's contract is found through the route
+//registered with the interbank contract
+//Args:
+//	BankID		string	the ID of the other bank
+//	Currency	string	the currency of the accounts
+func (s *BankChaincode) reconcileCorrespondent(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect arguments, expecting the bank ID and the currency")
+	}
+
+	bankAsBytes, err := stub.GetState("bank")
+
+	if err != nil {
+		return shim.Error("Unable to retrieve bank from ledger " + err.Error())
+	}
+
+	thisBank := &bank{}
+	err = json.Unmarshal(bankAsBytes, thisBank)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve bank from ledger " + err.Error())
+	}
+
+	if thisBank.InterbankContract == "" {
+		return shim.Error("Unable to reconcile with bank " + args[0] + " - no interbankchaincode provided")
+	}
+
+	vostro := decimal.Zero
+	vostroAcc, err := getInternalAccount(stub, vostroAccount, args[0], args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if vostroAcc != nil {
+		vostro = vostroAcc.Balance
+	}
+
+	bankContract, err := getBankContract(stub, thisBank.InterbankContract, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	stringArgs := []string{"listVostroAccounts", thisBank.ID}
+	response := stub.InvokeChaincode(bankContract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+	if response.Status != shim.OK {
+		return shim.Error("Unable to query nostro account at bank " + args[0] + " " + response.Message)
+	}
+
+	nostroAccounts := []account{}
+	err = json.Unmarshal(response.GetPayload(), &nostroAccounts)
+
+	if err != nil {
+		return shim.Error("Unable to unmarshal nostro accounts " + err.Error())
+	}
+
+	nostro := decimal.Zero
+	for _, acc := range nostroAccounts {
+		if acc.Currency == args[1] {
+			nostro = acc.Balance
+		}
+	}
+
+	position := correspondentPosition{BankID: args[0], Currency: args[1], Vostro: vostro, Nostro: nostro, Difference: vostro.Add(nostro)}
+	positionAsBytes, _ := json.Marshal(position)
+	return shim.Success(positionAsBytes)
+}
+
+// getBankContract returns the name of another bank's contract from its route in the interbank contract
+func getBankContract(stub shim.ChaincodeStubInterface, interbankContract string, bankID string) (string, error) {
+	stringArgs := []string{"getRoute", bankID}
+	response := stub.InvokeChaincode(interbankContract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+	if response.Status != shim.OK {
+		return "", errors.New("Unable to get route to bank " + bankID + " " + response.Message)
+	}
+
+	route := &struct {
+		BankContract string `json:"bankContract"`
+	}{}
+	err := json.Unmarshal(response.GetPayload(), route)
+
+	if err != nil {
+		return "", errors.New("Unable to unmarshal route " + err.Error())
+	}
+
+	return route.BankContract, nil
+}
@@ -0,0 +1,50 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package bank
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"rounding"
+)
+
+// setRoundingPolicy sets the rounding mode applied to currency conversions, only callable by the administrator MSP
+//Args:
+//	Mode string The rounding mode, one of half-even, half-up or truncate
+func (s *BankChaincode) setRoundingPolicy(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = rounding.PutPolicy(stub, args)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// bookRoundingResidual adds the difference between an exact converted amount and the amount actually credited
+// to the rounding account for the currency, kept with the bank's other internal accounts and booked the same way by
+// the interbank contract
+func (s *BankChaincode) bookRoundingResidual(stub shim.ChaincodeStubInterface, currency string, residual decimal.Decimal) error {
+	return rounding.BookResidual(stub, currency, residual)
+}
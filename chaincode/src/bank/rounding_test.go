@@ -0,0 +1,92 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package bank
+
+import (
+	"encoding/json"
+	"forex"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"rounding"
+	"testing"
+	"testutil"
+)
+
+func TestTransferRounding(t *testing.T) {
+	forexStub := testutil.NewMockStub("forex", new(forex.ForexChaincode))
+	bankStub := testutil.NewMockStub("bank", new(BankChaincode))
+	bankStub.MockPeerChaincode("forex", forexStub)
+
+	forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
+	forexStub.MockInit(uuid.New().String(), [][]byte{})
+	testutil.RegisterRateProvider(t, forexStub)
+
+	uid := uuid.New().String()
+	response := forexStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.2345"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	uid = uuid.New().String()
+	response = bankStub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"createAccount", "Bob Jones", "1", "0", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"createAccount", "Jim Smith", "2", "100", "GBP"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"setRoundingPolicy", "round-down"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "unknown rounding mode accepted")
+
+	bankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"setRoundingPolicy", "truncate"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "rounding policy set outside the administrator MSP")
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"setRoundingPolicy", "truncate"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//10.01 GBP at 1.2345 is 12.357345 USD, truncated to 12.35
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"transfer", "2", "0001", "1", "10.01"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	toAccount := &account{}
+	err := json.Unmarshal(bankStub.State["1"], toAccount)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "12.35", toAccount.Balance.String(), "incorrect balance")
+
+	residualAccount, err := getInternalAccount(bankStub, rounding.AccountType, "USD")
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "USD", residualAccount.Currency, "rounding account currency mismatch")
+	assert.Equal(t, "0.007345", residualAccount.Balance.String(), "incorrect rounding residual")
+	assert.Equal(t, "12.357345", toAccount.Balance.Add(residualAccount.Balance).String(), "totals do not reconcile")
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/hyperledger/fabric/common/util"
 	"interbank"
+	"testutil"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/shopspring/decimal"
@@ -21,15 +22,18 @@ func TestInterbankTransfer(t *testing.T) {
 
 	ibank := new(interbank.InterbankChaincode)
 
-	forexStub := shim.NewMockStub("forex", fx)
-	bankStub := shim.NewMockStub("bank", b1)
-	ibankStub := shim.NewMockStub("ibank", ibank)
-	bank2stub := shim.NewMockStub("bank2", b2)
+	forexStub := testutil.NewMockStub("forex", fx)
+	bankStub := testutil.NewMockStub("bank", b1)
+	ibankStub := testutil.NewMockStub("ibank", ibank)
+	bank2stub := testutil.NewMockStub("bank2", b2)
 
 	uid := uuid.New().String()
+	forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
 	response := forexStub.MockInit(uid, [][]byte{})
+	testutil.RegisterRateProvider(t, forexStub)
 
 	uid = uuid.New().String()
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
 	response = ibankStub.MockInit(uid, [][]byte{})
 
 	ibankStub.MockPeerChaincode("forex", forexStub)
@@ -52,13 +56,19 @@ func TestInterbankTransfer(t *testing.T) {
 	response = forexStub.MockInvoke(uid, args)
 	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
 
-	//create bank 1
+	//create bank 1, accepting credits from bank 2 through the interbank contract
 	uid = uuid.New().String()
-	response = bankStub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = bankStub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex"), []byte("ibank")})
 	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
 
-	//create bank 2
 	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"authorizeInterbankSender", "0002"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//create bank 2, administered by bank 2's MSP
+	uid = uuid.New().String()
+	bank2stub.Creator = testutil.NewIdentity("Org2MSP", "operations")
 	response = bank2stub.MockInit(uid, [][]byte{[]byte("Bank of Internet"), []byte("0002"), []byte("forex"), []byte("ibank")})
 	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
 
@@ -67,6 +77,7 @@ func TestInterbankTransfer(t *testing.T) {
 	stringArgs = []string{"createAccount", "Bob Jones", "1", "0", "USD"}
 
 	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs(stringArgs))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
 
 	//create account on bank 2
 	uid = uuid.New().String()
@@ -75,21 +86,40 @@ func TestInterbankTransfer(t *testing.T) {
 
 	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
 
+	//register route to bank
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	//the bank contract only transfers between its own accounts
 	uid = uuid.New().String()
-	response = bankStub.MockInvoke(uid, [][]byte{[]byte("queryAccount"), []byte("1")})
+	response = bank2stub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"transfer", "1234567", "0001", "1", "1000"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "interbank transfer made through the bank contract")
 
-	//register route to bank
+	//nor will it debit an account for an interbank transfer unless the interbank contract asks it to
 	uid = uuid.New().String()
-	response = ibankStub.MockInvoke(uid, [][]byte{[]byte("registerRoute"), []byte("0001"), []byte("bank"), []byte("forex")})
-	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
+	response = bank2stub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"interbankDebit", "1234567", "1000", "USD", "0001"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "account debited outside the interbank contract")
+
+	//perform transfer with the interbank contract, signed by a member of bank 2's MSP
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "operations")
 
-	//perform transfer
 	uid = uuid.New().String()
 
-	stringArgs = []string{"transfer", "1234567", "0001", "1", "1000"}
-	response = bank2stub.MockInvoke(uid, util.ArrayToChaincodeArgs(stringArgs))
+	stringArgs = []string{"interbankTransfer", "1", "0001", "1000", "USD", "0002", "1234567"}
+	response = ibankStub.MockInvoke(uid, util.ArrayToChaincodeArgs(stringArgs))
 	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
 
+	//the transfer event refers to the interbank contract's record of the transfer
+	event := &interbankTransferEvent{}
+	select {
+	case emitted := <-ibankStub.ChaincodeEventsChannel:
+		json.Unmarshal(emitted.Payload, event)
+	default:
+	}
+	assert.Equal(t, uid, event.InterbankTransferID, "interbank transfer record not referenced")
+
 	//Query To Account
 	uid = uuid.New().String()
 
@@ -107,4 +137,165 @@ func TestInterbankTransfer(t *testing.T) {
 
 	validateBalance, _ := decimal.NewFromString("1000")
 	assert.Equal(t, validateBalance, resonseAccount.Balance, "incorrect balance")
+
+	//the payer's account was debited
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1234567"}))
+	payerAccount := &account{}
+	json.Unmarshal(response.GetPayload(), payerAccount)
+	assert.Equal(t, "0", payerAccount.Balance.String(), "payer not debited")
+
+	//the receiving bank paid out of the sending bank's vostro account
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listVostroAccounts", "0002"}))
+	vostros := []account{}
+	json.Unmarshal(response.GetPayload(), &vostros)
+	if assert.Equal(t, 1, len(vostros), "vostro account not opened") {
+		assert.Equal(t, "-1000", vostros[0].Balance.String(), "vostro account not debited")
+	}
+
+	//the sending bank owes the receiving bank what it paid, and both banks agree
+	bank2stub.MockPeerChaincode("bank", bankStub)
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"reconcileCorrespondent", "0001", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	position := correspondentPosition{}
+	json.Unmarshal(response.GetPayload(), &position)
+	assert.Equal(t, "1000", position.Vostro.String(), "vostro account not credited")
+	assert.Equal(t, "-1000", position.Nostro.String(), "incorrect nostro balance")
+	assert.True(t, position.Difference.IsZero(), "banks do not reconcile")
+
+	//a transfer to an account that does not exist is rejected with a reason code
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Jane Blogs", "7654321", "50", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	stringArgs = []string{"interbankTransfer", "404", "0001", "50", "USD", "0002", "7654321"}
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(stringArgs))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "transfer to unknown account made")
+	assert.Contains(t, response.Message, "AC01", "reason code not reported")
+
+	//once returns are accepted the transfer succeeds and the funds stay with the payer
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setReturnPolicy", "return"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(stringArgs))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	event = &interbankTransferEvent{}
+	select {
+	case emitted := <-ibankStub.ChaincodeEventsChannel:
+		json.Unmarshal(emitted.Payload, event)
+	default:
+	}
+	assert.Equal(t, "returned", event.Status, "return not reported")
+	assert.Equal(t, "AC01", event.ReturnReasonCode, "reason code not reported")
+
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "7654321"}))
+	json.Unmarshal(response.GetPayload(), resonseAccount)
+	assert.Equal(t, "50", resonseAccount.Balance.String(), "returned funds not credited back")
+
+	//a payment through an intermediary that keeps a fee, converted for the payee, still reconciles between each pair
+	//of adjacent banks, each posting against its neighbour on the path in the currency of the transfer
+	bank3stub := testutil.NewMockStub("bank3", new(BankChaincode))
+	bank3stub.Creator = testutil.NewIdentity("Org3MSP", "operations")
+	response = bank3stub.MockInit(uuid.New().String(), [][]byte{[]byte("Third Bank"), []byte("0003"), []byte("forex"), []byte("ibank")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.MockPeerChaincode("bank3", bank3stub)
+	bank2stub.MockPeerChaincode("bank3", bank3stub)
+	bank3stub.MockPeerChaincode("ibank", ibankStub)
+	bank3stub.MockPeerChaincode("bank", bankStub)
+	testutil.RegisterRoute(t, ibankStub, "0003", "bank3", "forex", "Org3MSP")
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Bob Jones", "2", "0", "GBP"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	bank2stub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Joe Blogs", "2222222", "100", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	for _, fee := range [][]string{{"0002", "0001", "5"}, {"0002", "0003", "0.5"}, {"0003", "0001", "0.1"}} {
+		response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(append([]string{"setCorrespondent"}, fee...)))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "2", "0001", "100", "USD", "0002", "2222222"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "2"}))
+	json.Unmarshal(response.GetPayload(), resonseAccount)
+	assert.Equal(t, "79.52", resonseAccount.Balance.String(), "incorrect converted amount credited")
+
+	positions := []struct {
+		stub     *testutil.MockStub
+		bankID   string
+		expected string
+	}{
+		{bank2stub, "0003", "100"},
+		{bank3stub, "0001", "99.5"},
+		{bank2stub, "0001", "1000"},
+	}
+
+	for _, expected := range positions {
+		response = expected.stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"reconcileCorrespondent", expected.bankID, "USD"}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		position = correspondentPosition{}
+		json.Unmarshal(response.GetPayload(), &position)
+		assert.Equal(t, expected.expected, position.Vostro.String(), "incorrect vostro balance")
+		assert.True(t, position.Difference.IsZero(), "banks do not reconcile")
+	}
+
+	//cancelling a queued payment refunds the payer and reverses the sending bank's vostro entry
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	for _, args := range [][]string{{"setSettlementMode", "deferred", "centralbank"}, {"setBilateralLimit", "0002", "0003", "USD", "10"}} {
+		response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	bank2stub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createAccount", "Joe Blogs", "3333333", "50", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "50", "USD", "0002", "3333333"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	queued := struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}{}
+	json.Unmarshal(response.GetPayload(), &queued)
+	assert.Equal(t, "queued", queued.Status, "payment over the limit not queued")
+
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "3333333"}))
+	json.Unmarshal(response.GetPayload(), payerAccount)
+	assert.Equal(t, "0", payerAccount.Balance.String(), "queued payment not debited")
+
+	//only the interbank contract can refund the payer
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankRefund",
+		`[{"account":"3333333","amount":"50","currency":"USD","bank":"0003"}]`}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "payer refunded outside the interbank contract")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"cancelQueuedPayment", queued.ID}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "3333333"}))
+	json.Unmarshal(response.GetPayload(), payerAccount)
+	assert.Equal(t, "50", payerAccount.Balance.String(), "cancelled payment not refunded")
+
+	response = bank2stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"reconcileCorrespondent", "0003", "USD"}))
+	position = correspondentPosition{}
+	json.Unmarshal(response.GetPayload(), &position)
+	assert.Equal(t, "100", position.Vostro.String(), "vostro entry not reversed")
+	assert.True(t, position.Difference.IsZero(), "banks do not reconcile")
+}
+
+// interbankTransferEvent is the transfer-event the interbank contract emits for an interbank transfer
+type interbankTransferEvent struct {
+	InterbankTransferID string `json:"InterbankTransferID"`
+	Status              string `json:"Status"`
+	ReturnReasonCode    string `json:"ReturnReasonCode"`
 }
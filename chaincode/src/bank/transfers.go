@@ -0,0 +1,91 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+// transferRecord is kept for every transfer between accounts at the bank, stored under the composite key
+// transfer~ID where ID is the ID of the transaction that made the transfer
+//Amount, Currency - the amount debited from the payer, in the currency of the payer's account
+//Credited, CreditedCurrency - the amount credited to the payee, in the currency of the payee's account
+//Rate - the rate given to the payer, after the markup, 1 for a transfer in one currency
+//Markup - the markup booked to FX income, in the payee's currency. A quoted conversion carries no markup
+//QuoteID - the ID of the quote the conversion used
+type transferRecord struct {
+	ID               string          `json:"id"`
+	FromAccNumber    string          `json:"fromAccNumber"`
+	ToAccNumber      string          `json:"toAccNumber"`
+	Amount           decimal.Decimal `json:"amount"`
+	Currency         string          `json:"currency"`
+	Credited         decimal.Decimal `json:"credited"`
+	CreditedCurrency string          `json:"creditedCurrency"`
+	Rate             decimal.Decimal `json:"rate"`
+	Markup           decimal.Decimal `json:"markup"`
+	QuoteID          string          `json:"quoteID,omitempty"`
+	CreatedAt        int64           `json:"createdAt"`
+}
+
+//getTransfer returns the record of a transfer between accounts at the bank
+//Args:
+//	ID	string	the ID of the transaction that made the transfer
+func (s *BankChaincode) getTransfer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting the transfer ID")
+	}
+
+	transferKey, err := stub.CreateCompositeKey("transfer", []string{args[0]})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	recordAsBytes, err := stub.GetState(transferKey)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve transfer from ledger " + err.Error())
+	}
+
+	if recordAsBytes == nil {
+		return shim.Error("Transfer " + args[0] + " does not exist")
+	}
+
+	return shim.Success(recordAsBytes)
+}
+
+// putTransferRecord stores the record of a transfer under the ID of the transaction making it
+func putTransferRecord(stub shim.ChaincodeStubInterface, record *transferRecord) error {
+	transferKey, err := stub.CreateCompositeKey("transfer", []string{record.ID})
+
+	if err != nil {
+		return err
+	}
+
+	recordAsBytes, _ := json.Marshal(record)
+	err = stub.PutState(transferKey, recordAsBytes)
+
+	if err != nil {
+		return errors.New("Unable to commit transfer to ledger " + err.Error())
+	}
+
+	return nil
+}
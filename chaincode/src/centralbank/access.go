@@ -0,0 +1,151 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package centralbank
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// caller is the identity invoking a transaction
+//MSPID - the MSP ID of the caller's organization
+//Name - the common name of the caller's certificate
+type caller struct {
+	MSPID string
+	Name  string
+}
+
+func (c *caller) key() string {
+	return c.MSPID + "/" + c.Name
+}
+
+// getCaller returns the identity invoking the transaction
+func getCaller(stub shim.ChaincodeStubInterface) (*caller, error) {
+	identity, err := cid.New(stub)
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	mspID, err := identity.GetMSPID()
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	cert, err := identity.GetX509Certificate()
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	return &caller{MSPID: mspID, Name: cert.Subject.CommonName}, nil
+}
+
+// checkAdmin returns an error unless the caller belongs to the central bank's administrator MSP
+func checkAdmin(stub shim.ChaincodeStubInterface) error {
+	adminMSP, err := stub.GetState("adminMSP")
+
+	if err != nil {
+		return errors.New("Unable to retrieve administrator from ledger " + err.Error())
+	}
+
+	if adminMSP == nil {
+		return errors.New("No administrator MSP has been set, instantiate the chaincode with the administrator MSP ID")
+	}
+
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return err
+	}
+
+	if invoker.MSPID != string(adminMSP) {
+		return errors.New(invoker.key() + " is not a member of the administrator MSP")
+	}
+
+	return nil
+}
+
+func hasKey(stub shim.ChaincodeStubInterface, objectType string, attributes []string) (bool, error) {
+	key, err := stub.CreateCompositeKey(objectType, attributes)
+
+	if err != nil {
+		return false, err
+	}
+
+	value, err := stub.GetState(key)
+
+	if err != nil {
+		return false, errors.New("Unable to retrieve " + objectType + " from ledger " + err.Error())
+	}
+
+	return value != nil, nil
+}
+
+// setKey writes, or with remove set deletes, an authorization after checking the caller is an administrator
+func setKey(stub shim.ChaincodeStubInterface, objectType string, attributes []string, remove bool) sc.Response {
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(objectType, attributes)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if remove {
+		err = stub.DelState(key)
+	} else {
+		err = stub.PutState(key, []byte{0x00})
+	}
+
+	if err != nil {
+		return shim.Error("Unable to commit " + objectType + " to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// authorizeSettlementAgent lets members of an MSP settle payments that debit any bank's reserves, such as the
+// governance organization of an interbank contract releasing queued payments. Only callable by the administrator MSP
+//Args:
+//	MSPID string The MSP ID of the settlement agent's organization
+func (s *CentralBankChaincode) authorizeSettlementAgent(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect arguments, expecting the MSP ID of the settlement agent")
+	}
+
+	return setKey(stub, "settlementAgent", args, false)
+}
+
+// revokeSettlementAgent stops members of an MSP settling payments on behalf of other banks, only callable by the
+// administrator MSP
+//Args:
+//	MSPID string The MSP ID of the settlement agent's organization
+func (s *CentralBankChaincode) revokeSettlementAgent(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect arguments, expecting the MSP ID of the settlement agent")
+	}
+
+	return setKey(stub, "settlementAgent", args, true)
+}
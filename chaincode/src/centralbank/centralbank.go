@@ -0,0 +1,108 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package centralbank
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+//CentralBankChaincode is the struct that all chaincode methods are associated with
+//The central bank chaincode holds the reserve accounts of the banks on the network and moves reserves between them
+//to settle interbank payments. There are several functions:
+//	init - initialize the chaincode
+//	invoke - called upon invocation and calls other functions
+//	openReserveAccount - open a reserve account for a bank in a currency
+//	fundReserve, withdrawReserve - add reserves to, or take them out of, a bank's reserve account
+//	authorizeSettlementAgent, revokeSettlementAgent - manage the MSPs allowed to settle on behalf of any bank
+//	settle - move reserves between banks, all or nothing
+//	getReserve, listReserves - return reserve accounts and their balances
+//	getSettlement - return a settlement by its reference
+type CentralBankChaincode struct {
+}
+
+// reserve is the account a bank holds at the central bank in a currency, stored under the composite key
+// reserve~BankID~Currency
+//BankMSP - the MSP ID of the bank's organization, whose members may settle payments out of the account
+type reserve struct {
+	BankID   string          `json:"bankID"`
+	Currency string          `json:"currency"`
+	Balance  decimal.Decimal `json:"balance"`
+	BankMSP  string          `json:"bankMSP"`
+}
+
+//Init method is run on chaincode installation and upgrade
+//Args:
+//	AdminMSP	string	Optional, the MSP ID of the central bank's organization, which opens and funds reserve accounts.
+//						Defaults to the MSP of the identity instantiating the chaincode. Left unchanged on upgrade if omitted.
+func (s *CentralBankChaincode) Init(stub shim.ChaincodeStubInterface) sc.Response {
+	args := stub.GetStringArgs()
+
+	currentAdmin, err := stub.GetState("adminMSP")
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	adminMSP := ""
+	if len(args) > 0 {
+		adminMSP = args[0]
+	} else if currentAdmin == nil {
+		adminMSP, _ = cid.GetMSPID(stub)
+	}
+
+	if adminMSP == "" {
+		return shim.Success(nil)
+	}
+
+	err = stub.PutState("adminMSP", []byte(adminMSP))
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//Invoke is called when external applications invoke the smart contract
+func (s *CentralBankChaincode) Invoke(stub shim.ChaincodeStubInterface) sc.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	if function == "openReserveAccount" {
+		return s.openReserveAccount(stub, args)
+	} else if function == "fundReserve" {
+		return s.fundReserve(stub, args)
+	} else if function == "withdrawReserve" {
+		return s.withdrawReserve(stub, args)
+	} else if function == "authorizeSettlementAgent" {
+		return s.authorizeSettlementAgent(stub, args)
+	} else if function == "revokeSettlementAgent" {
+		return s.revokeSettlementAgent(stub, args)
+	} else if function == "settle" {
+		return s.settle(stub, args)
+	} else if function == "getReserve" {
+		return s.getReserve(stub, args)
+	} else if function == "listReserves" {
+		return s.listReserves(stub, args)
+	} else if function == "getSettlement" {
+		return s.getSettlement(stub, args)
+	}
+
+	return shim.Error("Invalid function")
+}
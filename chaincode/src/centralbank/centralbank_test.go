@@ -0,0 +1,125 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package centralbank
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestReserveAccounts(t *testing.T) {
+	stub := testutil.NewMockStub("centralbank", new(CentralBankChaincode))
+	stub.Creator = testutil.NewIdentity("CentralMSP", "reserves")
+	stub.MockInit(uuid.New().String(), [][]byte{})
+
+	for _, args := range [][]string{{"openReserveAccount", "0001", "Org1MSP", "USD"}, {"openReserveAccount", "0002", "Org2MSP", "USD"},
+		{"fundReserve", "0001", "USD", "500"}, {"fundReserve", "0002", "USD", "100"}, {"withdrawReserve", "0001", "USD", "100"}} {
+		response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"openReserveAccount", "0001", "Org1MSP", "USD"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "reserve account opened twice")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"withdrawReserve", "0002", "USD", "101"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "reserves overdrawn")
+
+	//only the central bank funds reserves
+	stub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"fundReserve", "0001", "USD", "1000"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "reserves funded by a bank")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getReserve", "0001", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	account := reserve{}
+	json.Unmarshal(response.GetPayload(), &account)
+	assert.Equal(t, "400", account.Balance.String(), "incorrect reserve balance")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getReserve", "0001", "EUR"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "unknown reserve account returned")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listReserves", "0002"}))
+	reserves := []reserve{}
+	json.Unmarshal(response.GetPayload(), &reserves)
+	assert.Equal(t, 1, len(reserves), "incorrect reserve accounts")
+	assert.Equal(t, "100", reserves[0].Balance.String(), "incorrect reserve balance")
+}
+
+func TestSettle(t *testing.T) {
+	stub := testutil.NewMockStub("centralbank", new(CentralBankChaincode))
+	stub.Creator = testutil.NewIdentity("CentralMSP", "reserves")
+	stub.MockInit(uuid.New().String(), [][]byte{})
+
+	for _, args := range [][]string{{"openReserveAccount", "0001", "Org1MSP", "USD"}, {"openReserveAccount", "0002", "Org2MSP", "USD"},
+		{"openReserveAccount", "0003", "Org3MSP", "USD"}, {"fundReserve", "0001", "USD", "100"}, {"authorizeSettlementAgent", "GovMSP"}} {
+		response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	settle := func(reference string, movements string) sc.Response {
+		return stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"settle", reference, movements}))
+	}
+
+	balances := func() []string {
+		response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listReserves"}))
+		reserves := []reserve{}
+		json.Unmarshal(response.GetPayload(), &reserves)
+
+		result := []string{}
+		for _, account := range reserves {
+			result = append(result, account.Balance.String())
+		}
+		return result
+	}
+
+	//a bank cannot settle out of another bank's reserves
+	stub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response := settle("1", `[{"from":"0001","to":"0002","currency":"USD","amount":"10"}]`)
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "settled out of another bank's reserves")
+
+	//the payment through 0002 leaves 0002 with its fee, only 0001's reserves fall
+	stub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = settle("1", `[{"from":"0001","to":"0002","currency":"USD","amount":"80"},{"from":"0002","to":"0003","currency":"USD","amount":"79"}]`)
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	assert.Equal(t, []string{"20", "1", "79"}, balances(), "incorrect reserves after settlement")
+
+	response = settle("1", `[{"from":"0001","to":"0002","currency":"USD","amount":"1"}]`)
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "reference settled twice")
+
+	//nothing moves if any bank cannot pay
+	stub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = settle("2", `[{"from":"0003","to":"0001","currency":"USD","amount":"50"},{"from":"0001","to":"0002","currency":"USD","amount":"100"}]`)
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "reserves overdrawn")
+	assert.Equal(t, []string{"20", "1", "79"}, balances(), "reserves moved by a failed settlement")
+
+	response = settle("2", `[{"from":"0003","to":"0001","currency":"USD","amount":"50"},{"from":"0001","to":"0002","currency":"USD","amount":"60"}]`)
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	assert.Equal(t, []string{"10", "61", "29"}, balances(), "incorrect reserves after settlement")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getSettlement", "2"}))
+	record := settlement{}
+	json.Unmarshal(response.GetPayload(), &record)
+	assert.Equal(t, 2, len(record.Movements), "incorrect settlement")
+	assert.Equal(t, "GovMSP/governance", record.SettledBy, "incorrect settlement")
+}
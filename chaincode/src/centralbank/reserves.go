@@ -0,0 +1,230 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package centralbank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+//openReserveAccount opens a reserve account for a bank in a currency with a zero balance. Only callable by the
+//administrator MSP
+//Args:
+//	BankID		string	the ID of the bank, as used by the interbank contract
+//	BankMSP		string	the MSP ID of the bank's organization
+//	Currency	string	the currency of the account
+func (s *CentralBankChaincode) openReserveAccount(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect arguments, expecting the bank ID, the bank's MSP ID and the currency")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, reserveKey, err := getReserveAccount(stub, args[0], args[2])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if existing != nil {
+		return shim.Error("Bank " + args[0] + " already has a reserve account in " + args[2])
+	}
+
+	reserveAsBytes, _ := json.Marshal(reserve{BankID: args[0], BankMSP: args[1], Currency: args[2], Balance: decimal.Zero})
+	err = stub.PutState(reserveKey, reserveAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit reserve account to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//fundReserve credits a bank's reserve account, for example when the bank deposits funds or borrows from the central
+//bank. Only callable by the administrator MSP
+//Args:
+//	BankID		string	the ID of the bank
+//	Currency	string	the currency of the account
+//	Amount		string	the amount to credit
+func (s *CentralBankChaincode) fundReserve(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return s.adjustReserve(stub, args, 1)
+}
+
+//withdrawReserve debits a bank's reserve account, refusing to take the balance below zero. Only callable by the
+//administrator MSP
+//Args:
+//	BankID		string	the ID of the bank
+//	Currency	string	the currency of the account
+//	Amount		string	the amount to debit
+func (s *CentralBankChaincode) withdrawReserve(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return s.adjustReserve(stub, args, -1)
+}
+
+// adjustReserve adds an amount to a reserve account, or takes it out when sign is -1
+func (s *CentralBankChaincode) adjustReserve(stub shim.ChaincodeStubInterface, args []string, sign int64) sc.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect arguments, expecting the bank ID, the currency and the amount")
+	}
+
+	amount, err := parseAmount(args[2])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	account, reserveKey, err := getReserveAccount(stub, args[0], args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if account == nil {
+		return shim.Error("Bank " + args[0] + " has no reserve account in " + args[1])
+	}
+
+	account.Balance = account.Balance.Add(amount.Mul(decimal.New(sign, 0)))
+
+	if account.Balance.IsNegative() {
+		return shim.Error("Insufficient reserves, bank " + args[0] + " holds " + account.Balance.Add(amount).String() + " " + args[1])
+	}
+
+	reserveAsBytes, _ := json.Marshal(account)
+	err = stub.PutState(reserveKey, reserveAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit reserve account to ledger " + err.Error())
+	}
+
+	return shim.Success(reserveAsBytes)
+}
+
+//getReserve returns a bank's reserve account in a currency
+//Args:
+//	BankID		string	the ID of the bank
+//	Currency	string	the currency of the account
+func (s *CentralBankChaincode) getReserve(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect arguments, expecting the bank ID and the currency")
+	}
+
+	account, _, err := getReserveAccount(stub, args[0], args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if account == nil {
+		return shim.Error("Bank " + args[0] + " has no reserve account in " + args[1])
+	}
+
+	reserveAsBytes, _ := json.Marshal(account)
+	return shim.Success(reserveAsBytes)
+}
+
+//listReserves returns reserve accounts with their balances
+//Args:
+//	BankID	string	optional, only return the accounts of this bank
+func (s *CentralBankChaincode) listReserves(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) > 1 {
+		return shim.Error("Incorrect arguments, expecting at most a bank ID")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("reserve", args)
+
+	if err != nil {
+		return shim.Error("Unable to query reserve accounts " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	reserves := []reserve{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		account := reserve{}
+		err = json.Unmarshal(queryResponse.Value, &account)
+
+		if err != nil {
+			return shim.Error("Unable to unmarshal reserve account " + err.Error())
+		}
+
+		reserves = append(reserves, account)
+	}
+
+	reservesAsBytes, _ := json.Marshal(reserves)
+	return shim.Success(reservesAsBytes)
+}
+
+// getReserveAccount returns a bank's reserve account and its key, or nil if the bank has no account in the currency
+func getReserveAccount(stub shim.ChaincodeStubInterface, bankID string, currency string) (*reserve, string, error) {
+	reserveKey, err := stub.CreateCompositeKey("reserve", []string{bankID, currency})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	reserveAsBytes, err := stub.GetState(reserveKey)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to retrieve reserve account from ledger " + err.Error())
+	}
+
+	if reserveAsBytes == nil {
+		return nil, reserveKey, nil
+	}
+
+	account := &reserve{}
+	err = json.Unmarshal(reserveAsBytes, account)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to unmarshal reserve account " + err.Error())
+	}
+
+	return account, reserveKey, nil
+}
+
+// parseAmount returns an amount of reserves, which must be positive
+func parseAmount(value string) (decimal.Decimal, error) {
+	amount, err := decimal.NewFromString(value)
+
+	if err != nil {
+		return decimal.Zero, errors.New("Invalid amount " + err.Error())
+	}
+
+	if !amount.IsPositive() {
+		return decimal.Zero, errors.New("Amount must be a positive number")
+	}
+
+	return amount, nil
+}
@@ -0,0 +1,229 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package centralbank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+// movement moves reserves from one bank to another
+type movement struct {
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Currency string          `json:"currency"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+// settlement records the movements settled together under a reference, stored under the composite key
+// settlement~Reference so a reference can only be settled once
+//SettledBy - the identity that signed the settlement, as MSPID/common name
+type settlement struct {
+	Reference string     `json:"reference"`
+	Movements []movement `json:"movements"`
+	SettledAt int64      `json:"settledAt"`
+	SettledBy string     `json:"settledBy"`
+}
+
+//settle moves reserves between banks. Either every movement is made or none are. The movements are netted per bank
+//and currency, and every bank whose reserves fall must belong to the caller's MSP unless the caller is a settlement
+//agent. A settlement that would overdraw any reserve account is refused
+//Args:
+//	Reference	string	a unique reference for the settlement, such as the ID of the interbank transfer
+//	Movements	string	a JSON array of movements, each with from, to, currency and amount
+func (s *CentralBankChaincode) settle(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect arguments, expecting a reference and a JSON array of movements")
+	}
+
+	movements := []movement{}
+	err := json.Unmarshal([]byte(args[1]), &movements)
+
+	if err != nil {
+		return shim.Error("Unable to unmarshal movements " + err.Error())
+	}
+
+	if len(movements) == 0 {
+		return shim.Error("No movements to settle")
+	}
+
+	for _, moved := range movements {
+		if moved.From == moved.To {
+			return shim.Error("Bank " + moved.From + " cannot settle with itself")
+		}
+
+		if !moved.Amount.IsPositive() {
+			return shim.Error("Amount moved from bank " + moved.From + " to bank " + moved.To + " must be a positive number")
+		}
+	}
+
+	settlementKey, err := stub.CreateCompositeKey("settlement", []string{args[0]})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, err := stub.GetState(settlementKey)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve settlement from ledger " + err.Error())
+	}
+
+	if existing != nil {
+		return shim.Error("Settlement " + args[0] + " has already been made")
+	}
+
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	agent, err := hasKey(stub, "settlementAgent", []string{invoker.MSPID})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//net the movements so each reserve account is read and written once
+	accounts := map[string]*reserve{}
+	net := map[string]decimal.Decimal{}
+	keys := []string{}
+
+	for _, moved := range movements {
+		for _, leg := range []struct {
+			bankID string
+			amount decimal.Decimal
+		}{{moved.From, moved.Amount.Neg()}, {moved.To, moved.Amount}} {
+			account, reserveKey, err := getReserveAccount(stub, leg.bankID, moved.Currency)
+
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			if account == nil {
+				return shim.Error("Bank " + leg.bankID + " has no reserve account in " + moved.Currency)
+			}
+
+			if _, found := accounts[reserveKey]; !found {
+				accounts[reserveKey] = account
+				keys = append(keys, reserveKey)
+			}
+
+			net[reserveKey] = net[reserveKey].Add(leg.amount)
+		}
+	}
+
+	for _, reserveKey := range keys {
+		account := accounts[reserveKey]
+
+		if !net[reserveKey].IsNegative() {
+			continue
+		}
+
+		if !agent && account.BankMSP != invoker.MSPID {
+			return shim.Error(invoker.key() + " cannot settle out of the reserves of bank " + account.BankID)
+		}
+
+		if account.Balance.Add(net[reserveKey]).IsNegative() {
+			return shim.Error("Insufficient reserves, bank " + account.BankID + " holds " + account.Balance.String() + " " +
+				account.Currency + " and must pay " + net[reserveKey].Neg().String())
+		}
+	}
+
+	for _, reserveKey := range keys {
+		account := accounts[reserveKey]
+
+		if net[reserveKey].IsZero() {
+			continue
+		}
+
+		account.Balance = account.Balance.Add(net[reserveKey])
+
+		reserveAsBytes, _ := json.Marshal(account)
+		err = stub.PutState(reserveKey, reserveAsBytes)
+
+		if err != nil {
+			return shim.Error("Unable to commit reserve account to ledger " + err.Error())
+		}
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	record := settlement{Reference: args[0], Movements: movements, SettledAt: timestamp.GetSeconds(), SettledBy: invoker.key()}
+	recordAsBytes, _ := json.Marshal(record)
+	err = stub.PutState(settlementKey, recordAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit settlement to ledger " + err.Error())
+	}
+
+	return shim.Success(recordAsBytes)
+}
+
+//getSettlement returns the movements settled under a reference
+//Args:
+//	Reference	string	the reference given to settle
+func (s *CentralBankChaincode) getSettlement(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect arguments, expecting the settlement reference")
+	}
+
+	record, err := getSettlementRecord(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	recordAsBytes, _ := json.Marshal(record)
+	return shim.Success(recordAsBytes)
+}
+
+// getSettlementRecord reads a settlement from the ledger
+func getSettlementRecord(stub shim.ChaincodeStubInterface, reference string) (*settlement, error) {
+	settlementKey, err := stub.CreateCompositeKey("settlement", []string{reference})
+
+	if err != nil {
+		return nil, err
+	}
+
+	recordAsBytes, err := stub.GetState(settlementKey)
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve settlement from ledger " + err.Error())
+	}
+
+	if recordAsBytes == nil {
+		return nil, errors.New("Unknown settlement " + reference)
+	}
+
+	record := &settlement{}
+	err = json.Unmarshal(recordAsBytes, record)
+
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal settlement " + err.Error())
+	}
+
+	return record, nil
+}
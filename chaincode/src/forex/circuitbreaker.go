@@ -0,0 +1,425 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// circuitBreaker limits how far the mid rate of a pair can move in a single update, stored under the
+// composite key circuitBreaker~Pair
+//MaxChange - the maximum change, as a percentage of the current mid rate, that is published without approval
+type circuitBreaker struct {
+	Pair      string          `json:"pair"`
+	MaxChange decimal.Decimal `json:"maxChange"`
+}
+
+// pendingRate is an update that tripped the circuit breaker of a pair, stored under the composite key
+// pendingRate~Pair until it is approved or rejected. A pair has at most one pending rate, a later update
+// that trips the breaker replaces it.
+//PreviousMid - the mid rate on the ledger when the update was parked
+//Change - the change in the mid rate, as a percentage of the previous mid rate
+//SubmittedBy - the provider whose submission produced the update, who cannot approve it
+type pendingRate struct {
+	Pair        string          `json:"pair"`
+	Bid         decimal.Decimal `json:"bid"`
+	Ask         decimal.Decimal `json:"ask"`
+	PreviousMid decimal.Decimal `json:"previousMid"`
+	Change      decimal.Decimal `json:"change"`
+	ValidFor    int64           `json:"validFor"`
+	Timestamp   int64           `json:"timestamp"`
+	SubmittedBy rateProvider    `json:"submittedBy"`
+}
+
+// suspension marks a pair as suspended, stored under the composite key suspendedPair~Pair
+type suspension struct {
+	Pair      string `json:"pair"`
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// setCircuitBreaker sets the maximum percentage change in the mid rate of a pair that is published without a
+// second approval, only callable by the administrator MSP
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+//	maxChange       string The maximum change as a percentage, e.g. "5" for 5%, or "0" to remove the limit
+func (s *ForexChaincode) setCircuitBreaker(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return shim.Error("Expecting 3 arguments, the base currency, counter currency and maximum percentage change")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	maxChange, err := decimal.NewFromString(args[2])
+
+	if err != nil || maxChange.IsNegative() {
+		return shim.Error("Maximum change in arg[2] must be a percentage of zero or more")
+	}
+
+	pair := args[0] + ":" + args[1]
+	breakerKey, err := stub.CreateCompositeKey("circuitBreaker", []string{pair})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if maxChange.IsZero() {
+		err = stub.DelState(breakerKey)
+	} else {
+		breakerAsBytes, _ := json.Marshal(circuitBreaker{Pair: pair, MaxChange: maxChange})
+		err = stub.PutState(breakerKey, breakerAsBytes)
+	}
+
+	if err != nil {
+		return shim.Error("Unable to commit circuit breaker to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// tripCircuitBreaker parks an aggregated rate as pending if it moves the mid rate of the pair by more than the
+// pair's circuit breaker allows. Returns the pending rate, or nil if the rate can be published.
+func tripCircuitBreaker(stub shim.ChaincodeStubInterface, rateSubmission submission, bid decimal.Decimal, ask decimal.Decimal, validFor int64) (*pendingRate, error) {
+	breakerKey, err := stub.CreateCompositeKey("circuitBreaker", []string{rateSubmission.Pair})
+
+	if err != nil {
+		return nil, err
+	}
+
+	breakerAsBytes, err := stub.GetState(breakerKey)
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve circuit breaker from ledger " + err.Error())
+	}
+
+	if breakerAsBytes == nil {
+		return nil, nil
+	}
+
+	breaker := &circuitBreaker{}
+	err = json.Unmarshal(breakerAsBytes, breaker)
+
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal circuit breaker " + err.Error())
+	}
+
+	pairAsBytes, err := stub.GetState(rateSubmission.Pair)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//the first rate for a pair has nothing to be compared with
+	if pairAsBytes == nil {
+		return nil, nil
+	}
+
+	current, err := unmarshalPair(rateSubmission.Pair, pairAsBytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mid := bid.Add(ask).Div(decimal.New(2, 0))
+	change := mid.Sub(current.Mid).Abs().Div(current.Mid).Mul(decimal.New(100, 0))
+
+	if change.LessThanOrEqual(breaker.MaxChange) {
+		return nil, nil
+	}
+
+	pending := &pendingRate{Pair: rateSubmission.Pair, Bid: bid, Ask: ask, PreviousMid: current.Mid, Change: change,
+		ValidFor: validFor, Timestamp: rateSubmission.Timestamp, SubmittedBy: rateSubmission.Provider}
+
+	pendingKey, err := stub.CreateCompositeKey("pendingRate", []string{rateSubmission.Pair})
+
+	if err != nil {
+		return nil, err
+	}
+
+	pendingAsBytes, _ := json.Marshal(pending)
+	err = stub.PutState(pendingKey, pendingAsBytes)
+
+	if err != nil {
+		return nil, errors.New("Unable to commit pending rate to ledger " + err.Error())
+	}
+
+	return pending, nil
+}
+
+// approvePendingRate publishes the pending rate of a pair. The caller must be a registered rate provider other than
+// the one whose submission produced the pending rate. The rate is valid from the time it is approved.
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+func (s *ForexChaincode) approvePendingRate(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments, the base currency and counter currency")
+	}
+
+	approver, err := getRegisteredCaller(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pending, pendingKey, err := getPendingRate(stub, args[0]+":"+args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if *approver == pending.SubmittedBy {
+		return shim.Error("The pending rate for " + pending.Pair + " must be approved by a different identity than " + approver.key())
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	forexPair, err := putPair(stub, pending.Pair, pending.Bid, pending.Ask, timestamp.GetSeconds(), pending.ValidFor)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(pendingKey)
+
+	if err != nil {
+		return shim.Error("Unable to delete pending rate from ledger " + err.Error())
+	}
+
+	pairAsBytes, _ := json.Marshal(forexPair)
+	return shim.Success(pairAsBytes)
+}
+
+// rejectPendingRate discards the pending rate of a pair, the caller must be a registered rate provider
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+func (s *ForexChaincode) rejectPendingRate(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments, the base currency and counter currency")
+	}
+
+	_, err := getRegisteredCaller(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, pendingKey, err := getPendingRate(stub, args[0]+":"+args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(pendingKey)
+
+	if err != nil {
+		return shim.Error("Unable to delete pending rate from ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// listPendingRates returns the rates awaiting approval
+func (s *ForexChaincode) listPendingRates(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("pendingRate", []string{})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	pendingRates := []pendingRate{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		pending := pendingRate{}
+		err = json.Unmarshal(queryResponse.Value, &pending)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		pendingRates = append(pendingRates, pending)
+	}
+
+	pendingAsBytes, _ := json.Marshal(pendingRates)
+	return shim.Success(pendingAsBytes)
+}
+
+// getPendingRate returns the pending rate of a pair and its key, or an error if the pair has no pending rate
+func getPendingRate(stub shim.ChaincodeStubInterface, pair string) (*pendingRate, string, error) {
+	pendingKey, err := stub.CreateCompositeKey("pendingRate", []string{pair})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	pendingAsBytes, err := stub.GetState(pendingKey)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to retrieve pending rate from ledger " + err.Error())
+	}
+
+	if pendingAsBytes == nil {
+		return nil, "", errors.New("No pending rate for " + pair)
+	}
+
+	pending := &pendingRate{}
+	err = json.Unmarshal(pendingAsBytes, pending)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to unmarshal pending rate " + err.Error())
+	}
+
+	return pending, pendingKey, nil
+}
+
+// suspendForexPair stops a pair, and its inverse, being used for conversions until it is resumed. Rates can still
+// be submitted for a suspended pair. Only callable by the administrator MSP
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+//	reason          string Optional, why the pair was suspended
+func (s *ForexChaincode) suspendForexPair(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Expecting 2 or 3 arguments, the base currency, counter currency and optionally the reason")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	pairSuspension := suspension{Pair: args[0] + ":" + args[1], Timestamp: timestamp.GetSeconds()}
+	if len(args) == 3 {
+		pairSuspension.Reason = args[2]
+	}
+
+	suspensionKey, err := stub.CreateCompositeKey("suspendedPair", []string{pairSuspension.Pair})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	suspensionAsBytes, _ := json.Marshal(pairSuspension)
+	err = stub.PutState(suspensionKey, suspensionAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit suspension to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// resumeForexPair lifts the suspension of a pair, only callable by the administrator MSP
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+func (s *ForexChaincode) resumeForexPair(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments, the base currency and counter currency")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	suspensionKey, err := stub.CreateCompositeKey("suspendedPair", []string{args[0] + ":" + args[1]})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(suspensionKey)
+
+	if err != nil {
+		return shim.Error("Unable to delete suspension from ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkSuspended returns an error if the pair, its inverse or, for a derived rate, any leg along its path is suspended
+func checkSuspended(stub shim.ChaincodeStubInterface, forexPair *forex) error {
+	path := forexPair.Path
+	if len(path) == 0 {
+		path = strings.Split(forexPair.Pair, ":")
+	}
+
+	for i := 1; i < len(path); i++ {
+		for _, pair := range []string{path[i-1] + ":" + path[i], path[i] + ":" + path[i-1]} {
+			suspensionKey, err := stub.CreateCompositeKey("suspendedPair", []string{pair})
+
+			if err != nil {
+				return err
+			}
+
+			suspensionAsBytes, err := stub.GetState(suspensionKey)
+
+			if err != nil {
+				return errors.New("Unable to retrieve suspension from ledger " + err.Error())
+			}
+
+			if suspensionAsBytes != nil {
+				pairSuspension := &suspension{}
+				err = json.Unmarshal(suspensionAsBytes, pairSuspension)
+
+				if err != nil {
+					return errors.New("Unable to unmarshal suspension " + err.Error())
+				}
+
+				message := "Pair " + pair + " is suspended"
+				if pairSuspension.Reason != "" {
+					message += ": " + pairSuspension.Reason
+				}
+
+				return errors.New(message)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	stub := newForexStub(t)
+
+	for _, args := range [][]string{{"registerRateProvider", "Org2MSP", "oracle"}, {"setCircuitBreaker", "GBP", "USD", "5"},
+		{"createUpdateForexPair", "GBP", "USD", "1.20"}} {
+		response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	//a fat-finger rate is parked rather than published
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "12.0"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	status := &aggregationStatus{}
+	err := json.Unmarshal(response.GetPayload(), status)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.False(t, status.Published, "rate beyond the circuit breaker published")
+	assert.NotNil(t, status.Pending, "rate beyond the circuit breaker not parked")
+	assert.Equal(t, "900", status.Pending.Change.String(), "change mismatch")
+
+	forexPair := &forex{}
+	err = json.Unmarshal(stub.State["GBP:USD"], forexPair)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.True(t, decimal.New(120, -2).Equal(forexPair.Mid), "rate changed before approval")
+
+	//the submitter cannot approve their own rate
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"approvePendingRate", "GBP", "USD"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "pending rate approved by its submitter")
+
+	stub.Creator = testutil.NewIdentity("Org2MSP", "oracle")
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"approvePendingRate", "GBP", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	forexPair = &forex{}
+	err = json.Unmarshal(stub.State["GBP:USD"], forexPair)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.True(t, decimal.New(12, 0).Equal(forexPair.Mid), "approved rate not published")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"approvePendingRate", "GBP", "USD"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "pending rate approved twice")
+}
+
+func TestSuspendPair(t *testing.T) {
+	stub := newForexStub(t)
+
+	for _, args := range [][]string{{"createUpdateForexPair", "GBP", "USD", "1.20"}, {"createUpdateForexPair", "USD", "JPY", "110"},
+		{"suspendForexPair", "GBP", "USD", "bad feed"}} {
+		response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	//the pair, its inverse and rates derived through it are all rejected
+	for _, args := range [][]string{{"getForexPair", "GBP", "USD"}, {"getForexPair", "USD", "GBP"}, {"getForexPair", "GBP", "JPY"}, {"createQuote", "GBP", "USD", "0001", "2", "100"}} {
+		response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "suspended pair used")
+		assert.Equal(t, "Pair GBP:USD is suspended: bad feed", response.Message, "unexpected error")
+	}
+
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "USD", "JPY"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"resumeForexPair", "GBP", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "GBP", "JPY"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+}
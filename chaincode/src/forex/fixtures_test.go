@@ -0,0 +1,37 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+// newForexStub returns a forex stub administered by Org1MSP, invoked by the registered rate provider Org1MSP/treasury
+func newForexStub(t *testing.T) *testutil.MockStub {
+	stub := testutil.NewMockStub("forex", new(ForexChaincode))
+	stub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
+
+	response := stub.MockInit(uuid.New().String(), [][]byte{[]byte("Org1MSP")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	testutil.RegisterRateProvider(t, stub)
+	return stub
+}
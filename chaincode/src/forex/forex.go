@@ -18,14 +18,133 @@ package forex
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// forex is a currency pair stored on the ledger under the key "BASE:COUNTER"
+// Rates are quoted as units of the counter currency per unit of the base currency and are held as
+// exact decimals, serialized as strings, e.g. "1.20". Records written before this change hold the
+// rate as a JSON number, these are still read correctly.
+//Bid  - the rate at which the base currency is bought from a customer
+//Ask  - the rate at which the base currency is sold to a customer
+//Mid  - the midpoint between the bid and ask rates
+//Rate - the rate for the side requested from getForexPair, the mid rate when stored on the ledger
+//Side - the side the rate was requested for, empty when stored on the ledger
+//Timestamp - the time, in seconds since the epoch, of the transaction that set the rate
+//ValidFor - the number of seconds the rate may be used for after it is set, zero for records written
+//	before rate validity was introduced, which never expire
+//Path - the currencies a derived rate was triangulated through, empty for pairs stored on the ledger
+//Inverted - true if the rate was derived from the inverse pair
 type forex struct {
-	Pair string  `json:"pair"`
-	Rate float64 `json:"rate"`
+	Pair      string          `json:"pair"`
+	Bid       decimal.Decimal `json:"bid"`
+	Ask       decimal.Decimal `json:"ask"`
+	Mid       decimal.Decimal `json:"mid"`
+	Rate      decimal.Decimal `json:"rate"`
+	Side      string          `json:"side,omitempty"`
+	Timestamp int64           `json:"timestamp"`
+	ValidFor  int64           `json:"validFor"`
+	Path      []string        `json:"path,omitempty"`
+	Inverted  bool            `json:"inverted,omitempty"`
+}
+
+const (
+	sideBid = "bid"
+	sideAsk = "ask"
+	sideMid = "mid"
+)
+
+// defaultValidFor is the validity period, in seconds, of a rate set without one
+const defaultValidFor = 24 * 60 * 60
+
+// isStale returns true if the rate is no longer valid at the given time
+func (f *forex) isStale(now int64) bool {
+	return f.ValidFor > 0 && now >= f.Timestamp+f.ValidFor
+}
+
+// staleError describes a stale rate, the message starts with "Stale rate" so callers can identify it
+func (f *forex) staleError() error {
+	setAt := time.Unix(f.Timestamp, 0).UTC().Format(time.RFC3339)
+	return errors.New("Stale rate for " + f.Pair + ": set at " + setAt + " and valid for " + strconv.FormatInt(f.ValidFor, 10) + " seconds")
+}
+
+// withSide sets the rate of the pair to the requested side
+func (f *forex) withSide(side string) error {
+	switch side {
+	case sideBid:
+		f.Rate = f.Bid
+	case sideAsk:
+		f.Rate = f.Ask
+	case sideMid:
+		f.Rate = f.Mid
+	default:
+		return errors.New("Unknown side " + side + ", expecting bid, ask or mid")
+	}
+
+	f.Side = side
+	return nil
+}
+
+// rateUpdate is a rate for a currency pair as submitted by a rate provider, either as arguments to
+// createUpdateForexPair or as an element of the JSON array passed to bulkUpdateForexPairs
+//Ask - optional, defaults to the bid rate
+//ValidFor - optional, the number of seconds the rate is valid for. Defaults to one day
+type rateUpdate struct {
+	Base     string `json:"base"`
+	Counter  string `json:"counter"`
+	Bid      string `json:"bid"`
+	Ask      string `json:"ask,omitempty"`
+	ValidFor string `json:"validFor,omitempty"`
+}
+
+// toSubmission validates a rate update and returns it as a submission from the provider
+func (u rateUpdate) toSubmission(provider *rateProvider, timestamp int64) (submission, error) {
+	pair := u.Base + ":" + u.Counter
+
+	if u.Base == "" || u.Counter == "" || u.Base == u.Counter {
+		return submission{}, errors.New("Invalid currency pair " + pair)
+	}
+
+	bid, err := decimal.NewFromString(u.Bid)
+
+	if err != nil {
+		return submission{}, errors.New("Unable to parse rate for " + pair)
+	}
+
+	ask := bid
+	if u.Ask != "" {
+		ask, err = decimal.NewFromString(u.Ask)
+
+		if err != nil {
+			return submission{}, errors.New("Unable to parse ask rate for " + pair)
+		}
+	}
+
+	var validFor int64 = defaultValidFor
+	if u.ValidFor != "" {
+		validFor, err = strconv.ParseInt(u.ValidFor, 10, 64)
+
+		if err != nil || validFor <= 0 {
+			return submission{}, errors.New("Validity period for " + pair + " must be a positive number of seconds")
+		}
+	}
+
+	if !bid.IsPositive() || !ask.IsPositive() {
+		return submission{}, errors.New("Rate for " + pair + " must be a positive number")
+	}
+
+	if bid.GreaterThan(ask) {
+		return submission{}, errors.New("Bid rate for " + pair + " must not be greater than the ask rate")
+	}
+
+	return submission{Pair: pair, Provider: *provider, Bid: bid, Ask: ask, ValidFor: validFor, Timestamp: timestamp}, nil
 }
 
 //ForexChaincode is the struct that all chaincode methods are associated with
@@ -33,7 +152,35 @@ type ForexChaincode struct {
 }
 
 //Init method is invokved on installation and upgrade
+//Args:
+//	AdminMSP string Optional, the MSP ID of the organization that administers the rate providers. Defaults to
+//		the MSP of the identity instantiating the chaincode. Left unchanged on upgrade if omitted.
 func (s *ForexChaincode) Init(stub shim.ChaincodeStubInterface) sc.Response {
+	args := stub.GetStringArgs()
+
+	currentAdmin, err := stub.GetState("adminMSP")
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	adminMSP := ""
+	if len(args) > 0 {
+		adminMSP = args[0]
+	} else if currentAdmin == nil {
+		adminMSP, _ = cid.GetMSPID(stub)
+	}
+
+	if adminMSP == "" {
+		return shim.Success(nil)
+	}
+
+	err = stub.PutState("adminMSP", []byte(adminMSP))
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -45,56 +192,241 @@ func (s *ForexChaincode) Invoke(stub shim.ChaincodeStubInterface) sc.Response {
 		return s.createUpdateForexPair(stub, args)
 	} else if function == "getForexPair" {
 		return s.getForexPair(stub, args)
+	} else if function == "setPivotCurrency" {
+		return s.setPivotCurrency(stub, args)
+	} else if function == "checkForexConsistency" {
+		return s.checkForexConsistency(stub, args)
+	} else if function == "getForexPairAsOf" {
+		return s.getForexPairAsOf(stub, args)
+	} else if function == "getForexPairHistory" {
+		return s.getForexPairHistory(stub, args)
+	} else if function == "registerRateProvider" {
+		return s.registerRateProvider(stub, args)
+	} else if function == "removeRateProvider" {
+		return s.removeRateProvider(stub, args)
+	} else if function == "listRateProviders" {
+		return s.listRateProviders(stub, args)
+	} else if function == "setAggregationPolicy" {
+		return s.setAggregationPolicy(stub, args)
+	} else if function == "createQuote" {
+		return s.createQuote(stub, args)
+	} else if function == "consumeQuote" {
+		return s.consumeQuote(stub, args)
+	} else if function == "authorizeQuoteConsumer" {
+		return s.authorizeQuoteConsumer(stub, args)
+	} else if function == "revokeQuoteConsumer" {
+		return s.revokeQuoteConsumer(stub, args)
+	} else if function == "getQuote" {
+		return s.getQuoteByID(stub, args)
+	} else if function == "listForexPairs" {
+		return s.listForexPairs(stub, args)
+	} else if function == "deleteForexPair" {
+		return s.deleteForexPair(stub, args)
+	} else if function == "bulkUpdateForexPairs" {
+		return s.bulkUpdateForexPairs(stub, args)
+	} else if function == "setCircuitBreaker" {
+		return s.setCircuitBreaker(stub, args)
+	} else if function == "approvePendingRate" {
+		return s.approvePendingRate(stub, args)
+	} else if function == "rejectPendingRate" {
+		return s.rejectPendingRate(stub, args)
+	} else if function == "listPendingRates" {
+		return s.listPendingRates(stub, args)
+	} else if function == "suspendForexPair" {
+		return s.suspendForexPair(stub, args)
+	} else if function == "resumeForexPair" {
+		return s.resumeForexPair(stub, args)
 	}
 
 	return shim.Error("Invalid function")
 }
 
+// createUpdateForexPair submits a rate for a currency pair on behalf of the calling rate provider. The
+// rate written to the ledger is the median of the fresh submissions from registered providers, once
+// the quorum is reached, see submitRate. Returns the status of the aggregation for the pair.
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+//	bid             string The bid rate, or the single rate used for both sides if ask is omitted
+//	ask             string Optional, the ask rate
+//	validFor        string Optional, the number of seconds the rate is valid for. Defaults to one day
 func (s *ForexChaincode) createUpdateForexPair(stub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 3 {
-		return shim.Error("Expecting 3 arguments, base currency, counter currency , rate")
+	if len(args) < 3 || len(args) > 5 {
+		return shim.Error("Expecting 3 to 5 arguments, base currency, counter currency, rate or bid rate and ask rate, and optionally the validity period in seconds")
 	}
 
-	baseCurrency := args[0]
-	counterCurrency := args[1]
-	rate, err := strconv.ParseFloat(args[2], 64)
-	pair := baseCurrency + ":" + counterCurrency
+	update := rateUpdate{Base: args[0], Counter: args[1], Bid: args[2]}
+
+	if len(args) > 3 {
+		update.Ask = args[3]
+	}
+
+	if len(args) > 4 {
+		update.ValidFor = args[4]
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	provider, err := getRegisteredCaller(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rateSubmission, err := update.toSubmission(provider, timestamp.GetSeconds())
 
 	if err != nil {
-		return shim.Error("Unable to parse rate from arg[2]")
+		return shim.Error(err.Error())
 	}
 
-	forexPair := forex{Pair: pair, Rate: rate}
+	status, err := submitRate(stub, rateSubmission)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	statusAsBytes, _ := json.Marshal(status)
+	return shim.Success(statusAsBytes)
+}
+
+// putPair writes a currency pair to the ledger, the mid rate is the midpoint of the bid and ask rates
+func putPair(stub shim.ChaincodeStubInterface, pair string, bid decimal.Decimal, ask decimal.Decimal, timestamp int64, validFor int64) (*forex, error) {
+	mid := bid.Add(ask).Div(decimal.New(2, 0))
+
+	forexPair := &forex{Pair: pair, Bid: bid, Ask: ask, Mid: mid, Rate: mid, Timestamp: timestamp, ValidFor: validFor}
 	asBytes, _ := json.Marshal(forexPair)
-	err = stub.PutState(pair, asBytes)
+	err := stub.PutState(pair, asBytes)
 
 	if err != nil {
-		return shim.Error("Unable to commit pair to ledger")
+		return nil, errors.New("Unable to commit pair to ledger")
+	}
 
+	err = indexPair(stub, pair, false)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return shim.Success(nil)
+	return forexPair, nil
+}
+
+// indexPair writes, or with remove set deletes, the composite key forexPair~BASE~COUNTER listing a pair stored on
+// the ledger, so the stored pairs can be found without scanning the whole state
+func indexPair(stub shim.ChaincodeStubInterface, pair string, remove bool) error {
+	indexKey, err := stub.CreateCompositeKey("forexPair", strings.Split(pair, ":"))
+
+	if err != nil {
+		return err
+	}
+
+	if remove {
+		err = stub.DelState(indexKey)
+	} else {
+		err = stub.PutState(indexKey, []byte{0x00})
+	}
+
+	if err != nil {
+		return errors.New("Unable to commit pair index to ledger " + err.Error())
+	}
+
+	return nil
 }
 
+// getForexPair returns a currency pair from the ledger, with the rate set to the requested side.
+// If the pair is not on the ledger the rate is triangulated through other pairs, see resolvePair.
+// Returns an error if no rate is available, the rate is stale or the pair is suspended.
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+//	side            string Optional, one of bid, ask or mid. Defaults to mid
 func (s *ForexChaincode) getForexPair(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting the base currency and counter currency")
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting the base currency, counter currency and optionally the side (bid, ask or mid)")
 	}
 
-	baseCurrency := args[0]
-	counterCurrency := args[1]
-	pair := baseCurrency + ":" + counterCurrency
+	side := sideMid
+	if len(args) == 3 {
+		side = args[2]
+	}
 
-	pairAsBytes, stubError := stub.GetState(pair)
+	forexPair, err := resolvePair(stub, args[0], args[1])
 
-	if stubError != nil {
-		return shim.Error(stubError.Error())
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
+	if forexPair == nil {
+		return shim.Error("No rate available for " + args[0] + ":" + args[1])
+	}
+
+	err = checkSuspended(stub, forexPair)
+
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	if forexPair.isStale(timestamp.GetSeconds()) {
+		return shim.Error(forexPair.staleError().Error())
+	}
+
+	err = forexPair.withSide(side)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pairAsBytes, _ := json.Marshal(forexPair)
 	return (shim.Success(pairAsBytes))
 }
 
+// getPair reads a currency pair from the ledger, returning nil if the pair does not exist
+func getPair(stub shim.ChaincodeStubInterface, baseCurrency string, counterCurrency string) (*forex, error) {
+	pair := baseCurrency + ":" + counterCurrency
+
+	pairAsBytes, err := stub.GetState(pair)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if pairAsBytes == nil {
+		return nil, nil
+	}
+
+	return unmarshalPair(pair, pairAsBytes)
+}
+
+// unmarshalPair parses a currency pair record, filling in the bid, ask and mid rates of records
+// written before they were introduced
+func unmarshalPair(pair string, pairAsBytes []byte) (*forex, error) {
+	forexPair := &forex{}
+	err := json.Unmarshal(pairAsBytes, forexPair)
+
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal pair " + pair + ": " + err.Error())
+	}
+
+	//records written before bid and ask rates were introduced only hold a single rate
+	if forexPair.Mid.IsZero() {
+		forexPair.Bid = forexPair.Rate
+		forexPair.Ask = forexPair.Rate
+		forexPair.Mid = forexPair.Rate
+	}
+
+	return forexPair, nil
+}
+
 func main() {
 	err := shim.Start(new(ForexChaincode))
 	if err != nil {
@@ -21,13 +21,14 @@ import (
 	"github.com/google/uuid"
 	"github.com/hyperledger/fabric/common/util"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
-	"strconv"
 	"testing"
+	"testutil"
 )
 
 func TestForex(t *testing.T) {
-	stub := shim.NewMockStub("forex", new(ForexChaincode))
+	stub := newForexStub(t)
 	uuid := uuid.New().String()
 
 	const RATE = "0.88"
@@ -54,8 +55,84 @@ func TestForex(t *testing.T) {
 		panic(err)
 	}
 
-	rateAsFloat, _ := strconv.ParseFloat(RATE, 64)
+	rateAsDecimal, _ := decimal.NewFromString(RATE)
 
-	assert.Equal(t, rateAsFloat, responseForex.Rate, "rate mismatch")
+	assert.True(t, rateAsDecimal.Equal(responseForex.Rate), "rate mismatch")
 
 }
+
+func TestForexFloatRecord(t *testing.T) {
+	stub := testutil.NewMockStub("forex", new(ForexChaincode))
+
+	//records written before rates were stored as decimal strings hold the rate as a JSON number
+	stub.State["GBP:USD"] = []byte(`{"pair":"GBP:USD","rate":1.2}`)
+
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "GBP", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	responseForex := map[string]interface{}{}
+	err := json.Unmarshal(response.GetPayload(), &responseForex)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "1.2", responseForex["rate"], "pair not returned with decimal rate")
+	assert.Equal(t, "1.2", responseForex["bid"], "bid rate not derived from single rate")
+	assert.Equal(t, "1.2", responseForex["ask"], "ask rate not derived from single rate")
+}
+
+func TestForexBidAsk(t *testing.T) {
+	stub := newForexStub(t)
+
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.21", "1.19"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "bid greater than ask accepted")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.19", "1.21"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	expected := map[string]string{"bid": "1.19", "ask": "1.21", "mid": "1.2"}
+
+	for side, rate := range expected {
+		response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "GBP", "USD", side}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		responseForex := &forex{}
+		err := json.Unmarshal(response.GetPayload(), responseForex)
+		if err != nil {
+			panic(err)
+		}
+
+		assert.Equal(t, rate, responseForex.Rate.String(), side+" rate mismatch")
+		assert.Equal(t, side, responseForex.Side, "side mismatch")
+	}
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "GBP", "USD", "offer"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "unknown side accepted")
+}
+
+func TestForexStaleRate(t *testing.T) {
+	stub := newForexStub(t)
+
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.19", "1.21", "60"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "GBP", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	responseForex := &forex{}
+	err := json.Unmarshal(response.GetPayload(), responseForex)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.NotZero(t, responseForex.Timestamp, "timestamp not set")
+	assert.EqualValues(t, 60, responseForex.ValidFor, "validity period mismatch")
+
+	//move the time the rate was set back beyond its validity period
+	responseForex.Timestamp -= 120
+	stub.State["GBP:USD"], _ = json.Marshal(responseForex)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "GBP", "USD"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "stale rate returned")
+	assert.Contains(t, response.Message, "Stale rate for GBP:USD", "unexpected error")
+}
@@ -0,0 +1,241 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strconv"
+)
+
+// defaultHistoryPageSize is the number of entries returned by getForexPairHistory if no page size is given
+const defaultHistoryPageSize = 100
+
+// pairChange is a single write to, or deletion of, a currency pair
+//TxID - the ID of the transaction that changed the pair
+//Timestamp - the time, in seconds since the epoch, of the transaction
+//IsDelete - true if the transaction deleted the pair, Pair is nil
+type pairChange struct {
+	TxID      string `json:"txID"`
+	Timestamp int64  `json:"timestamp"`
+	IsDelete  bool   `json:"isDelete"`
+	Pair      *forex `json:"pair,omitempty"`
+}
+
+// pairHistory is a page of changes to a currency pair, Bookmark is passed to getForexPairHistory to
+// fetch the next page and is empty on the last page
+type pairHistory struct {
+	History  []pairChange `json:"history"`
+	Bookmark string       `json:"bookmark"`
+}
+
+// getForexPairAsOf returns the rate of a currency pair that was in effect at a point in time, i.e. the last
+// rate written at or before that time. If the pair has never been written the inverse pair is used.
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+//	timestamp       string The point in time, in seconds since the epoch
+//	side            string Optional, one of bid, ask or mid. Defaults to mid
+func (s *ForexChaincode) getForexPairAsOf(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting the base currency, counter currency, timestamp and optionally the side (bid, ask or mid)")
+	}
+
+	asOf, err := strconv.ParseInt(args[2], 10, 64)
+
+	if err != nil {
+		return shim.Error("Unable to parse timestamp from arg[2]")
+	}
+
+	side := sideMid
+	if len(args) == 4 {
+		side = args[3]
+	}
+
+	changes, err := getPairChanges(stub, args[0], args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	inverted := false
+	if len(changes) == 0 {
+		changes, err = getPairChanges(stub, args[1], args[0])
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		inverted = true
+	}
+
+	forexPair := rateAsOf(changes, asOf)
+
+	if forexPair == nil {
+		return shim.Error("No rate in effect for " + args[0] + ":" + args[1] + " at " + args[2])
+	}
+
+	if inverted {
+		forexPair = invertPair(forexPair)
+	}
+
+	err = forexPair.withSide(side)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pairAsBytes, _ := json.Marshal(forexPair)
+	return shim.Success(pairAsBytes)
+}
+
+// getForexPairHistory returns a page of the changes made to a currency pair, oldest first
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+//	from            string Optional, only return changes at or after this time, in seconds since the epoch
+//	to              string Optional, only return changes at or before this time, in seconds since the epoch
+//	pageSize        string Optional, the maximum number of changes to return. Defaults to 100
+//	bookmark        string Optional, the bookmark returned with the previous page
+func (s *ForexChaincode) getForexPairHistory(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 2 || len(args) > 6 {
+		return shim.Error("Incorrect number of arguments. Expecting the base currency, counter currency and optionally from, to, page size and bookmark")
+	}
+
+	var err error
+	var from, to int64 = 0, 0
+	pageSize := defaultHistoryPageSize
+	offset := 0
+
+	if len(args) > 2 && args[2] != "" {
+		if from, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			return shim.Error("Unable to parse from timestamp from arg[2]")
+		}
+	}
+
+	if len(args) > 3 && args[3] != "" {
+		if to, err = strconv.ParseInt(args[3], 10, 64); err != nil {
+			return shim.Error("Unable to parse to timestamp from arg[3]")
+		}
+	}
+
+	if len(args) > 4 && args[4] != "" {
+		if pageSize, err = strconv.Atoi(args[4]); err != nil || pageSize <= 0 {
+			return shim.Error("Page size in arg[4] must be a positive number")
+		}
+	}
+
+	if len(args) > 5 && args[5] != "" {
+		if offset, err = strconv.Atoi(args[5]); err != nil || offset < 0 {
+			return shim.Error("Invalid bookmark " + args[5])
+		}
+	}
+
+	changes, err := getPairChanges(stub, args[0], args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	historyAsBytes, _ := json.Marshal(pageChanges(changes, from, to, pageSize, offset))
+	return shim.Success(historyAsBytes)
+}
+
+// getPairChanges reads the key history of a currency pair, ordered by time
+func getPairChanges(stub shim.ChaincodeStubInterface, baseCurrency string, counterCurrency string) ([]pairChange, error) {
+	pair := baseCurrency + ":" + counterCurrency
+
+	resultsIterator, err := stub.GetHistoryForKey(pair)
+
+	if err != nil {
+		return nil, errors.New("Unable to get key history " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	changes := []pairChange{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		change := pairChange{TxID: queryResponse.TxId, Timestamp: queryResponse.Timestamp.GetSeconds(), IsDelete: queryResponse.IsDelete}
+
+		if !queryResponse.IsDelete {
+			change.Pair, err = unmarshalPair(pair, queryResponse.Value)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Timestamp < changes[j].Timestamp })
+
+	return changes, nil
+}
+
+// rateAsOf returns the pair from the last change at or before a point in time, nil if there was no
+// change before then or the last change deleted the pair. The changes must be ordered by time.
+func rateAsOf(changes []pairChange, asOf int64) *forex {
+	var forexPair *forex
+
+	for _, change := range changes {
+		if change.Timestamp > asOf {
+			break
+		}
+
+		forexPair = change.Pair
+	}
+
+	return forexPair
+}
+
+// pageChanges filters changes to those between from and to, zero meaning unbounded, and returns
+// the page of at most pageSize changes starting at offset
+func pageChanges(changes []pairChange, from int64, to int64, pageSize int, offset int) pairHistory {
+	filtered := []pairChange{}
+
+	for _, change := range changes {
+		if (from == 0 || change.Timestamp >= from) && (to == 0 || change.Timestamp <= to) {
+			filtered = append(filtered, change)
+		}
+	}
+
+	page := pairHistory{History: []pairChange{}}
+
+	if offset >= len(filtered) {
+		return page
+	}
+
+	end := offset + pageSize
+	if end < len(filtered) {
+		page.Bookmark = strconv.Itoa(end)
+	} else {
+		end = len(filtered)
+	}
+
+	page.History = filtered[offset:end]
+	return page
+}
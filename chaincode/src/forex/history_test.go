@@ -0,0 +1,63 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// the mock stub does not implement GetHistoryForKey, so the history is built by hand
+func testChanges() []pairChange {
+	changes := []pairChange{}
+
+	for i, rate := range []string{"1.18", "1.20", "1.22"} {
+		mid, _ := decimal.NewFromString(rate)
+		changes = append(changes, pairChange{TxID: rate, Timestamp: int64(1000 * (i + 1)),
+			Pair: &forex{Pair: "GBP:USD", Bid: mid, Ask: mid, Mid: mid, Rate: mid}})
+	}
+
+	return append(changes, pairChange{TxID: "delete", Timestamp: 4000, IsDelete: true})
+}
+
+func TestRateAsOf(t *testing.T) {
+	changes := testChanges()
+
+	assert.Nil(t, rateAsOf(changes, 999), "rate returned before the pair was written")
+	assert.Equal(t, "1.18", rateAsOf(changes, 1000).Rate.String(), "rate mismatch")
+	assert.Equal(t, "1.2", rateAsOf(changes, 2999).Rate.String(), "rate mismatch")
+	assert.Equal(t, "1.22", rateAsOf(changes, 3000).Rate.String(), "rate mismatch")
+	assert.Nil(t, rateAsOf(changes, 4000), "rate returned after the pair was deleted")
+}
+
+func TestPageChanges(t *testing.T) {
+	changes := testChanges()
+
+	page := pageChanges(changes, 2000, 0, 2, 0)
+	assert.Len(t, page.History, 2, "page size mismatch")
+	assert.Equal(t, "1.20", page.History[0].TxID, "first change mismatch")
+	assert.Equal(t, "2", page.Bookmark, "bookmark mismatch")
+
+	page = pageChanges(changes, 2000, 0, 2, 2)
+	assert.Len(t, page.History, 1, "last page size mismatch")
+	assert.Equal(t, "delete", page.History[0].TxID, "last change mismatch")
+	assert.Equal(t, "", page.Bookmark, "bookmark returned on the last page")
+
+	page = pageChanges(changes, 0, 2500, 10, 0)
+	assert.Len(t, page.History, 2, "changes not filtered by time")
+}
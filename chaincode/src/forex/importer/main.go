@@ -0,0 +1,130 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+// importer converts ECB style reference rate sheets into the payload for the ForexChaincode bulkUpdateForexPairs
+// function, or shows how the rates differ from those on the ledger.
+//
+//	importer -in eurofxref-daily.xml -inverse -cross USD,GBP,JPY > rates.json
+//	importer -in eurofxref.csv -dry-run -current pairs.json
+//
+// pairs.json is the output of the listForexPairs query.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+func main() {
+	in := flag.String("in", "", "the rate sheet to import, eurofxref XML or CSV")
+	format := flag.String("format", "", "the format of the rate sheet, xml or csv. Defaults to the file extension")
+	inverse := flag.Bool("inverse", false, "include the inverse of each EUR pair, e.g. USD:EUR")
+	cross := flag.String("cross", "", "comma separated currencies to derive cross pairs between through EUR, e.g. USD,GBP,JPY")
+	places := flag.Int("places", 6, "the number of decimal places derived rates are rounded to")
+	validFor := flag.String("validFor", "", "the number of seconds the rates are valid for, defaults to the chaincode default")
+	dryRun := flag.Bool("dry-run", false, "print the differences from the current rates instead of the payload")
+	current := flag.String("current", "", "the current rates, as returned by listForexPairs, to compare with in a dry run")
+	flag.Parse()
+
+	err := run(*in, *format, *inverse, *cross, int32(*places), *validFor, *dryRun, *current, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(in string, format string, inverse bool, cross string, places int32, validFor string, dryRun bool, current string, out io.Writer) error {
+	if in == "" {
+		return fmt.Errorf("A rate sheet must be given with -in")
+	}
+
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(in)), ".")
+	}
+
+	sheet, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer sheet.Close()
+
+	var rates *referenceRates
+
+	switch format {
+	case "xml":
+		rates, err = parseECBXML(sheet)
+	case "csv":
+		rates, err = parseECBCSV(sheet)
+	default:
+		return fmt.Errorf("Unknown format %s, expecting xml or csv", format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	crossCurrencies := []string{}
+	if cross != "" {
+		for _, currency := range strings.Split(cross, ",") {
+			crossCurrencies = append(crossCurrencies, strings.TrimSpace(currency))
+		}
+	}
+
+	updates, err := buildUpdates(rates, inverse, crossCurrencies, places, validFor)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		return json.NewEncoder(out).Encode(updates)
+	}
+
+	currentRates := map[string]decimal.Decimal{}
+	if current != "" {
+		currentFile, err := os.Open(current)
+		if err != nil {
+			return err
+		}
+		defer currentFile.Close()
+
+		currentRates, err = readCurrentRates(currentFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(out, "Rates for %s\n", rates.Date)
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "PAIR\tCURRENT\tNEW\tCHANGE %")
+
+	for _, diff := range diffRates(updates, currentRates) {
+		if diff.Current == "" {
+			diff.Current, diff.Change = "-", "new"
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", diff.Pair, diff.Current, diff.New, diff.Change)
+	}
+
+	return writer.Flush()
+}
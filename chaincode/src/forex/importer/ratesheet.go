@@ -0,0 +1,266 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/shopspring/decimal"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// referenceCurrency is the base currency of ECB reference rates
+const referenceCurrency = "EUR"
+
+// referenceRates is a day's reference rates, as units of each currency per euro
+type referenceRates struct {
+	Date  string
+	Rates map[string]decimal.Decimal
+}
+
+// rateUpdate is an element of the JSON array passed to bulkUpdateForexPairs
+type rateUpdate struct {
+	Base     string `json:"base"`
+	Counter  string `json:"counter"`
+	Bid      string `json:"bid"`
+	ValidFor string `json:"validFor,omitempty"`
+}
+
+func (u rateUpdate) pair() string {
+	return u.Base + ":" + u.Counter
+}
+
+// ecbEnvelope is the eurofxref XML published by the ECB, the daily file holds one day and the history files
+// hold one Cube per day, most recent first, e.g.
+//	<Cube><Cube time="2019-05-10"><Cube currency="USD" rate="1.1218"/>...</Cube></Cube>
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// parseECBXML reads the most recent day of rates from eurofxref XML
+func parseECBXML(r io.Reader) (*referenceRates, error) {
+	envelope := ecbEnvelope{}
+	err := xml.NewDecoder(r).Decode(&envelope)
+
+	if err != nil {
+		return nil, errors.New("Unable to parse XML rate sheet " + err.Error())
+	}
+
+	if len(envelope.Days) == 0 {
+		return nil, errors.New("XML rate sheet contains no rates")
+	}
+
+	day := envelope.Days[0]
+	rates := &referenceRates{Date: day.Time, Rates: map[string]decimal.Decimal{}}
+
+	for _, rate := range day.Rates {
+		value, err := decimal.NewFromString(rate.Rate)
+
+		if err != nil || !value.IsPositive() {
+			return nil, errors.New("Invalid rate " + rate.Rate + " for " + rate.Currency)
+		}
+
+		rates.Rates[rate.Currency] = value
+	}
+
+	return rates, nil
+}
+
+// parseECBCSV reads the most recent day of rates from eurofxref CSV, a header row of currencies followed by a row
+// per day, most recent first, e.g.
+//	Date, USD, JPY,
+//	10 May 2019, 1.1218, 123.26,
+// Currencies without a rate for the day, shown as N/A or left empty, are skipped
+func parseECBCSV(r io.Reader) (*referenceRates, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+
+	if err != nil {
+		return nil, errors.New("Unable to read CSV rate sheet header " + err.Error())
+	}
+
+	row, err := reader.Read()
+
+	if err == io.EOF {
+		return nil, errors.New("CSV rate sheet contains no rates")
+	}
+
+	if err != nil {
+		return nil, errors.New("Unable to read CSV rate sheet " + err.Error())
+	}
+
+	if len(header) < 2 || strings.TrimSpace(header[0]) != "Date" {
+		return nil, errors.New("CSV rate sheet header must start with Date followed by currencies")
+	}
+
+	rates := &referenceRates{Date: strings.TrimSpace(row[0]), Rates: map[string]decimal.Decimal{}}
+
+	for i := 1; i < len(header) && i < len(row); i++ {
+		currency := strings.TrimSpace(header[i])
+		rate := strings.TrimSpace(row[i])
+
+		if currency == "" || rate == "" || rate == "N/A" {
+			continue
+		}
+
+		value, err := decimal.NewFromString(rate)
+
+		if err != nil || !value.IsPositive() {
+			return nil, errors.New("Invalid rate " + rate + " for " + currency)
+		}
+
+		rates.Rates[currency] = value
+	}
+
+	return rates, nil
+}
+
+// buildUpdates converts reference rates into pair updates, sorted by pair. Every currency is quoted against the
+// euro, e.g. EUR:USD. With inverse set the reverse pairs, e.g. USD:EUR, are included, and every combination of
+// the cross currencies is included derived through the euro, e.g. GBP:USD is EUR:USD / EUR:GBP. Derived rates
+// are rounded to the given number of decimal places.
+func buildUpdates(rates *referenceRates, inverse bool, cross []string, places int32, validFor string) ([]rateUpdate, error) {
+	updates := []rateUpdate{}
+
+	for currency, rate := range rates.Rates {
+		updates = append(updates, rateUpdate{Base: referenceCurrency, Counter: currency, Bid: rate.String(), ValidFor: validFor})
+
+		if inverse {
+			updates = append(updates, rateUpdate{Base: currency, Counter: referenceCurrency, Bid: decimal.New(1, 0).DivRound(rate, places).String(), ValidFor: validFor})
+		}
+	}
+
+	//the euro legs are already covered by the EUR pairs and their inverses
+	crossRates := map[string]decimal.Decimal{}
+
+	for _, currency := range cross {
+		if currency == referenceCurrency {
+			continue
+		}
+
+		rate, ok := rates.Rates[currency]
+
+		if !ok {
+			return nil, errors.New("No rate for cross currency " + currency + " in the rate sheet")
+		}
+
+		crossRates[currency] = rate
+	}
+
+	for base, baseRate := range crossRates {
+		for counter, counterRate := range crossRates {
+			if counter != base {
+				updates = append(updates, rateUpdate{Base: base, Counter: counter, Bid: counterRate.DivRound(baseRate, places).String(), ValidFor: validFor})
+			}
+		}
+	}
+
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].pair() < updates[j].pair()
+	})
+
+	return updates, nil
+}
+
+// readCurrentRates reads the mid rates of the pairs on the ledger from the JSON returned by listForexPairs, either
+// a page of pairs or a plain array of pairs
+func readCurrentRates(r io.Reader) (map[string]decimal.Decimal, error) {
+	type pair struct {
+		Pair string          `json:"pair"`
+		Mid  decimal.Decimal `json:"mid"`
+		Rate decimal.Decimal `json:"rate"`
+	}
+
+	data, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := []pair{}
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &pairs)
+	} else {
+		page := struct {
+			Pairs []pair `json:"pairs"`
+		}{}
+		err = json.Unmarshal(data, &page)
+		pairs = page.Pairs
+	}
+
+	if err != nil {
+		return nil, errors.New("Unable to parse current rates " + err.Error())
+	}
+
+	current := map[string]decimal.Decimal{}
+
+	for _, p := range pairs {
+		//records written before bid and ask rates were introduced only hold a single rate
+		if p.Mid.IsZero() {
+			p.Mid = p.Rate
+		}
+
+		current[p.Pair] = p.Mid
+	}
+
+	return current, nil
+}
+
+// rateDiff compares an update with the rate currently on the ledger
+//Current - empty if the pair is not on the ledger
+//Change - the change as a percentage of the current rate, empty if the pair is not on the ledger
+type rateDiff struct {
+	Pair    string
+	Current string
+	New     string
+	Change  string
+}
+
+// diffRates compares each update with the current rates, pairs on the ledger that are not in the updates are left
+// unchanged by bulkUpdateForexPairs and are not listed
+func diffRates(updates []rateUpdate, current map[string]decimal.Decimal) []rateDiff {
+	diffs := []rateDiff{}
+
+	for _, update := range updates {
+		diff := rateDiff{Pair: update.pair(), New: update.Bid}
+
+		if rate, ok := current[diff.Pair]; ok && rate.IsPositive() {
+			newRate, _ := decimal.NewFromString(update.Bid)
+			diff.Current = rate.String()
+			diff.Change = newRate.Sub(rate).Div(rate).Mul(decimal.New(100, 0)).StringFixed(2)
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	return diffs
+}
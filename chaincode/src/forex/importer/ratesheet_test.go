@@ -0,0 +1,90 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package main
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const ecbXML = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<gesmes:Sender>
+		<gesmes:name>European Central Bank</gesmes:name>
+	</gesmes:Sender>
+	<Cube>
+		<Cube time="2019-05-10">
+			<Cube currency="USD" rate="1.1218"/>
+			<Cube currency="GBP" rate="0.86278"/>
+		</Cube>
+		<Cube time="2019-05-09">
+			<Cube currency="USD" rate="1.1203"/>
+			<Cube currency="GBP" rate="0.86168"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+const ecbCSV = `Date, USD, JPY, GBP, ISK, 
+10 May 2019, 1.1218, 123.26, 0.86278, N/A, 
+`
+
+func TestParseRateSheets(t *testing.T) {
+	rates, err := parseECBXML(strings.NewReader(ecbXML))
+	assert.Nil(t, err, "unable to parse XML")
+	assert.Equal(t, "2019-05-10", rates.Date, "the most recent day was not used")
+	assert.Len(t, rates.Rates, 2, "rate count mismatch")
+	assert.Equal(t, "1.1218", rates.Rates["USD"].String(), "USD rate mismatch")
+
+	rates, err = parseECBCSV(strings.NewReader(ecbCSV))
+	assert.Nil(t, err, "unable to parse CSV")
+	assert.Equal(t, "10 May 2019", rates.Date, "date mismatch")
+	assert.Len(t, rates.Rates, 3, "currencies without a rate were not skipped")
+	assert.Equal(t, "123.26", rates.Rates["JPY"].String(), "JPY rate mismatch")
+
+	_, err = parseECBCSV(strings.NewReader("Date, USD\n10 May 2019, abc\n"))
+	assert.NotNil(t, err, "invalid rate accepted")
+}
+
+func TestBuildUpdates(t *testing.T) {
+	rates, err := parseECBXML(strings.NewReader(ecbXML))
+	if err != nil {
+		panic(err)
+	}
+
+	updates, err := buildUpdates(rates, true, []string{"EUR", "USD", "GBP"}, 6, "86400")
+	assert.Nil(t, err, "unable to build updates")
+
+	bids := map[string]string{}
+	for _, update := range updates {
+		bids[update.pair()] = update.Bid
+		assert.Equal(t, "86400", update.ValidFor, "validity period mismatch")
+	}
+
+	assert.Equal(t, map[string]string{"EUR:GBP": "0.86278", "EUR:USD": "1.1218", "GBP:EUR": "1.159044", "USD:EUR": "0.891424",
+		"GBP:USD": "1.300216", "USD:GBP": "0.769103"}, bids, "updates mismatch")
+	assert.Equal(t, "EUR:GBP", updates[0].pair(), "updates not sorted")
+
+	_, err = buildUpdates(rates, false, []string{"JPY"}, 6, "")
+	assert.NotNil(t, err, "cross currency missing from the rate sheet accepted")
+
+	diffs := diffRates(updates[:2], map[string]decimal.Decimal{"EUR:GBP": decimal.New(86, -2)})
+	assert.Equal(t, rateDiff{Pair: "EUR:GBP", Current: "0.86", New: "0.86278", Change: "0.32"}, diffs[0], "diff mismatch")
+	assert.Equal(t, rateDiff{Pair: "EUR:USD", New: "1.1218"}, diffs[1], "new pair diff mismatch")
+}
@@ -0,0 +1,124 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// inconsistency is a pair whose rate multiplied by the rate of its inverse deviates from one by more than the tolerance
+type inconsistency struct {
+	Pair        string          `json:"pair"`
+	InversePair string          `json:"inversePair"`
+	Rate        decimal.Decimal `json:"rate"`
+	InverseRate decimal.Decimal `json:"inverseRate"`
+	Product     decimal.Decimal `json:"product"`
+	Deviation   decimal.Decimal `json:"deviation"`
+}
+
+// defaultTolerance is the deviation from one allowed by checkForexConsistency if no tolerance is given
+var defaultTolerance = decimal.New(1, -2)
+
+// invertPair derives the rates for COUNTER:BASE from BASE:COUNTER. The bid and ask swap sides, as
+// buying the base currency is selling the counter currency.
+func invertPair(forexPair *forex) *forex {
+	one := decimal.New(1, 0)
+	currencies := strings.Split(forexPair.Pair, ":")
+
+	inverse := &forex{Pair: currencies[1] + ":" + currencies[0], Bid: one.Div(forexPair.Ask), Ask: one.Div(forexPair.Bid),
+		Mid: one.Div(forexPair.Mid), Timestamp: forexPair.Timestamp, ValidFor: forexPair.ValidFor, Inverted: true}
+	inverse.Rate = inverse.Mid
+
+	return inverse
+}
+
+// getPairOrInverse reads a currency pair from the ledger, deriving it from the inverse pair if only
+// that is on the ledger. Returns nil if neither exist.
+func getPairOrInverse(stub shim.ChaincodeStubInterface, baseCurrency string, counterCurrency string) (*forex, error) {
+	forexPair, err := getPair(stub, baseCurrency, counterCurrency)
+
+	if err != nil || forexPair != nil {
+		return forexPair, err
+	}
+
+	inversePair, err := getPair(stub, counterCurrency, baseCurrency)
+
+	if err != nil || inversePair == nil {
+		return nil, err
+	}
+
+	return invertPair(inversePair), nil
+}
+
+// checkForexConsistency returns the pairs stored in both directions whose mid rates, multiplied together,
+// deviate from one by more than the tolerance
+//Args:
+//	tolerance string Optional, the allowed deviation, e.g. 0.01 for 1%. Defaults to 0.01
+func (s *ForexChaincode) checkForexConsistency(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) > 1 {
+		return shim.Error("Expecting at most 1 argument, the tolerance")
+	}
+
+	tolerance := defaultTolerance
+	if len(args) == 1 {
+		var err error
+		tolerance, err = decimal.NewFromString(args[0])
+
+		if err != nil || tolerance.IsNegative() {
+			return shim.Error("Tolerance must be a non-negative number")
+		}
+	}
+
+	pairs, err := listPairs(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rates := map[string]*forex{}
+	for _, forexPair := range pairs {
+		rates[forexPair.Pair] = forexPair
+	}
+
+	one := decimal.New(1, 0)
+	inconsistencies := []inconsistency{}
+
+	for _, forexPair := range pairs {
+		currencies := strings.Split(forexPair.Pair, ":")
+		inversePair, ok := rates[currencies[1]+":"+currencies[0]]
+
+		//check each pair of pairs once, from the pair whose key sorts first
+		if !ok || forexPair.Pair > inversePair.Pair {
+			continue
+		}
+
+		product := forexPair.Mid.Mul(inversePair.Mid)
+		deviation := product.Sub(one).Abs()
+
+		if deviation.GreaterThan(tolerance) {
+			inconsistencies = append(inconsistencies, inconsistency{Pair: forexPair.Pair, InversePair: inversePair.Pair,
+				Rate: forexPair.Mid, InverseRate: inversePair.Mid, Product: product, Deviation: deviation})
+		}
+	}
+
+	inconsistenciesAsBytes, _ := json.Marshal(inconsistencies)
+	return shim.Success(inconsistenciesAsBytes)
+}
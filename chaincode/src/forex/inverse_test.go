@@ -0,0 +1,74 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestInversePair(t *testing.T) {
+	stub := newForexStub(t)
+
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.24", "1.26"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "USD", "GBP", "bid"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	responseForex := &forex{}
+	err := json.Unmarshal(response.GetPayload(), responseForex)
+	if err != nil {
+		panic(err)
+	}
+
+	//selling USD for GBP is buying GBP at the GBP:USD ask
+	assert.Equal(t, "USD:GBP", responseForex.Pair, "pair mismatch")
+	assert.True(t, responseForex.Inverted, "pair not marked as inverted")
+	assert.Equal(t, "0.7936507936507937", responseForex.Rate.String(), "rate mismatch")
+	assert.Equal(t, "0.8", responseForex.Mid.String(), "mid rate mismatch")
+}
+
+func TestForexConsistency(t *testing.T) {
+	stub := newForexStub(t)
+
+	pairs := [][]string{{"GBP", "USD", "1.20"}, {"USD", "GBP", "0.80"}, {"EUR", "USD", "1.10"}, {"USD", "EUR", "0.905"}}
+
+	for _, pair := range pairs {
+		response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(append([]string{"createUpdateForexPair"}, pair...)))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"checkForexConsistency", "0.01"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	inconsistencies := []inconsistency{}
+	err := json.Unmarshal(response.GetPayload(), &inconsistencies)
+	if err != nil {
+		panic(err)
+	}
+
+	//1.20 * 0.80 = 0.96 is flagged, 1.10 * 0.905 = 0.9955 is within tolerance
+	assert.Len(t, inconsistencies, 1, "unexpected number of inconsistent pairs")
+	assert.Equal(t, "GBP:USD", inconsistencies[0].Pair, "pair mismatch")
+	assert.Equal(t, "USD:GBP", inconsistencies[0].InversePair, "inverse pair mismatch")
+	assert.Equal(t, "0.04", inconsistencies[0].Deviation.String(), "deviation mismatch")
+}
@@ -0,0 +1,221 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"strings"
+)
+
+// defaultPairPageSize is the number of pairs returned by listForexPairs if no page size is given
+const defaultPairPageSize = 100
+
+// pairPage is a page of currency pairs, Bookmark is passed to listForexPairs to fetch the next page
+// and is empty on the last page
+type pairPage struct {
+	Pairs    []*forex `json:"pairs"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// listForexPairs returns a page of the currency pairs on the ledger, ordered by pair
+//Args:
+//	baseCurrency string Optional, only return pairs with this base currency
+//	pageSize     string Optional, the maximum number of pairs to return. Defaults to 100
+//	bookmark     string Optional, the bookmark returned with the previous page
+func (s *ForexChaincode) listForexPairs(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) > 3 {
+		return shim.Error("Expecting at most 3 arguments, the base currency, page size and bookmark")
+	}
+
+	baseCurrency := ""
+	if len(args) > 0 {
+		baseCurrency = args[0]
+	}
+
+	pageSize := defaultPairPageSize
+	if len(args) > 1 && args[1] != "" {
+		var err error
+		pageSize, err = strconv.Atoi(args[1])
+
+		if err != nil || pageSize <= 0 {
+			return shim.Error("Page size in arg[1] must be a positive number")
+		}
+	}
+
+	bookmark := ""
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+
+	pairs, err := listPairs(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	page := pairPage{Pairs: []*forex{}}
+
+	for _, forexPair := range pairs {
+		if forexPair.Pair <= bookmark || (baseCurrency != "" && !strings.HasPrefix(forexPair.Pair, baseCurrency+":")) {
+			continue
+		}
+
+		if len(page.Pairs) == pageSize {
+			page.Bookmark = page.Pairs[pageSize-1].Pair
+			break
+		}
+
+		page.Pairs = append(page.Pairs, forexPair)
+	}
+
+	pageAsBytes, _ := json.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
+
+// deleteForexPair removes a currency pair, and the submissions for it, from the ledger. Only callable by the
+// administrator MSP
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+func (s *ForexChaincode) deleteForexPair(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments, the base currency and counter currency")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pair := args[0] + ":" + args[1]
+
+	forexPair, err := getPair(stub, args[0], args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if forexPair == nil {
+		return shim.Error("Unknown pair " + pair)
+	}
+
+	err = stub.DelState(pair)
+
+	if err != nil {
+		return shim.Error("Unable to delete pair from ledger " + err.Error())
+	}
+
+	err = indexPair(stub, pair, true)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	submissions, err := getSubmissions(stub, pair)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for _, previous := range submissions {
+		submissionKey, err := stub.CreateCompositeKey("rateSubmission", []string{pair, previous.Provider.MSPID, previous.Provider.Name})
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = stub.DelState(submissionKey)
+
+		if err != nil {
+			return shim.Error("Unable to delete submission from ledger " + err.Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+// bulkUpdateForexPairs submits a rate sheet on behalf of the calling rate provider. Every rate is validated
+// before any are submitted, so either the whole sheet is accepted in one transaction or none of it is.
+// Returns the status of the aggregation for each pair.
+//Args:
+//	rates string A JSON array of rates, e.g. [{"base":"GBP","counter":"USD","bid":"1.19","ask":"1.21","validFor":"86400"}]
+func (s *ForexChaincode) bulkUpdateForexPairs(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument, a JSON array of rates")
+	}
+
+	updates := []rateUpdate{}
+	err := json.Unmarshal([]byte(args[0]), &updates)
+
+	if err != nil {
+		return shim.Error("Unable to unmarshal rates " + err.Error())
+	}
+
+	if len(updates) == 0 {
+		return shim.Error("Rate sheet is empty")
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	provider, err := getRegisteredCaller(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	submissions := []submission{}
+	seen := map[string]bool{}
+
+	for _, update := range updates {
+		rateSubmission, err := update.toSubmission(provider, timestamp.GetSeconds())
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		//writes are not visible to reads in the same transaction, so a pair can only be submitted once
+		if seen[rateSubmission.Pair] {
+			return shim.Error("Pair " + rateSubmission.Pair + " appears more than once in the rate sheet")
+		}
+
+		seen[rateSubmission.Pair] = true
+		submissions = append(submissions, rateSubmission)
+	}
+
+	statuses := []*aggregationStatus{}
+
+	for _, rateSubmission := range submissions {
+		status, err := submitRate(stub, rateSubmission)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	statusesAsBytes, _ := json.Marshal(statuses)
+	return shim.Success(statusesAsBytes)
+}
@@ -0,0 +1,81 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBulkUpdateAndList(t *testing.T) {
+	stub := newForexStub(t)
+
+	//an invalid rate rejects the whole sheet
+	sheet := `[{"base":"GBP","counter":"USD","bid":"1.19","ask":"1.21"},{"base":"EUR","counter":"USD","bid":"-1"}]`
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"bulkUpdateForexPairs", sheet}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "invalid rate sheet accepted")
+	assert.Nil(t, stub.State["GBP:USD"], "pair written from rejected rate sheet")
+
+	sheet = `[{"base":"GBP","counter":"USD","bid":"1.19","ask":"1.21"},{"base":"EUR","counter":"USD","bid":"1.10"},
+		{"base":"GBP","counter":"EUR","bid":"1.15","validFor":"3600"}]`
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"bulkUpdateForexPairs", sheet}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listForexPairs", "GBP", "1"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	page := &pairPage{}
+	err := json.Unmarshal(response.GetPayload(), page)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Len(t, page.Pairs, 1, "page size mismatch")
+	assert.Equal(t, "GBP:EUR", page.Pairs[0].Pair, "pair mismatch")
+	assert.EqualValues(t, 3600, page.Pairs[0].ValidFor, "validity period mismatch")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listForexPairs", "GBP", "1", page.Bookmark}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	page = &pairPage{}
+	err = json.Unmarshal(response.GetPayload(), page)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Len(t, page.Pairs, 1, "page size mismatch")
+	assert.Equal(t, "GBP:USD", page.Pairs[0].Pair, "pair mismatch")
+	assert.Equal(t, "", page.Bookmark, "bookmark returned on the last page")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"deleteForexPair", "GBP", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listForexPairs"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	page = &pairPage{}
+	err = json.Unmarshal(response.GetPayload(), page)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Len(t, page.Pairs, 2, "pair not deleted")
+}
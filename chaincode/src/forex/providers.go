@@ -0,0 +1,456 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strconv"
+)
+
+// rateProvider is an identity authorized to submit rates, stored under the composite key rateProvider~MSPID~Name
+//MSPID - the MSP ID of the provider's organization
+//Name - the common name of the provider's certificate
+type rateProvider struct {
+	MSPID string `json:"mspID"`
+	Name  string `json:"name"`
+}
+
+func (p *rateProvider) key() string {
+	return p.MSPID + "/" + p.Name
+}
+
+// submission is a rate submitted by a provider, stored under the composite key rateSubmission~Pair~MSPID~Name
+type submission struct {
+	Pair      string          `json:"pair"`
+	Provider  rateProvider    `json:"provider"`
+	Bid       decimal.Decimal `json:"bid"`
+	Ask       decimal.Decimal `json:"ask"`
+	ValidFor  int64           `json:"validFor"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// aggregationPolicy is stored on the ledger under the key "aggregationPolicy"
+//Quorum - the number of fresh submissions needed before a rate is published
+//MaxAge - the number of seconds a submission counts towards the quorum for
+type aggregationPolicy struct {
+	Quorum int   `json:"quorum"`
+	MaxAge int64 `json:"maxAge"`
+}
+
+// aggregationStatus is returned by createUpdateForexPair
+//Submissions - the number of fresh submissions for the pair, including this one
+//Published - true if the quorum was reached and the pair was written to the ledger
+//Pending - set if the quorum was reached but the rate tripped the pair's circuit breaker and awaits approval
+type aggregationStatus struct {
+	Pair        string       `json:"pair"`
+	Submissions int          `json:"submissions"`
+	Quorum      int          `json:"quorum"`
+	Published   bool         `json:"published"`
+	Rate        *forex       `json:"rate,omitempty"`
+	Pending     *pendingRate `json:"pending,omitempty"`
+}
+
+// defaultAggregationPolicy publishes every submission straight away
+var defaultAggregationPolicy = aggregationPolicy{Quorum: 1, MaxAge: 5 * 60}
+
+// getCaller returns the identity invoking the transaction
+func getCaller(stub shim.ChaincodeStubInterface) (*rateProvider, error) {
+	identity, err := cid.New(stub)
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	mspID, err := identity.GetMSPID()
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	cert, err := identity.GetX509Certificate()
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	return &rateProvider{MSPID: mspID, Name: cert.Subject.CommonName}, nil
+}
+
+// getRegisteredCaller returns the identity invoking the transaction, or an error if it is not a registered rate provider
+func getRegisteredCaller(stub shim.ChaincodeStubInterface) (*rateProvider, error) {
+	caller, err := getCaller(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	registered, err := isRegistered(stub, caller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !registered {
+		return nil, errors.New(caller.key() + " is not a registered rate provider")
+	}
+
+	return caller, nil
+}
+
+func isRegistered(stub shim.ChaincodeStubInterface, provider *rateProvider) (bool, error) {
+	providerKey, err := stub.CreateCompositeKey("rateProvider", []string{provider.MSPID, provider.Name})
+
+	if err != nil {
+		return false, err
+	}
+
+	providerAsBytes, err := stub.GetState(providerKey)
+
+	if err != nil {
+		return false, errors.New("Unable to retrieve rate provider from ledger " + err.Error())
+	}
+
+	return providerAsBytes != nil, nil
+}
+
+// checkAdmin returns an error unless the caller belongs to the MSP set as the administrator when the chaincode was instantiated
+func checkAdmin(stub shim.ChaincodeStubInterface) error {
+	adminMSP, err := stub.GetState("adminMSP")
+
+	if err != nil {
+		return errors.New("Unable to retrieve administrator from ledger " + err.Error())
+	}
+
+	if adminMSP == nil {
+		return errors.New("No administrator MSP has been set, instantiate the chaincode with the administrator MSP ID")
+	}
+
+	caller, err := getCaller(stub)
+
+	if err != nil {
+		return err
+	}
+
+	if caller.MSPID != string(adminMSP) {
+		return errors.New(caller.key() + " is not a member of the administrator MSP")
+	}
+
+	return nil
+}
+
+// registerRateProvider authorizes an identity to submit rates, only callable by the administrator MSP
+//Args:
+//	MSPID string The MSP ID of the provider's organization
+//	Name  string The common name of the provider's certificate
+func (s *ForexChaincode) registerRateProvider(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments, the MSP ID and common name of the rate provider")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	provider := rateProvider{MSPID: args[0], Name: args[1]}
+	providerKey, err := stub.CreateCompositeKey("rateProvider", []string{provider.MSPID, provider.Name})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	providerAsBytes, _ := json.Marshal(provider)
+	err = stub.PutState(providerKey, providerAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit rate provider to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// removeRateProvider revokes an identity's authorization to submit rates, its submissions no longer count
+// towards the quorum. Only callable by the administrator MSP
+//Args:
+//	MSPID string The MSP ID of the provider's organization
+//	Name  string The common name of the provider's certificate
+func (s *ForexChaincode) removeRateProvider(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments, the MSP ID and common name of the rate provider")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	providerKey, err := stub.CreateCompositeKey("rateProvider", args)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(providerKey)
+
+	if err != nil {
+		return shim.Error("Unable to remove rate provider from ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// listRateProviders returns the registered rate providers
+func (s *ForexChaincode) listRateProviders(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("rateProvider", []string{})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	providers := []rateProvider{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		provider := rateProvider{}
+		err = json.Unmarshal(queryResponse.Value, &provider)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		providers = append(providers, provider)
+	}
+
+	providersAsBytes, _ := json.Marshal(providers)
+	return shim.Success(providersAsBytes)
+}
+
+// setAggregationPolicy sets how many fresh submissions are needed to publish a rate, only callable by the administrator MSP
+//Args:
+//	Quorum string The number of fresh submissions needed to publish a rate
+//	MaxAge string The number of seconds a submission counts towards the quorum for
+func (s *ForexChaincode) setAggregationPolicy(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments, the quorum and the maximum age of a submission in seconds")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	quorum, err := strconv.Atoi(args[0])
+
+	if err != nil || quorum < 1 {
+		return shim.Error("Quorum must be a positive number")
+	}
+
+	maxAge, err := strconv.ParseInt(args[1], 10, 64)
+
+	if err != nil || maxAge < 1 {
+		return shim.Error("Maximum age must be a positive number of seconds")
+	}
+
+	policyAsBytes, _ := json.Marshal(aggregationPolicy{Quorum: quorum, MaxAge: maxAge})
+	err = stub.PutState("aggregationPolicy", policyAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit aggregation policy to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func getAggregationPolicy(stub shim.ChaincodeStubInterface) (*aggregationPolicy, error) {
+	policy := defaultAggregationPolicy
+
+	policyAsBytes, err := stub.GetState("aggregationPolicy")
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve aggregation policy from ledger " + err.Error())
+	}
+
+	if policyAsBytes != nil {
+		err = json.Unmarshal(policyAsBytes, &policy)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal aggregation policy " + err.Error())
+		}
+	}
+
+	return &policy, nil
+}
+
+// submitRate records a provider's submission for a pair. If, including this submission, the pair has at least a
+// quorum of submissions from registered providers that are no older than the maximum age, the pair is published
+// with the median bid and ask of those submissions and the shortest validity period among them, unless the rate
+// trips the pair's circuit breaker, in which case it is parked for approval.
+func submitRate(stub shim.ChaincodeStubInterface, rateSubmission submission) (*aggregationStatus, error) {
+	policy, err := getAggregationPolicy(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	submissions, err := getSubmissions(stub, rateSubmission.Pair)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//writes are not visible to reads in the same transaction, so replace the provider's previous submission by hand
+	fresh := []submission{rateSubmission}
+
+	for _, previous := range submissions {
+		if previous.Provider == rateSubmission.Provider || rateSubmission.Timestamp-previous.Timestamp > policy.MaxAge {
+			continue
+		}
+
+		registered, err := isRegistered(stub, &previous.Provider)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if registered {
+			fresh = append(fresh, previous)
+		}
+	}
+
+	submissionKey, err := stub.CreateCompositeKey("rateSubmission", []string{rateSubmission.Pair, rateSubmission.Provider.MSPID, rateSubmission.Provider.Name})
+
+	if err != nil {
+		return nil, err
+	}
+
+	submissionAsBytes, _ := json.Marshal(rateSubmission)
+	err = stub.PutState(submissionKey, submissionAsBytes)
+
+	if err != nil {
+		return nil, errors.New("Unable to commit submission to ledger " + err.Error())
+	}
+
+	status := &aggregationStatus{Pair: rateSubmission.Pair, Submissions: len(fresh), Quorum: policy.Quorum}
+
+	if len(fresh) < policy.Quorum {
+		return status, nil
+	}
+
+	bids := []decimal.Decimal{}
+	asks := []decimal.Decimal{}
+	validFor := rateSubmission.ValidFor
+
+	for _, freshSubmission := range fresh {
+		bids = append(bids, freshSubmission.Bid)
+		asks = append(asks, freshSubmission.Ask)
+
+		if freshSubmission.ValidFor < validFor {
+			validFor = freshSubmission.ValidFor
+		}
+	}
+
+	bid := median(bids)
+	ask := median(asks)
+
+	status.Pending, err = tripCircuitBreaker(stub, rateSubmission, bid, ask, validFor)
+
+	if err != nil || status.Pending != nil {
+		return status, err
+	}
+
+	status.Rate, err = putPair(stub, rateSubmission.Pair, bid, ask, rateSubmission.Timestamp, validFor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//a rate published within the limit supersedes any rate awaiting approval
+	pendingKey, err := stub.CreateCompositeKey("pendingRate", []string{rateSubmission.Pair})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.DelState(pendingKey)
+
+	if err != nil {
+		return nil, errors.New("Unable to delete pending rate from ledger " + err.Error())
+	}
+
+	status.Published = true
+	return status, nil
+}
+
+// getSubmissions returns the submissions on the ledger for a pair
+func getSubmissions(stub shim.ChaincodeStubInterface, pair string) ([]submission, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("rateSubmission", []string{pair})
+
+	if err != nil {
+		return nil, errors.New("Unable to query submissions " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	submissions := []submission{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		previous := submission{}
+		err = json.Unmarshal(queryResponse.Value, &previous)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal submission " + err.Error())
+		}
+
+		submissions = append(submissions, previous)
+	}
+
+	return submissions, nil
+}
+
+// median returns the middle value, or the mean of the two middle values, of a non-empty list
+func median(values []decimal.Decimal) decimal.Decimal {
+	sorted := append([]decimal.Decimal{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LessThan(sorted[j]) })
+
+	middle := len(sorted) / 2
+
+	if len(sorted)%2 == 1 {
+		return sorted[middle]
+	}
+
+	return sorted[middle-1].Add(sorted[middle]).Div(decimal.New(2, 0))
+}
@@ -0,0 +1,89 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestRateProviders(t *testing.T) {
+	stub := newForexStub(t)
+	admin := stub.Creator
+
+	//identities outside the administrator MSP cannot register providers
+	stub.Creator = testutil.NewIdentity("Org2MSP", "oracle")
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"registerRateProvider", "Org2MSP", "oracle"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "provider registered by non administrator")
+
+	//unregistered identities cannot submit rates
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "12.0"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "rate accepted from unregistered provider")
+	assert.Equal(t, "Org2MSP/oracle is not a registered rate provider", response.Message, "unexpected error")
+
+	stub.Creator = admin
+	for _, args := range [][]string{{"registerRateProvider", "Org2MSP", "oracle"}, {"registerRateProvider", "Org3MSP", "oracle"}, {"setAggregationPolicy", "2", "300"}} {
+		response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	//the first submission is below the quorum so nothing is published
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.18", "1.22"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	status := &aggregationStatus{}
+	err := json.Unmarshal(response.GetPayload(), status)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.False(t, status.Published, "rate published below quorum")
+	assert.Nil(t, stub.State["GBP:USD"], "rate published below quorum")
+
+	stub.Creator = testutil.NewIdentity("Org2MSP", "oracle")
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.20", "1.24"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	stub.Creator = testutil.NewIdentity("Org3MSP", "oracle")
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "12.0", "12.1"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	status = &aggregationStatus{}
+	err = json.Unmarshal(response.GetPayload(), status)
+	if err != nil {
+		panic(err)
+	}
+
+	//the median of three submissions ignores the outlier
+	assert.True(t, status.Published, "rate not published once quorum reached")
+	assert.Equal(t, 3, status.Submissions, "submission count mismatch")
+
+	forexPair := &forex{}
+	err = json.Unmarshal(stub.State["GBP:USD"], forexPair)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.True(t, decimal.New(120, -2).Equal(forexPair.Bid), "bid mismatch")
+	assert.True(t, decimal.New(124, -2).Equal(forexPair.Ask), "ask mismatch")
+}
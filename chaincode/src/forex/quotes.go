@@ -0,0 +1,340 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"invocation"
+	"strconv"
+)
+
+// quote is a rate locked for a period of time, stored under the composite key quote~ID
+//ID - the ID of the transaction that created the quote
+//BankID, Account - the bank and account number the quote was given to, only conversions from that account can use it
+//MaxAmount - the largest amount of the base currency the quote can convert
+//Expiry - the time, in seconds since the epoch, after which the quote can no longer be used
+//Consumed - true once the quote has been used for a conversion, a quote can only be used once
+type quote struct {
+	ID        string          `json:"id"`
+	Pair      string          `json:"pair"`
+	Side      string          `json:"side"`
+	Rate      decimal.Decimal `json:"rate"`
+	BankID    string          `json:"bankID"`
+	Account   string          `json:"account"`
+	MaxAmount decimal.Decimal `json:"maxAmount"`
+	Expiry    int64           `json:"expiry"`
+	Consumed  bool            `json:"consumed"`
+}
+
+// defaultQuoteValidFor is the number of seconds a quote is valid for if no period is given
+const defaultQuoteValidFor = 60
+
+// createQuote locks the current rate for a currency pair and returns a quote that can be used once before it
+// expires, for a conversion of at most the maximum amount from the given account
+//Args:
+//	baseCurrency    string The base currency of the pair
+//	counterCurrency string The counter currency of the pair
+//	bankID          string The ID of the bank holding the account
+//	accNum          string The number of the account the conversion is made from
+//	maxAmount       string The largest amount of the base currency the quote can convert
+//	side            string Optional, one of bid, ask or mid. Defaults to bid, the side transfers convert at
+//	validFor        string Optional, the number of seconds the quote is valid for. Defaults to 60
+func (s *ForexChaincode) createQuote(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) < 5 || len(args) > 7 {
+		return shim.Error("Expecting 5 to 7 arguments, base currency, counter currency, bank ID, account number, maximum amount and optionally the side and validity period in seconds")
+	}
+
+	maxAmount, err := decimal.NewFromString(args[4])
+
+	if err != nil || !maxAmount.IsPositive() {
+		return shim.Error("Maximum amount in arg[4] must be a positive number")
+	}
+
+	side := sideBid
+	if len(args) > 5 {
+		side = args[5]
+	}
+
+	var validFor int64 = defaultQuoteValidFor
+	if len(args) > 6 {
+		validFor, err = strconv.ParseInt(args[6], 10, 64)
+
+		if err != nil || validFor <= 0 {
+			return shim.Error("Validity period in arg[6] must be a positive number of seconds")
+		}
+	}
+
+	forexPair, err := resolvePair(stub, args[0], args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if forexPair == nil {
+		return shim.Error("No rate available for " + args[0] + ":" + args[1])
+	}
+
+	err = checkSuspended(stub, forexPair)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	if forexPair.isStale(timestamp.GetSeconds()) {
+		return shim.Error(forexPair.staleError().Error())
+	}
+
+	err = forexPair.withSide(side)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	newQuote := quote{ID: stub.GetTxID(), Pair: forexPair.Pair, Side: side, Rate: forexPair.Rate, BankID: args[2], Account: args[3],
+		MaxAmount: maxAmount, Expiry: timestamp.GetSeconds() + validFor}
+
+	err = putQuote(stub, &newQuote)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	quoteAsBytes, _ := json.Marshal(newQuote)
+	return shim.Success(quoteAsBytes)
+}
+
+// consumeQuote marks a quote as used and returns it. Returns an error if the quote does not exist, is not for
+// the given pair, account or amount, has expired, has already been used or the pair has since been suspended.
+// Only transactions entered through a contract authorized with authorizeQuoteConsumer can use a quote, so a
+// quote is only consumed by the bank or interbank contract that debits the account
+//Args:
+//	quoteID         string The ID of the quote
+//	baseCurrency    string The base currency of the conversion
+//	counterCurrency string The counter currency of the conversion
+//	bankID          string The ID of the bank holding the debited account
+//	accNum          string The number of the debited account
+//	amount          string The amount of the base currency converted
+func (s *ForexChaincode) consumeQuote(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 6 {
+		return shim.Error("Expecting 6 arguments, the quote ID, base currency, counter currency, bank ID, account number and amount")
+	}
+
+	err := checkQuoteConsumer(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	lockedQuote, err := getQuote(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if lockedQuote.Pair != args[1]+":"+args[2] {
+		return shim.Error("Quote " + lockedQuote.ID + " is for " + lockedQuote.Pair + " not " + args[1] + ":" + args[2])
+	}
+
+	if lockedQuote.BankID != args[3] || lockedQuote.Account != args[4] {
+		return shim.Error("Quote " + lockedQuote.ID + " was not given to account " + args[4] + " at bank " + args[3])
+	}
+
+	amount, err := decimal.NewFromString(args[5])
+
+	if err != nil || !amount.IsPositive() {
+		return shim.Error("Amount in arg[5] must be a positive number")
+	}
+
+	if amount.GreaterThan(lockedQuote.MaxAmount) {
+		return shim.Error("Quote " + lockedQuote.ID + " converts at most " + lockedQuote.MaxAmount.String() + ", not " + amount.String())
+	}
+
+	if lockedQuote.Consumed {
+		return shim.Error("Quote " + lockedQuote.ID + " has already been used")
+	}
+
+	err = checkSuspended(stub, &forex{Pair: lockedQuote.Pair})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	if timestamp.GetSeconds() >= lockedQuote.Expiry {
+		return shim.Error("Quote " + lockedQuote.ID + " has expired")
+	}
+
+	lockedQuote.Consumed = true
+	err = putQuote(stub, lockedQuote)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	quoteAsBytes, _ := json.Marshal(lockedQuote)
+	return shim.Success(quoteAsBytes)
+}
+
+// getQuoteByID returns a quote
+//Args:
+//	quoteID string The ID of the quote
+func (s *ForexChaincode) getQuoteByID(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument, the quote ID")
+	}
+
+	lockedQuote, err := getQuote(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	quoteAsBytes, _ := json.Marshal(lockedQuote)
+	return shim.Success(quoteAsBytes)
+}
+
+func getQuote(stub shim.ChaincodeStubInterface, quoteID string) (*quote, error) {
+	quoteKey, err := stub.CreateCompositeKey("quote", []string{quoteID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	quoteAsBytes, err := stub.GetState(quoteKey)
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve quote from ledger " + err.Error())
+	}
+
+	if quoteAsBytes == nil {
+		return nil, errors.New("Unknown quote " + quoteID)
+	}
+
+	lockedQuote := &quote{}
+	err = json.Unmarshal(quoteAsBytes, lockedQuote)
+
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal quote " + err.Error())
+	}
+
+	return lockedQuote, nil
+}
+
+func putQuote(stub shim.ChaincodeStubInterface, lockedQuote *quote) error {
+	quoteKey, err := stub.CreateCompositeKey("quote", []string{lockedQuote.ID})
+
+	if err != nil {
+		return err
+	}
+
+	quoteAsBytes, _ := json.Marshal(lockedQuote)
+	err = stub.PutState(quoteKey, quoteAsBytes)
+
+	if err != nil {
+		return errors.New("Unable to commit quote to ledger " + err.Error())
+	}
+
+	return nil
+}
+
+// authorizeQuoteConsumer lets transactions entered through a contract, such as a bank contract, use quotes. Only
+// callable by the administrator MSP
+//Args:
+//	contract string The name of the chaincode
+func (s *ForexChaincode) authorizeQuoteConsumer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return setQuoteConsumer(stub, args, false)
+}
+
+// revokeQuoteConsumer stops transactions entered through a contract from using quotes. Only callable by the
+// administrator MSP
+//Args:
+//	contract string The name of the chaincode
+func (s *ForexChaincode) revokeQuoteConsumer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return setQuoteConsumer(stub, args, true)
+}
+
+func setQuoteConsumer(stub shim.ChaincodeStubInterface, args []string, remove bool) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument, the name of the contract")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	consumerKey, err := stub.CreateCompositeKey("quoteConsumer", args)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if remove {
+		err = stub.DelState(consumerKey)
+	} else {
+		err = stub.PutState(consumerKey, []byte{0x00})
+	}
+
+	if err != nil {
+		return shim.Error("Unable to commit quote consumer to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkQuoteConsumer returns an error unless the transaction was entered through an authorized quote consumer
+func checkQuoteConsumer(stub shim.ChaincodeStubInterface) error {
+	contract, err := invocation.TopLevelChaincode(stub)
+
+	if err != nil {
+		return err
+	}
+
+	consumerKey, err := stub.CreateCompositeKey("quoteConsumer", []string{contract})
+
+	if err != nil {
+		return err
+	}
+
+	consumerAsBytes, err := stub.GetState(consumerKey)
+
+	if err != nil {
+		return errors.New("Unable to retrieve quote consumer from ledger " + err.Error())
+	}
+
+	if consumerAsBytes == nil {
+		return errors.New("Quotes can not be used through " + contract + ", it is not an authorized quote consumer")
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+// quoteConsumer forwards its invocations to the forex contract, standing in for a bank contract using a quote
+type quoteConsumer struct{}
+
+func (c *quoteConsumer) Init(stub shim.ChaincodeStubInterface) sc.Response {
+	return shim.Success(nil)
+}
+
+func (c *quoteConsumer) Invoke(stub shim.ChaincodeStubInterface) sc.Response {
+	return stub.InvokeChaincode("forex", stub.GetArgs(), "")
+}
+
+func TestQuote(t *testing.T) {
+	stub := newForexStub(t)
+	bankStub := testutil.NewMockStub("bank", new(quoteConsumer))
+	bankStub.MockPeerChaincode("forex", stub)
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "teller")
+
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.19", "1.21"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createQuote", "GBP", "USD", "0001", "2", "0"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "quote created without a maximum amount")
+
+	quoteTxID := uuid.New().String()
+	response = stub.MockInvoke(quoteTxID, util.ArrayToChaincodeArgs([]string{"createQuote", "GBP", "USD", "0001", "2", "100"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	lockedQuote := &quote{}
+	err := json.Unmarshal(response.GetPayload(), lockedQuote)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, quoteTxID, lockedQuote.ID, "quote ID mismatch")
+	assert.Equal(t, "1.19", lockedQuote.Rate.String(), "quote not locked at the bid rate")
+	assert.Equal(t, "0001/2", lockedQuote.BankID+"/"+lockedQuote.Account, "quote not bound to the account")
+
+	//the quoted rate is used even though the rate has moved
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.30"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//only transactions entered through an authorized contract can use a quote
+	consume := []string{"consumeQuote", lockedQuote.ID, "GBP", "USD", "0001", "2", "100"}
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(consume))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "quote used outside a bank contract")
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(consume))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "quote used through an unauthorized contract")
+	assert.Contains(t, response.Message, "not an authorized quote consumer", "unexpected error")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"authorizeQuoteConsumer", "bank"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	for _, args := range [][]string{{"USD", "GBP", "0001", "2", "100"}, {"GBP", "USD", "0001", "3", "100"}, {"GBP", "USD", "0002", "2", "100"},
+		{"GBP", "USD", "0001", "2", "100.01"}} {
+		response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(append([]string{"consumeQuote", lockedQuote.ID}, args...)))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "quote used for another pair, account or a larger amount")
+	}
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(consume))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	consumedQuote := &quote{}
+	err = json.Unmarshal(response.GetPayload(), consumedQuote)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "1.19", consumedQuote.Rate.String(), "rate mismatch")
+	assert.True(t, consumedQuote.Consumed, "quote not marked as used")
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(consume))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "quote used twice")
+	assert.Contains(t, response.Message, "has already been used", "unexpected error")
+
+	//a quote cannot be used once it has expired
+	expiredTxID := uuid.New().String()
+	response = stub.MockInvoke(expiredTxID, util.ArrayToChaincodeArgs([]string{"createQuote", "GBP", "USD", "0001", "2", "100", "bid", "1"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	expiredQuote, _ := getQuote(stub, expiredTxID)
+	expiredQuote.Expiry -= 10
+	stub.MockTransactionStart(expiredTxID)
+	putQuote(stub, expiredQuote)
+	stub.MockTransactionEnd(expiredTxID)
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"consumeQuote", expiredTxID, "GBP", "USD", "0001", "2", "100"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "expired quote used")
+	assert.Contains(t, response.Message, "has expired", "unexpected error")
+}
@@ -0,0 +1,221 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+)
+
+// setPivotCurrency sets the currency used to derive cross rates when a pair is not on the ledger,
+// e.g. with a pivot of USD a GBP:JPY rate is derived from GBP:USD and USD:JPY. Only callable by the
+// administrator MSP
+//Args:
+//	pivotCurrency string The pivot currency, or an empty string to remove the pivot currency
+func (s *ForexChaincode) setPivotCurrency(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument, the pivot currency")
+	}
+
+	err := checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if args[0] == "" {
+		err = stub.DelState("pivotCurrency")
+	} else {
+		err = stub.PutState("pivotCurrency", []byte(args[0]))
+	}
+
+	if err != nil {
+		return shim.Error("Unable to commit pivot currency to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// resolvePair returns the rate for a currency pair. If neither the pair or its inverse are on the ledger
+// the rate is derived through the pivot currency, if one is set and both legs exist, otherwise through
+// the shortest path across the pairs on the ledger. Returns nil if no rate can be derived.
+func resolvePair(stub shim.ChaincodeStubInterface, baseCurrency string, counterCurrency string) (*forex, error) {
+	forexPair, err := getPairOrInverse(stub, baseCurrency, counterCurrency)
+
+	if err != nil || forexPair != nil {
+		return forexPair, err
+	}
+
+	pivotAsBytes, err := stub.GetState("pivotCurrency")
+
+	if err != nil {
+		return nil, err
+	}
+
+	pivot := string(pivotAsBytes)
+
+	if pivot != "" && pivot != baseCurrency && pivot != counterCurrency {
+		baseLeg, err := getPairOrInverse(stub, baseCurrency, pivot)
+
+		if err != nil {
+			return nil, err
+		}
+
+		counterLeg, err := getPairOrInverse(stub, pivot, counterCurrency)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if baseLeg != nil && counterLeg != nil {
+			return crossRate([]string{baseCurrency, pivot, counterCurrency}, []*forex{baseLeg, counterLeg}), nil
+		}
+	}
+
+	return shortestPath(stub, baseCurrency, counterCurrency)
+}
+
+// shortestPath searches the pairs on the ledger, and their inverses, for the path between two currencies
+// with the fewest conversions and derives the rate along it
+func shortestPath(stub shim.ChaincodeStubInterface, baseCurrency string, counterCurrency string) (*forex, error) {
+	pairs, err := listPairs(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//breadth first search, recording the leg used to first reach each currency
+	legTo := map[string]*forex{}
+	visited := map[string]bool{baseCurrency: true}
+	queue := []string{baseCurrency}
+
+	for len(queue) > 0 && !visited[counterCurrency] {
+		currency := queue[0]
+		queue = queue[1:]
+
+		for _, forexPair := range pairs {
+			currencies := strings.Split(forexPair.Pair, ":")
+
+			leg := forexPair
+			next := currencies[1]
+
+			if currencies[1] == currency {
+				leg = invertPair(forexPair)
+				next = currencies[0]
+			} else if currencies[0] != currency {
+				continue
+			}
+
+			if visited[next] {
+				continue
+			}
+
+			visited[next] = true
+			legTo[next] = leg
+			queue = append(queue, next)
+		}
+	}
+
+	if !visited[counterCurrency] {
+		return nil, nil
+	}
+
+	//walk back from the counter currency to build the path
+	path := []string{counterCurrency}
+	legs := []*forex{}
+
+	for currency := counterCurrency; currency != baseCurrency; {
+		leg := legTo[currency]
+		currency = strings.Split(leg.Pair, ":")[0]
+		path = append([]string{currency}, path...)
+		legs = append([]*forex{leg}, legs...)
+	}
+
+	return crossRate(path, legs), nil
+}
+
+// crossRate multiplies the rates of each leg along a path. The derived rate takes the validity of the
+// leg that expires first, so it is stale as soon as any of its legs are.
+func crossRate(path []string, legs []*forex) *forex {
+	derived := &forex{Pair: path[0] + ":" + path[len(path)-1], Bid: legs[0].Bid, Ask: legs[0].Ask, Mid: legs[0].Mid,
+		Timestamp: legs[0].Timestamp, ValidFor: legs[0].ValidFor, Path: path}
+
+	for i, leg := range legs {
+		if i > 0 {
+			derived.Bid = derived.Bid.Mul(leg.Bid)
+			derived.Ask = derived.Ask.Mul(leg.Ask)
+			derived.Mid = derived.Mid.Mul(leg.Mid)
+		}
+
+		if leg.ValidFor > 0 && (derived.ValidFor == 0 || leg.Timestamp+leg.ValidFor < derived.Timestamp+derived.ValidFor) {
+			derived.Timestamp = leg.Timestamp
+			derived.ValidFor = leg.ValidFor
+		}
+	}
+
+	derived.Rate = derived.Mid
+	return derived
+}
+
+// listPairs returns every currency pair stored on the ledger, in key order, using the forexPair index
+func listPairs(stub shim.ChaincodeStubInterface) ([]*forex, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("forexPair", []string{})
+
+	if err != nil {
+		return nil, errors.New("Unable to query pairs " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	pairs := []*forex{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, currencies, err := stub.SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pair := strings.Join(currencies, ":")
+		pairAsBytes, err := stub.GetState(pair)
+
+		if err != nil {
+			return nil, errors.New("Unable to retrieve pair " + pair + " from ledger " + err.Error())
+		}
+
+		if pairAsBytes == nil {
+			continue
+		}
+
+		forexPair, err := unmarshalPair(pair, pairAsBytes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pairs = append(pairs, forexPair)
+	}
+
+	return pairs, nil
+}
@@ -0,0 +1,71 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package forex
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCrossRate(t *testing.T) {
+	stub := newForexStub(t)
+
+	pairs := [][]string{{"GBP", "USD", "1.20"}, {"USD", "JPY", "150"}, {"GBP", "EUR", "1.15"}, {"EUR", "CHF", "0.95"}, {"CHF", "JPY", "160"}}
+
+	for _, pair := range pairs {
+		response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(append([]string{"createUpdateForexPair"}, pair...)))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	//without a pivot currency the shortest path is used
+	response := stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "GBP", "JPY"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	responseForex := &forex{}
+	err := json.Unmarshal(response.GetPayload(), responseForex)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "GBP:JPY", responseForex.Pair, "pair mismatch")
+	assert.Equal(t, "180", responseForex.Rate.String(), "rate mismatch")
+	assert.Equal(t, []string{"GBP", "USD", "JPY"}, responseForex.Path, "path mismatch")
+
+	//the pivot currency is preferred over the shortest path
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setPivotCurrency", "EUR"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "GBP", "CHF"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	responseForex = &forex{}
+	err = json.Unmarshal(response.GetPayload(), responseForex)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "1.0925", responseForex.Rate.String(), "rate mismatch")
+	assert.Equal(t, []string{"GBP", "EUR", "CHF"}, responseForex.Path, "path mismatch")
+
+	response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getForexPair", "JPY", "AUD"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "rate returned for pair with no path")
+	assert.Equal(t, "No rate available for JPY:AUD", response.Message, "unexpected error")
+}
@@ -0,0 +1,114 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// caller is the identity invoking a transaction
+//MSPID - the MSP ID of the caller's organization
+//Name - the common name of the caller's certificate
+type caller struct {
+	MSPID string
+	Name  string
+}
+
+func (c *caller) key() string {
+	return c.MSPID + "/" + c.Name
+}
+
+// getCaller returns the identity invoking the transaction
+func getCaller(stub shim.ChaincodeStubInterface) (*caller, error) {
+	identity, err := cid.New(stub)
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	mspID, err := identity.GetMSPID()
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	cert, err := identity.GetX509Certificate()
+
+	if err != nil {
+		return nil, errors.New("Unable to identify caller " + err.Error())
+	}
+
+	return &caller{MSPID: mspID, Name: cert.Subject.CommonName}, nil
+}
+
+// checkGovernance returns the caller, or an error unless the caller belongs to the network governance MSP set
+// when the chaincode was instantiated
+func checkGovernance(stub shim.ChaincodeStubInterface) (*caller, error) {
+	governanceMSP, err := stub.GetState("governanceMSP")
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve governance MSP from ledger " + err.Error())
+	}
+
+	if governanceMSP == nil {
+		return nil, errors.New("No governance MSP has been set, instantiate the chaincode with the governance MSP ID")
+	}
+
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if invoker.MSPID != string(governanceMSP) {
+		return nil, errors.New(invoker.key() + " is not a member of the governance MSP")
+	}
+
+	return invoker, nil
+}
+
+// checkSender returns the route of the sending bank, or an error unless the transaction was signed by a member of
+// the MSP registered for that route and the route is not suspended
+func checkSender(stub shim.ChaincodeStubInterface, fromBankID string) (*route, error) {
+	fromRoute, err := getKnownRoute(stub, fromBankID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fromRoute.Suspended {
+		return nil, errors.New("Route to bank " + fromBankID + " is suspended, it cannot send transfers")
+	}
+
+	if fromRoute.BankMSP == "" {
+		return nil, errors.New("The route to bank " + fromBankID + " has no bank MSP, update the route before sending transfers")
+	}
+
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if invoker.MSPID != fromRoute.BankMSP {
+		return nil, errors.New(invoker.key() + " is not a member of " + fromRoute.BankMSP + ", the MSP of bank " + fromBankID)
+	}
+
+	return fromRoute, nil
+}
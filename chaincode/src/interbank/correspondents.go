@@ -0,0 +1,417 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"rounding"
+	"sort"
+)
+
+// correspondent is a relationship setting the fee one bank keeps from payments another bank sends it, stored under the
+// composite key correspondent~From~To. Payments only pass between banks along relationships, a fee of 0 linking two
+// banks directly at no charge. Relationships are directional, payments back need a relationship of their own
+//From - the ID of the bank sending payments
+//To - the ID of the bank receiving payments
+//Fee - the percentage of each payment the receiving bank keeps as its fee, e.g. 0.1 for 0.1%
+type correspondent struct {
+	From string          `json:"from"`
+	To   string          `json:"to"`
+	Fee  decimal.Decimal `json:"fee"`
+}
+
+// obligation is the running amount one bank owes another for payments made over a correspondent relationship in the
+// open settlement cycle, stored under the composite key obligation~From~To~Currency. Exposures between banks are
+// kept in the same form under exposure~From~To~Currency
+type obligation struct {
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Currency string          `json:"currency"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+// hop is one leg of an interbank transfer, Amount is what From pays To and Fee is what To keeps from it
+type hop struct {
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Amount decimal.Decimal `json:"amount"`
+	Fee    decimal.Decimal `json:"fee"`
+}
+
+// leg is the part of a payment a bank on its path books with its contract's receiveInterbank. The bank received Amount
+// from From and keeps Fee, forwarding the rest to To. The beneficiary's bank has no To, and instead credits Credit to
+// Account, the payment having been sent by Originator
+type leg struct {
+	From       string          `json:"from"`
+	To         string          `json:"to,omitempty"`
+	Amount     decimal.Decimal `json:"amount"`
+	Fee        decimal.Decimal `json:"fee"`
+	Currency   string          `json:"currency"`
+	Originator string          `json:"originator,omitempty"`
+	Account    string          `json:"account,omitempty"`
+	Credit     decimal.Decimal `json:"credit"`
+}
+
+// transferResult is returned by interbankTransfer
+//ID - the ID of the transfer record
+//Path - the bank IDs the payment passed through, from the sending bank to the receiving bank
+//Hops - the amount paid and fee kept on each leg of the path, in the currency of the transfer
+//Amount - the amount credited to the recipient, in the currency of the recipient's account
+//Currency - the currency of the recipient's account
+//Status - the status of the transfer record, queued transfers have not yet credited the recipient and returned
+//		transfers never will
+//Cycle - the settlement cycle the hops will be settled in, zero when they were settled gross
+//ReasonCode, Reason - why a transfer was queued or returned, the reason code is only set for returns
+type transferResult struct {
+	ID         string          `json:"id"`
+	Path       []string        `json:"path"`
+	Hops       []hop           `json:"hops"`
+	Amount     decimal.Decimal `json:"amount"`
+	Currency   string          `json:"currency"`
+	Status     string          `json:"status"`
+	Cycle      int64           `json:"cycle,omitempty"`
+	ReasonCode string          `json:"reasonCode,omitempty"`
+	Reason     string          `json:"reason,omitempty"`
+}
+
+//setCorrespondent creates or updates a relationship setting the fee a bank keeps from payments another bank sends it.
+//Callable by the governance MSP or by the MSP of the receiving bank
+//Args
+//	From	string	the ID of the bank sending payments
+//	To		string	the ID of the bank receiving payments
+//	Fee		string	the percentage of each payment the receiving bank keeps, 0 for none
+func (s *InterbankChaincode) setCorrespondent(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return shim.Error("Expecting 3 arguments: sending bank ID, receiving bank ID, fee percentage")
+	}
+
+	if args[0] == args[1] {
+		return shim.Error("A bank cannot be its own correspondent")
+	}
+
+	fee, err := decimal.NewFromString(args[2])
+
+	if err != nil {
+		return shim.Error("Invalid fee " + err.Error())
+	}
+
+	if fee.IsNegative() || fee.GreaterThanOrEqual(decimal.New(100, 0)) {
+		return shim.Error("Fee must be at least 0 and less than 100 percent")
+	}
+
+	for _, bankID := range args[:2] {
+		_, err = getKnownRoute(stub, bankID)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = checkCorrespondentOwner(stub, args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	relationshipKey, err := stub.CreateCompositeKey("correspondent", []string{args[0], args[1]})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	relationshipAsBytes, _ := json.Marshal(correspondent{From: args[0], To: args[1], Fee: fee})
+	err = stub.PutState(relationshipKey, relationshipAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit correspondent to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//removeCorrespondent ends a relationship, payments from one bank to the other must then go through other banks.
+//Callable by the governance MSP or by the MSP of the receiving bank
+//Args
+//	From	string	the ID of the bank sending payments
+//	To		string	the ID of the bank receiving payments
+func (s *InterbankChaincode) removeCorrespondent(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments: sending bank ID, receiving bank ID")
+	}
+
+	relationshipKey, err := stub.CreateCompositeKey("correspondent", []string{args[0], args[1]})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	relationshipAsBytes, err := stub.GetState(relationshipKey)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve correspondent from ledger " + err.Error())
+	}
+
+	if relationshipAsBytes == nil {
+		return shim.Error("Bank " + args[1] + " is not a correspondent of bank " + args[0])
+	}
+
+	err = checkCorrespondentOwner(stub, args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(relationshipKey)
+
+	if err != nil {
+		return shim.Error("Unable to delete correspondent " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//listCorrespondents returns correspondent relationships ordered by sending bank
+//Args
+//	From	string	optional, only return the relationships of this sending bank
+func (s *InterbankChaincode) listCorrespondents(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	relationships, err := getCorrespondents(stub, args...)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	relationshipsAsBytes, _ := json.Marshal(relationships)
+	return shim.Success(relationshipsAsBytes)
+}
+
+//getObligations returns the amounts banks owe each other in the open settlement cycle
+//Args
+//	BankID	string	optional, only return the obligations of this paying bank
+func (s *InterbankChaincode) getObligations(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("obligation", args)
+
+	if err != nil {
+		return shim.Error("Unable to query obligations " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	obligations := []obligation{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		owed := obligation{}
+		err = json.Unmarshal(queryResponse.Value, &owed)
+
+		if err != nil {
+			return shim.Error("Unable to unmarshal obligation " + err.Error())
+		}
+
+		obligations = append(obligations, owed)
+	}
+
+	obligationsAsBytes, _ := json.Marshal(obligations)
+	return shim.Success(obligationsAsBytes)
+}
+
+// checkCorrespondentOwner returns an error unless the caller belongs to the governance MSP or to the MSP of the
+// bank receiving payments under the relationship, as that bank sets its own fee
+func checkCorrespondentOwner(stub shim.ChaincodeStubInterface, toBankID string) error {
+	_, err := checkGovernance(stub)
+
+	if err == nil {
+		return nil
+	}
+
+	toRoute, err := getKnownRoute(stub, toBankID)
+
+	if err != nil {
+		return err
+	}
+
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return err
+	}
+
+	if toRoute.BankMSP == "" || invoker.MSPID != toRoute.BankMSP {
+		return errors.New(invoker.key() + " is not a member of the governance MSP or the MSP of bank " + toBankID)
+	}
+
+	return nil
+}
+
+// getCorrespondents reads correspondent relationships from the ledger, optionally limited to one sending bank
+func getCorrespondents(stub shim.ChaincodeStubInterface, fromBankID ...string) ([]correspondent, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("correspondent", fromBankID)
+
+	if err != nil {
+		return nil, errors.New("Unable to query correspondents " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	relationships := []correspondent{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		relationship := correspondent{}
+		err = json.Unmarshal(queryResponse.Value, &relationship)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal correspondent " + err.Error())
+		}
+
+		relationships = append(relationships, relationship)
+	}
+
+	return relationships, nil
+}
+
+// findPath returns the relationships a payment follows from one bank to another. Only relationships are followed, so
+// two banks are only linked directly if a relationship between them is set. As each bank keeps its fee from what it
+// is forwarded, fees compound and the path delivering the largest share of the payment is chosen, ties going to the
+// path with fewer hops. Banks whose route is suspended or removed are not used
+func findPath(stub shim.ChaincodeStubInterface, fromBankID string, toBankID string) ([]correspondent, error) {
+	routes, err := getRoutes(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	relationships, err := getCorrespondents(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//only follow relationships to banks that can currently receive payments, the sender is checked separately
+	active := map[string]bool{}
+	for _, bankRoute := range routes {
+		active[bankRoute.ID] = !bankRoute.Suspended
+	}
+
+	links := map[string][]correspondent{}
+	for _, relationship := range relationships {
+		if active[relationship.To] {
+			links[relationship.From] = append(links[relationship.From], relationship)
+		}
+	}
+
+	//Dijkstra's algorithm over the relationships, costing each hop by the share of the payment it keeps
+	type visit struct {
+		share decimal.Decimal
+		hops  int
+		via   *correspondent
+	}
+
+	hundred := decimal.New(100, 0)
+	visits := map[string]*visit{fromBankID: {share: decimal.New(1, 0)}}
+	done := map[string]bool{}
+
+	for {
+		//pick the bank with the largest share not yet done, ordering by ID so the choice is deterministic
+		candidates := []string{}
+		for bankID := range visits {
+			if !done[bankID] {
+				candidates = append(candidates, bankID)
+			}
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+
+		sort.Strings(candidates)
+		current := candidates[0]
+		for _, bankID := range candidates[1:] {
+			if cheaper(visits[bankID].share, visits[bankID].hops, visits[current].share, visits[current].hops) {
+				current = bankID
+			}
+		}
+
+		if current == toBankID {
+			break
+		}
+
+		done[current] = true
+
+		for _, link := range links[current] {
+			if done[link.To] || link.To == fromBankID {
+				continue
+			}
+
+			share := visits[current].share.Mul(hundred.Sub(link.Fee)).Div(hundred)
+			hops := visits[current].hops + 1
+			next, seen := visits[link.To]
+
+			if !seen || cheaper(share, hops, next.share, next.hops) {
+				relationship := link
+				visits[link.To] = &visit{share: share, hops: hops, via: &relationship}
+			}
+		}
+	}
+
+	if _, found := visits[toBankID]; !found {
+		return nil, errors.New("No correspondent path from bank " + fromBankID + " to bank " + toBankID)
+	}
+
+	path := []correspondent{}
+	for bankID := toBankID; visits[bankID].via != nil; bankID = visits[bankID].via.From {
+		path = append([]correspondent{*visits[bankID].via}, path...)
+	}
+
+	return path, nil
+}
+
+// cheaper returns true if a path delivering share of a payment in hops is preferred to one delivering otherShare in
+// otherHops
+func cheaper(share decimal.Decimal, hops int, otherShare decimal.Decimal, otherHops int) bool {
+	if !share.Equal(otherShare) {
+		return share.GreaterThan(otherShare)
+	}
+
+	return hops < otherHops
+}
+
+// priceAlongPath works out what is paid on each hop of a path, each receiving bank keeping its fee and forwarding
+// the rest. Returns the hops and the amount reaching the final bank
+func priceAlongPath(path []correspondent, amount decimal.Decimal, currency string, mode string) ([]hop, decimal.Decimal) {
+	hops := []hop{}
+
+	for _, relationship := range path {
+		fee := rounding.Round(amount.Mul(relationship.Fee).Div(decimal.New(100, 0)), currency, mode)
+
+		hops = append(hops, hop{From: relationship.From, To: relationship.To, Amount: amount, Fee: fee})
+		amount = amount.Sub(fee)
+	}
+
+	return hops, amount
+}
@@ -0,0 +1,224 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"bank"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestCorrespondentPath(t *testing.T) {
+	bankStub := testutil.NewMockStub("bank", new(bank.BankChaincode))
+	bank3Stub := testutil.NewMockStub("bank3", new(bank.BankChaincode))
+	bank4Stub := testutil.NewMockStub("bank4", new(bank.BankChaincode))
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.MockPeerChaincode("bank", bankStub)
+	ibankStub.MockPeerChaincode("bank3", bank3Stub)
+	ibankStub.MockPeerChaincode("bank4", bank4Stub)
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	bankStub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex"), []byte("ibank")})
+	bank3Stub.Creator = testutil.NewIdentity("Org3MSP", "operations")
+	bank3Stub.MockInit(uuid.New().String(), [][]byte{[]byte("ThirdBank"), []byte("0003"), []byte("forex"), []byte("ibank")})
+	bank4Stub.Creator = testutil.NewIdentity("Org4MSP", "operations")
+	bank4Stub.MockInit(uuid.New().String(), [][]byte{[]byte("FourthBank"), []byte("0004"), []byte("forex"), []byte("ibank")})
+
+	for _, args := range [][]string{{"authorizeInterbankSender", "0002"}, {"createAccount", "Bob Jones", "1", "0", "USD"}} {
+		response := bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	newTestBank(t, ibankStub, "bank2", "9", "1000", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.RegisterRoute(t, ibankStub, "0003", "bank3", "forex", "Org3MSP")
+	testutil.RegisterRoute(t, ibankStub, "0004", "bank4", "forex", "Org4MSP")
+
+	//registered banks are not linked until a correspondent relationship is set
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "100", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "payment made without a relationship")
+	assert.Equal(t, "No correspondent path from bank 0002 to bank 0001", response.Message, "unexpected error")
+
+	//a relationship with no fee links two banks directly
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setCorrespondent", "0002", "0001", "0"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "100", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	result := transferResult{}
+	err := json.Unmarshal(response.GetPayload(), &result)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, []string{"0002", "0001"}, result.Path, "direct path not taken")
+	assert.Equal(t, "100", result.Amount.String(), "incorrect amount credited")
+
+	//a bank sets the fee for payments it receives, but not for another bank
+	ibankStub.Creator = testutil.NewIdentity("Org3MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setCorrespondent", "0002", "0003", "0.5"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setCorrespondent", "0002", "0004", "0"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "fee set for another bank")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "deferred", "centralbank"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//fees compound, 0.5% then 0.1% keeps more of the payment than 0.6% direct, and 1% then nothing keeps less
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0.6")
+	testutil.SetCorrespondent(t, ibankStub, "0003", "0001", "0.1")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0004", "1")
+	testutil.SetCorrespondent(t, ibankStub, "0004", "0001", "0")
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "100", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	result = transferResult{}
+	err = json.Unmarshal(response.GetPayload(), &result)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, []string{"0002", "0003", "0001"}, result.Path, "cheapest path not taken")
+	assert.Equal(t, "99.4", result.Amount.String(), "incorrect amount credited")
+	assert.Equal(t, "0.5", result.Hops[0].Fee.String(), "incorrect intermediary fee")
+	assert.Equal(t, "99.5", result.Hops[1].Amount.String(), "incorrect forwarded amount")
+
+	//the intermediary keeps its fee and owes the rest to the next bank, the beneficiary's bank keeps its own fee
+	vostros := []struct {
+		stub     *testutil.MockStub
+		bankID   string
+		expected string
+	}{
+		{bank3Stub, "0002", "-100"},
+		{bank3Stub, "0001", "99.5"},
+		{bankStub, "0003", "-99.5"},
+	}
+
+	for _, vostro := range vostros {
+		response = vostro.stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listVostroAccounts", vostro.bankID}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		accounts := []account{}
+		err = json.Unmarshal(response.GetPayload(), &accounts)
+		if err != nil {
+			panic(err)
+		}
+
+		if assert.Equal(t, 1, len(accounts), "incorrect vostro accounts") {
+			assert.Equal(t, vostro.expected, accounts[0].Balance.String(), "incorrect vostro balance")
+		}
+	}
+
+	fees := []struct {
+		stub     *testutil.MockStub
+		expected string
+	}{
+		{bank3Stub, "0.5"},
+		{bankStub, "0.1"},
+	}
+
+	for _, fee := range fees {
+		feeKey, _ := fee.stub.CreateCompositeKey("internalAccount", []string{"FEEINCOME", "USD"})
+
+		feeAccount := account{}
+		err = json.Unmarshal(fee.stub.State[feeKey], &feeAccount)
+		if err != nil {
+			panic(err)
+		}
+
+		assert.Equal(t, fee.expected, feeAccount.Balance.String(), "incorrect fee income")
+	}
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getObligations", "0003"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	obligations := []obligation{}
+	err = json.Unmarshal(response.GetPayload(), &obligations)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, 1, len(obligations), "incorrect obligations")
+	assert.Equal(t, decimal.RequireFromString("99.5"), obligations[0].Amount, "incorrect obligation")
+
+	//payments avoid suspended intermediaries
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "2")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"suspendRoute", "0003", "under investigation"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "100", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	result = transferResult{}
+	err = json.Unmarshal(response.GetPayload(), &result)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, []string{"0002", "0004", "0001"}, result.Path, "suspended intermediary used")
+	assert.Equal(t, "99", result.Amount.String(), "incorrect amount credited")
+
+	//a fee can not be avoided by paying through an active bank that has no relationships
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	for _, relationship := range [][]string{{"0002", "0004"}, {"0004", "0001"}} {
+		response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(append([]string{"removeCorrespondent"}, relationship...)))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "100", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	result = transferResult{}
+	err = json.Unmarshal(response.GetPayload(), &result)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, []string{"0002", "0001"}, result.Path, "fee avoided through an unrelated bank")
+	assert.Equal(t, "98", result.Amount.String(), "incorrect amount credited")
+
+	//removing the last relationship leaves no path
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"removeCorrespondent", "0002", "0001"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "100", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "payment made after the relationship was removed")
+}
@@ -0,0 +1,115 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+// testBank stands in for a bank contract, keeping one account that interbankDebit debits, receiveInterbank and
+// interbankRefund credit and queryAccount returns, so that the tests of the interbank contract do not depend on the
+// bank contract's interbankDebit, which is left for the workshop to implement
+type testBank struct{}
+
+// Init opens the account given its number, balance and currency
+func (s *testBank) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	args := stub.GetStringArgs()
+	acc := account{Name: "Joe Blogs", AccNumber: args[0], Balance: decimal.RequireFromString(args[1]), Currency: args[2]}
+
+	accAsBytes, _ := json.Marshal(acc)
+	stub.PutState(acc.AccNumber, accAsBytes)
+	return shim.Success(nil)
+}
+
+// Invoke answers interbankDebit, receiveInterbank, interbankRefund and queryAccount, receiveInterbank and
+// interbankRefund only crediting the account
+func (s *testBank) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	accNum := args[0]
+	amount := decimal.Zero
+
+	if function == "receiveInterbank" {
+		legs := []leg{}
+		json.Unmarshal([]byte(args[0]), &legs)
+
+		for _, bankLeg := range legs {
+			accNum = bankLeg.Account
+			amount = amount.Add(bankLeg.Credit)
+		}
+
+		if accNum == "" {
+			return shim.Success(nil)
+		}
+	} else if function == "interbankRefund" {
+		refunds := []refund{}
+		json.Unmarshal([]byte(args[0]), &refunds)
+
+		for _, refunded := range refunds {
+			accNum = refunded.Account
+			amount = amount.Add(refunded.Amount)
+		}
+	}
+
+	accAsBytes, _ := stub.GetState(accNum)
+	if accAsBytes == nil {
+		return shim.Error("Account " + accNum + " does not exist")
+	}
+
+	if function == "queryAccount" {
+		return shim.Success(accAsBytes)
+	}
+
+	acc := account{}
+	json.Unmarshal(accAsBytes, &acc)
+
+	if function == "interbankDebit" {
+		amount = decimal.RequireFromString(args[1])
+
+		if acc.Currency != args[2] || acc.Balance.LessThan(amount) {
+			return shim.Error("Account has insufficient funds")
+		}
+
+		amount = amount.Neg()
+	}
+
+	acc.Balance = acc.Balance.Add(amount)
+
+	accAsBytes, _ = json.Marshal(acc)
+	stub.PutState(acc.AccNumber, accAsBytes)
+	return shim.Success(nil)
+}
+
+// newTestBank registers a testBank with the interbank stub under the contract name, holding an account with the
+// given balance
+func newTestBank(t *testing.T, ibankStub *testutil.MockStub, bankContract string, accNum string, balance string, currency string) *testutil.MockStub {
+	bankStub := testutil.NewMockStub(bankContract, new(testBank))
+
+	response := bankStub.MockInit(uuid.New().String(), util.ArrayToChaincodeArgs([]string{accNum, balance, currency}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.MockPeerChaincode(bankContract, bankStub)
+	return bankStub
+}
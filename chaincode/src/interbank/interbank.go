@@ -21,15 +21,39 @@ import (
 	"errors"
 	"fmt"
 	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
 	"github.com/shopspring/decimal"
+	"rounding"
 )
 
+// route maps a bank ID to the contracts of that bank, it is stored on the ledger under the composite key route~ID
+//Suspended - true if transfers to the bank are refused, SuspendedReason says why
+//BankMSP - the MSP ID of the bank's organization, which accepted the route
+//RegisteredBy - the governance identity that proposed the route, as MSPID/common name
+//ApprovedBy - the identity from the bank's MSP that accepted the route, as MSPID/common name
 type route struct {
-	ID            string `json:"name"`
-	BankContract  string `json:"bankContract"`
-	ForexContract string `json:"ForexContract"`
+	ID              string `json:"name"`
+	BankContract    string `json:"bankContract"`
+	ForexContract   string `json:"ForexContract"`
+	Suspended       bool   `json:"suspended,omitempty"`
+	SuspendedReason string `json:"suspendedReason,omitempty"`
+	BankMSP         string `json:"bankMSP,omitempty"`
+	RegisteredBy    string `json:"registeredBy,omitempty"`
+	ApprovedBy      string `json:"approvedBy,omitempty"`
+}
+
+// routeProposal is a new or changed route awaiting acceptance by the bank's MSP, stored under the composite key
+// routeProposal~ID. A bank has at most one proposal, a later proposal replaces it. A proposal moving a route to
+// another MSP names the MSP currently registered for the route in CurrentMSP, and must be accepted by both MSPs.
+// Update is true when the proposal changes a registered route rather than registering a new one
+type routeProposal struct {
+	Route                route  `json:"route"`
+	ProposedAt           int64  `json:"proposedAt"`
+	Update               bool   `json:"update,omitempty"`
+	CurrentMSP           string `json:"currentMSP,omitempty"`
+	CurrentMSPApprovedBy string `json:"currentMSPApprovedBy,omitempty"`
 }
 
 type account struct {
@@ -39,9 +63,52 @@ type account struct {
 	Currency  string          `json:"currency"`
 }
 
+// forexPair is the rate returned by the ForexChaincode, ValidFor is zero for rates that never expire
 type forexPair struct {
-	Pair string  `json:"pair"`
-	Rate float64 `json:"rate"`
+	Pair      string          `json:"pair"`
+	Rate      decimal.Decimal `json:"rate"`
+	Timestamp int64           `json:"timestamp"`
+	ValidFor  int64           `json:"validFor"`
+}
+
+// forexQuote is a rate locked by the ForexChaincode for a single conversion
+type forexQuote struct {
+	ID   string          `json:"id"`
+	Pair string          `json:"pair"`
+	Rate decimal.Decimal `json:"rate"`
+}
+
+// transferRequest is a transfer to make, given as interbankTransfer's arguments or read from a pacs.008 message
+//MessageID, EndToEndID, UETR - the identification of a pacs.008 message and its transaction, kept on the record
+//DebtorName, CreditorName, RemittanceInfo - the party names and unstructured remittance information of a pacs.008
+type transferRequest struct {
+	ToAccNum       string
+	ToBankID       string
+	Amount         string
+	Currency       string
+	FromBankID     string
+	FromAccNum     string
+	QuoteID        string
+	MessageID      string
+	EndToEndID     string
+	UETR           string
+	DebtorName     string
+	CreditorName   string
+	RemittanceInfo []string
+}
+
+// transferEvent is emitted by interbankTransfer, with the fields of the bank contract's transfer event and the ID and
+// status of the interbank contract's record of the transfer. ReturnReasonCode is the ISO 20022 reason code given
+// when the beneficiary bank rejected the transfer and the funds stayed with the payer
+type transferEvent struct {
+	FromAccNumber       string `json:"FromAccNumber"`
+	FromBankID          string `json:"FromBankID"`
+	ToAccNumber         string `json:"ToAccNumber"`
+	ToBankID            string `json:"ToBankID"`
+	Amount              string `json:"Amount"`
+	InterbankTransferID string `json:"InterbankTransferID"`
+	Status              string `json:"Status"`
+	ReturnReasonCode    string `json:"ReturnReasonCode,omitempty"`
 }
 
 // InterbankChaincode is the struct to which all contract methods are associated with
@@ -49,7 +116,35 @@ type InterbankChaincode struct {
 }
 
 // Initalize the chaincode
+//Args:
+//	GovernanceMSP string Optional, the MSP ID of the organization that governs route registration. Defaults to
+//		the MSP of the identity instantiating the chaincode. Left unchanged on upgrade if omitted.
 func (s *InterbankChaincode) Init(stub shim.ChaincodeStubInterface) sc.Response {
+	args := stub.GetStringArgs()
+
+	currentGovernance, err := stub.GetState("governanceMSP")
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	governanceMSP := ""
+	if len(args) > 0 {
+		governanceMSP = args[0]
+	} else if currentGovernance == nil {
+		governanceMSP, _ = cid.GetMSPID(stub)
+	}
+
+	if governanceMSP == "" {
+		return shim.Success(nil)
+	}
+
+	err = stub.PutState("governanceMSP", []byte(governanceMSP))
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -61,45 +156,197 @@ func (s *InterbankChaincode) Invoke(stub shim.ChaincodeStubInterface) sc.Respons
 		return s.interbankTransfer(stub, args)
 	} else if function == "registerRoute" {
 		return s.registerRoute(stub, args)
+	} else if function == "setRoundingPolicy" {
+		return s.setRoundingPolicy(stub, args)
+	} else if function == "acceptRoute" {
+		return s.acceptRoute(stub, args)
+	} else if function == "rejectRoute" {
+		return s.rejectRoute(stub, args)
+	} else if function == "listRouteProposals" {
+		return s.listRouteProposals(stub, args)
+	} else if function == "updateRoute" {
+		return s.updateRoute(stub, args)
+	} else if function == "suspendRoute" {
+		return s.suspendRoute(stub, args)
+	} else if function == "resumeRoute" {
+		return s.resumeRoute(stub, args)
+	} else if function == "removeRoute" {
+		return s.removeRoute(stub, args)
+	} else if function == "getRoute" {
+		return s.getRouteByID(stub, args)
+	} else if function == "listRoutes" {
+		return s.listRoutes(stub, args)
+	} else if function == "setCorrespondent" {
+		return s.setCorrespondent(stub, args)
+	} else if function == "removeCorrespondent" {
+		return s.removeCorrespondent(stub, args)
+	} else if function == "listCorrespondents" {
+		return s.listCorrespondents(stub, args)
+	} else if function == "getObligations" {
+		return s.getObligations(stub, args)
+	} else if function == "setSettlementMode" {
+		return s.setSettlementMode(stub, args)
+	} else if function == "closeSettlementCycle" {
+		return s.closeSettlementCycle(stub, args)
+	} else if function == "getSettlementCycle" {
+		return s.getSettlementCycle(stub, args)
+	} else if function == "getSettlementReport" {
+		return s.getSettlementReport(stub, args)
+	} else if function == "getInterbankTransfer" {
+		return s.getInterbankTransfer(stub, args)
+	} else if function == "listInterbankTransfersByBank" {
+		return s.listInterbankTransfersByBank(stub, args)
+	} else if function == "setBilateralLimit" {
+		return s.setBilateralLimit(stub, args)
+	} else if function == "removeBilateralLimit" {
+		return s.removeBilateralLimit(stub, args)
+	} else if function == "listBilateralLimits" {
+		return s.listBilateralLimits(stub, args)
+	} else if function == "listQueuedPayments" {
+		return s.listQueuedPayments(stub, args)
+	} else if function == "setPaymentPriority" {
+		return s.setPaymentPriority(stub, args)
+	} else if function == "resolveQueue" {
+		return s.resolveQueue(stub, args)
+	} else if function == "cancelQueuedPayment" {
+		return s.cancelQueuedPayment(stub, args)
+	} else if function == "setQueueExpiry" {
+		return s.setQueueExpiry(stub, args)
+	} else if function == "expireQueuedPayments" {
+		return s.expireQueuedPayments(stub, args)
+	} else if function == "setReturnPolicy" {
+		return s.setReturnPolicy(stub, args)
+	} else if function == "getPaymentReturn" {
+		return s.getPaymentReturn(stub, args)
 	}
 
 	return shim.Error("Invalid function")
 }
 
-// Perform a transfer between two banks
+// Perform a transfer between two banks. The transfer is given either as positional arguments or as a single ISO 20022
+// pacs.008 message, in which case a pacs.002 status report is returned instead of the transfer result. The payer's
+// account is debited through the sending bank's contract, which must accept interbank debits from this contract
 // params:
 //	toAccNumber	string	the account number to pay
 //	toBankID	string	the ID of the bank that the account belongs to
 //	amount		string	the amount to pay
 //	currency	string 	the currency of the amount being paid
+//	fromBankID	string	the ID of the sending bank, the transaction must be signed by a member of that bank's MSP
+//	fromAccNumber	string	the account number at the sending bank to debit
+//	quoteID		string	optional, the ID of a quote from the recipient bank's forex contract to convert at
+// or:
+//	message		string	a pacs.008 FI to FI customer credit transfer, in XML or its JSON mapping
 func (s *InterbankChaincode) interbankTransfer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) == 1 {
+		return s.creditTransfer(stub, args[0])
+	}
+
+	if len(args) != 6 && len(args) != 7 {
+		return shim.Error("Expecting 6 or 7 arguments: account number, bank ID, amount, currency, sending bank ID, sending account number and optionally a quote ID, or a pacs.008 message")
+	}
 
-	toAccNum := args[0]
-	toBankID := args[1]
-	amount := args[2]
-	currency := args[3]
+	request := &transferRequest{ToAccNum: args[0], ToBankID: args[1], Amount: args[2], Currency: args[3], FromBankID: args[4], FromAccNum: args[5]}
+	if len(args) > 6 {
+		request.QuoteID = args[6]
+	}
 
-	routeAsBytes, err := stub.GetState(toBankID)
+	result, err := s.makeTransfer(stub, request)
 
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	toRoute := &route{}
-	err = json.Unmarshal(routeAsBytes, toRoute)
+	//write out an event of the transfer, in the form of the bank contract's transfer event
+	event := &transferEvent{FromAccNumber: request.FromAccNum, FromBankID: request.FromBankID, ToAccNumber: request.ToAccNum,
+		ToBankID: request.ToBankID, Amount: request.Amount, InterbankTransferID: result.ID, Status: result.Status}
+
+	if result.Status == statusReturned {
+		event.ReturnReasonCode = result.ReasonCode
+	}
+
+	eventBytes, _ := json.Marshal(event)
+	err = stub.SetEvent("transfer-event", eventBytes)
+
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error("Unable to set transfer event " + err.Error())
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+// makeTransfer pays a transfer request. The originator's account is debited through the sending bank's contract, and
+// the payment follows the cheapest path from the sending bank to the receiving bank, each bank on the path keeping its
+// fee. A payment that would breach a bilateral limit in gross or deferred mode, or in rtgs mode that the sending
+// bank's reserves cannot cover, is queued until resolveQueue can release it. A transfer to an account that does not
+// exist is rejected with reason code AC01 or, if the return policy is return, recorded as returned with nothing
+// credited. A record of the transfer is kept, and its ID and status are returned with the path, hops and amount
+// credited
+func (s *InterbankChaincode) makeTransfer(stub shim.ChaincodeStubInterface, request *transferRequest) (*transferResult, error) {
+	toAccNum := request.ToAccNum
+	toBankID := request.ToBankID
+	amount := request.Amount
+	currency := request.Currency
+	fromBankID := request.FromBankID
+	fromAccNum := request.FromAccNum
+	quoteID := request.QuoteID
+
+	fromRoute, err := checkSender(stub, fromBankID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if toBankID == fromBankID {
+		return nil, errors.New("Payments between accounts at bank " + toBankID + " are made with the bank's transfer")
+	}
+
+	toRoute, err := getKnownRoute(stub, toBankID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if toRoute.Suspended {
+		message := "Route to bank " + toBankID + " is suspended"
+		if toRoute.SuspendedReason != "" {
+			message += ": " + toRoute.SuspendedReason
+		}
+		return nil, errors.New(message)
 	}
 
 	toBankContract := toRoute.BankContract
 
-	stringArgs := []string{"queryAccount", toAccNum}
-	response := stub.InvokeChaincode(toBankContract, util.ArrayToChaincodeArgs(stringArgs), "")
-	toAccount := &account{}
-	err = json.Unmarshal(response.Payload, toAccount)
+	amountAsDecimal, err := decimal.NewFromString(amount)
+
+	if err != nil {
+		return nil, errors.New("Unable to parse amount: " + amount)
+	}
+
+	if !amountAsDecimal.IsPositive() {
+		return nil, errors.New("Amount to transfer must be a positive number")
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
 
 	if err != nil {
-		return shim.Error("Unable to retrieve to account from ledger " + err.Error())
+		return nil, errors.New("Unable to get transaction timestamp " + err.Error())
+	}
+
+	record := &transferRecord{ID: stub.GetTxID(), OriginatorBank: fromBankID, OriginatorAccount: fromAccNum, BeneficiaryBank: toBankID,
+		BeneficiaryAccount: toAccNum, Currency: currency, QuoteID: quoteID, Path: []string{fromBankID}, CreatedAt: timestamp.GetSeconds(),
+		MessageID: request.MessageID, EndToEndID: request.EndToEndID, UETR: request.UETR, DebtorName: request.DebtorName,
+		CreditorName: request.CreditorName, RemittanceInfo: request.RemittanceInfo}
+
+	//a transfer to an account that does not exist is rejected, or accepted and returned to the originator
+	toAccount, err := getBeneficiary(stub, toBankContract, toBankID, toAccNum)
+
+	if rejected, ok := err.(*rejection); ok {
+		return s.rejectTransfer(stub, record, fromRoute.BankContract, amountAsDecimal, rejected)
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
 	var exchangeRate decimal.Decimal
@@ -107,77 +354,229 @@ func (s *InterbankChaincode) interbankTransfer(stub shim.ChaincodeStubInterface,
 	//check if currency conversion is required
 	if currency == toAccount.Currency {
 		exchangeRate = decimal.NewFromFloat(1.0)
+	} else if quoteID != "" {
+		rate, err := quotedConversion(stub, toRoute.ForexContract, quoteID, currency, toAccount.Currency, fromBankID, fromAccNum, amount)
+
+		if err != nil {
+			return nil, errors.New("Unable to perform currency conversion " + err.Error())
+		}
+
+		exchangeRate = rate
 	} else {
 		forexContract := toRoute.ForexContract
-		exchangeRateAsFloat, err := currencyConversion(stub, forexContract, currency, toAccount.Currency)
+		rate, err := currencyConversion(stub, forexContract, currency, toAccount.Currency)
 
 		if err != nil {
-			return shim.Error("Unable to perform currency conversion " + err.Error())
+			return nil, errors.New("Unable to perform currency conversion " + err.Error())
 		}
 
-		exchangeRate = decimal.NewFromFloat(exchangeRateAsFloat)
+		exchangeRate = rate
 	}
 
-	//perform payment
-	amountAsDecimal, err := decimal.NewFromString(amount)
+	policy, err := rounding.GetPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	//pay through the cheapest chain of correspondents, each intermediary keeps its fee from the amount it forwards
+	path, err := findPath(stub, fromBankID, toBankID)
 
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
 
-	amountAsDecimal = amountAsDecimal.Mul(exchangeRate)
-	amountAsString := amountAsDecimal.String()
+	sentAmount := amountAsDecimal
+	hops, amountAsDecimal := priceAlongPath(path, amountAsDecimal, currency, policy.Mode)
 
-	stringArgs = []string{"deposit", toAccNum, amountAsString}
-	response = stub.InvokeChaincode(toBankContract, util.ArrayToChaincodeArgs(stringArgs), "")
+	convertedAmount := amountAsDecimal.Mul(exchangeRate)
+	amountAsDecimal = convertedAmount
+
+	//round the converted amount to the precision of the recipient currency
+	if currency != toAccount.Currency {
+		amountAsDecimal = rounding.Round(convertedAmount, toAccount.Currency, policy.Mode)
+	}
+
+	result := transferResult{ID: record.ID, Path: []string{fromBankID}, Hops: hops, Amount: amountAsDecimal, Currency: toAccount.Currency}
+	for _, leg := range hops {
+		result.Path = append(result.Path, leg.To)
+	}
+
+	record.Amount = sentAmount
+	record.CreditedAmount = amountAsDecimal
+	record.CreditedCurrency = toAccount.Currency
+	record.Rate = exchangeRate
+	record.Path = result.Path
+	payment := queuedPayment{ID: result.ID, QueuedAt: timestamp.GetSeconds(), Hops: hops, BankContract: toBankContract,
+		Residual: convertedAmount.Sub(amountAsDecimal)}
+
+	//queue the payment if in gross or deferred mode it would take a bank over its bilateral limit, or in rtgs mode
+	//overdraw its reserves
+	settlement, err := getSettlementPolicy(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exposures := newExposureBook(stub, settlement)
+	err = addExposures(exposures, hops, currency, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	breach, limit, err := findBreach(exposures)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reserves := newReserveBook(stub, settlement)
+	err = reserves.add(hops, currency, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//the transfer is accepted, take the amount sent from the originator through the sending bank's contract
+	err = debitOriginator(stub, fromRoute.BankContract, fromAccNum, amount, currency, hops[0].To)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if breach != nil {
+		record.Reason = "Bank " + breach.From + " would exceed its bilateral limit of " + limit.Limit.String() + " " + limit.Currency + " with bank " + breach.To
+	} else if shortfall := reserves.findShortfall(); shortfall != nil {
+		record.Reason = "Bank " + shortfall.BankID + " has insufficient reserves in " + shortfall.Currency
+	}
+
+	if record.Reason != "" {
+		record.Status = statusQueued
+
+		err = putTransferRecord(stub, record, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = putQueuedPayment(stub, &payment)
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = s.releasePayments(stub, exposures, []queuedPayment{payment}, []*transferRecord{record}, true)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result.Status = record.Status
+	result.Cycle = record.Cycle
+	result.Reason = record.Reason
+
+	return &result, nil
+}
+
+// rejectTransfer fails a transfer the beneficiary bank rejected or, when the return policy accepts such transfers,
+// records it as returned and reports the return. A transfer is only accepted for return if the originator's account
+// could have paid it
+func (s *InterbankChaincode) rejectTransfer(stub shim.ChaincodeStubInterface, record *transferRecord, fromBankContract string, amount decimal.Decimal, rejected *rejection) (*transferResult, error) {
+	policy, err := getReturnPolicy(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.Mode != returnPayment {
+		return nil, rejected
+	}
+
+	err = checkOriginatorFunds(stub, fromBankContract, record.OriginatorBank, record.OriginatorAccount, amount, record.Currency)
+
+	if err != nil {
+		return nil, err
+	}
+
+	record.Amount = amount
+	err = returnTransfer(stub, record, rejected)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &transferResult{ID: record.ID, Path: record.Path, Hops: []hop{}, Amount: decimal.Zero, Currency: record.Currency,
+		Status: record.Status, ReasonCode: record.ReasonCode, Reason: record.Reason}, nil
+}
+
+// debitOriginator takes the amount of a transfer from the originator's account with the sending bank's interbankDebit,
+// which credits it to the vostro account of the first bank on the path, the bank it is paid to
+func debitOriginator(stub shim.ChaincodeStubInterface, bankContract string, fromAccNum string, amount string, currency string, toBankID string) error {
+	stringArgs := []string{"interbankDebit", fromAccNum, amount, currency, toBankID}
+	response := stub.InvokeChaincode(bankContract, util.ArrayToChaincodeArgs(stringArgs), "")
 
 	if response.GetStatus() != shim.OK {
-		return shim.Error("Failed to make payment " + err.Error())
+		return errors.New("Unable to debit account " + fromAccNum + " at the sending bank " + response.Message)
 	}
 
-	return (shim.Success(nil))
+	return nil
 }
 
-func currencyConversion(stub shim.ChaincodeStubInterface, forexContract string, baseCurrency string, counterCurrency string) (float64, error) {
+func currencyConversion(stub shim.ChaincodeStubInterface, forexContract string, baseCurrency string, counterCurrency string) (decimal.Decimal, error) {
 
-	// invoke the forex contract to get the exchange rate for the pair
-	stringArgs := []string{"getForexPair", baseCurrency, counterCurrency}
+	// invoke the forex contract to get the rate for the pair. The payer sells the base currency for the counter
+	// currency, so it is bought at the bid rate and the spread is kept, as in the bank contract. The forex contract
+	// swaps the bid and ask of a pair stored the other way round, so the bid is the right side in either direction
+	stringArgs := []string{"getForexPair", baseCurrency, counterCurrency, "bid"}
 	args := util.ArrayToChaincodeArgs(stringArgs)
 	response := stub.InvokeChaincode(forexContract, args, "")
 
 	if response.Status != shim.OK {
-		return 0.0, errors.New("Unable to get exchange rate from Forex Contract" + response.Message)
+		return decimal.Zero, errors.New("Unable to get exchange rate from Forex Contract" + response.Message)
 	}
 
 	responseForex := &forexPair{}
 	err := json.Unmarshal(response.GetPayload(), responseForex)
 
 	if err != nil {
-		return 0.0, errors.New("Unable to unmarshal exchange rate from Forex Contract" + err.Error())
+		return decimal.Zero, errors.New("Unable to unmarshal exchange rate from Forex Contract" + err.Error())
+	}
+
+	// reject the rate if it has expired, so payments never execute on outdated prices
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return decimal.Zero, errors.New("Unable to get transaction timestamp " + err.Error())
+	}
+
+	if responseForex.ValidFor > 0 && timestamp.GetSeconds() >= responseForex.Timestamp+responseForex.ValidFor {
+		return decimal.Zero, errors.New("Stale rate for " + responseForex.Pair + ", the rate has expired")
 	}
 
 	return responseForex.Rate, nil
 }
 
-//registerRoute registers the contracts associated with a bank to allow for interbank transfer
-//Args
-//	ID            string 	the ID of the bank, analogue of SWIFT, IBAN, routing code, etc
-//	BankContract  string 	the name of the chaincode for the bank
-//	ForexContract string 	the name of the contract to provide forex services
-func (s *InterbankChaincode) registerRoute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if len(args) != 3 {
-		return shim.Error("Expecting 3 arguments: bank ID, name of BankChaincode, name of ForexContract")
+// quotedConversion uses a quote created by the forex contract for the sending account, returning its locked rate.
+// The quote must cover the whole amount sent
+func quotedConversion(stub shim.ChaincodeStubInterface, forexContract string, quoteID string, baseCurrency string, counterCurrency string,
+	fromBankID string, fromAccNum string, amount string) (decimal.Decimal, error) {
+
+	stringArgs := []string{"consumeQuote", quoteID, baseCurrency, counterCurrency, fromBankID, fromAccNum, amount}
+	response := stub.InvokeChaincode(forexContract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+	if response.Status != shim.OK {
+		return decimal.Zero, errors.New("Unable to use quote from Forex Contract " + response.Message)
 	}
 
-	newRoute := route{ID: args[0], BankContract: args[1], ForexContract: args[2]}
-	asBytes, _ := json.Marshal(newRoute)
-	err := stub.PutState(args[0], asBytes)
+	responseQuote := &forexQuote{}
+	err := json.Unmarshal(response.GetPayload(), responseQuote)
 
 	if err != nil {
-		return shim.Error("Unable to commit route to ledger")
+		return decimal.Zero, errors.New("Unable to unmarshal quote from Forex Contract" + err.Error())
 	}
 
-	return shim.Success(nil)
+	return responseQuote.Rate, nil
 }
 
 func main() {
@@ -22,6 +22,7 @@ import (
 	"forex"
 	"github.com/google/uuid"
 	"github.com/hyperledger/fabric/common/util"
+	"testutil"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/shopspring/decimal"
@@ -35,22 +36,25 @@ func TestTransfer(t *testing.T) {
 	b1 := new(bank.BankChaincode)
 	ibank := new(InterbankChaincode)
 
-	forexStub := shim.NewMockStub("forex", fx)
-	bankStub := shim.NewMockStub("bank", b1)
-	ibankStub := shim.NewMockStub("ibank", ibank)
+	forexStub := testutil.NewMockStub("forex", fx)
+	bankStub := testutil.NewMockStub("bank", b1)
+	ibankStub := testutil.NewMockStub("ibank", ibank)
 
 	uid := uuid.New().String()
+	forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
 	response := forexStub.MockInit(uid, [][]byte{})
+	testutil.RegisterRateProvider(t, forexStub)
 
 	uid = uuid.New().String()
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
 	response = ibankStub.MockInit(uid, [][]byte{})
 
 	ibankStub.MockPeerChaincode("forex", forexStub)
 	ibankStub.MockPeerChaincode("bank", bankStub)
 
-	//create forexpair
+	//create forexpair, transfers convert at the bid rate of 1.19, the spread is kept
 	uid = uuid.New().String()
-	stringArgs := []string{"createUpdateForexPair", "GBP", "USD", "1.20"}
+	stringArgs := []string{"createUpdateForexPair", "GBP", "USD", "1.19", "1.21"}
 	args := util.ArrayToChaincodeArgs(stringArgs)
 	response = forexStub.MockInvoke(uid, args)
 	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
@@ -62,11 +66,16 @@ func TestTransfer(t *testing.T) {
 	response = forexStub.MockInvoke(uid, args)
 	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
 
-	//create bank
+	//create bank, accepting credits from bank 0002 through the interbank contract
 	uid = uuid.New().String()
-	response = bankStub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex")})
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = bankStub.MockInit(uid, [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex"), []byte("ibank")})
 	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
 
+	uid = uuid.New().String()
+	response = bankStub.MockInvoke(uid, util.ArrayToChaincodeArgs([]string{"authorizeInterbankSender", "0002"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
 	//create account
 	uid = uuid.New().String()
 	response = bankStub.MockInvoke(uid, [][]byte{[]byte("createAccount"),
@@ -77,18 +86,48 @@ func TestTransfer(t *testing.T) {
 	uid = uuid.New().String()
 	response = bankStub.MockInvoke(uid, [][]byte{[]byte("queryAccount"), []byte("1")})
 
-	//register route to bank
-	uid = uuid.New().String()
-	response = ibankStub.MockInvoke(uid, [][]byte{[]byte("registerRoute"), []byte("0001"), []byte("bank"), []byte("forex")})
-	assert.EqualValues(t, shim.OK, response.GetStatus(), "failed to execute invocation")
+	//the sending bank, whose customer pays in GBP
+	bank2Stub := newTestBank(t, ibankStub, "bank2", "9", "500", "GBP")
 
-	//perform transfer
+	//register route to bank
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	//perform transfer, signed by a member of the sending bank's MSP
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	bankStub.Creator = ibankStub.Creator
 	uid = uuid.New().String()
 
-	stringArgs = []string{"interbankTransfer", "1", "0001", "100", "GBP"}
+	stringArgs = []string{"interbankTransfer", "1", "0001", "100", "GBP", "0002", "9"}
 	response = ibankStub.MockInvoke(uid, util.ArrayToChaincodeArgs(stringArgs))
 	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
 
+	//the transfer is recorded with the amounts in both currencies, and listed for both banks
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", uid}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	record := transferRecord{}
+	err := json.Unmarshal(response.GetPayload(), &record)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "0002/9", record.OriginatorBank+"/"+record.OriginatorAccount, "incorrect originator")
+	assert.Equal(t, "0001/1", record.BeneficiaryBank+"/"+record.BeneficiaryAccount, "incorrect beneficiary")
+	assert.Equal(t, "100 GBP", record.Amount.String()+" "+record.Currency, "incorrect amount sent")
+	assert.Equal(t, "119 USD", record.CreditedAmount.String()+" "+record.CreditedCurrency, "incorrect amount credited")
+	assert.Equal(t, "1.19", record.Rate.String(), "incorrect rate")
+	assert.Equal(t, statusSettled, record.Status, "gross transfer not settled")
+
+	for _, bankID := range []string{"0001", "0002"} {
+		response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listInterbankTransfersByBank", bankID}))
+		records := []transferRecord{}
+		json.Unmarshal(response.GetPayload(), &records)
+		assert.Equal(t, 1, len(records), "transfer not listed for bank "+bankID)
+	}
+
 	//Query To Account
 	uid = uuid.New().String()
 
@@ -99,11 +138,16 @@ func TestTransfer(t *testing.T) {
 
 	resonseAccount := &account{}
 
-	err := json.Unmarshal(response.GetPayload(), resonseAccount)
+	err = json.Unmarshal(response.GetPayload(), resonseAccount)
 	if err != nil {
 		panic(err)
 	}
 
-	validateBalance, _ := decimal.NewFromString("120")
+	validateBalance, _ := decimal.NewFromString("119")
 	assert.Equal(t, validateBalance, resonseAccount.Balance, "incorrect balance")
+
+	//the originator paid the amount sent
+	response = bank2Stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "9"}))
+	json.Unmarshal(response.GetPayload(), resonseAccount)
+	assert.Equal(t, "400", resonseAccount.Balance.String(), "originator not debited")
 }
@@ -0,0 +1,358 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"time"
+)
+
+const (
+	// pacs008Name is the message name reported as the original message of a status report
+	pacs008Name = "pacs.008.001.08"
+	// pacs002Namespace is the namespace of the status reports returned for pacs.008 messages
+	pacs002Namespace = "urn:iso:std:iso:20022:tech:xsd:pacs.002.001.10"
+)
+
+// reasonDuplicate is the ISO 20022 reason code for a message that has already been processed
+const reasonDuplicate = "AM05"
+
+// ISO 20022 transaction statuses reported in a pacs.002
+const (
+	// isoSettled is AcceptedSettlementCompleted, the beneficiary has been credited and the banks have settled
+	isoSettled = "ACSC"
+	// isoAccepted is AcceptedSettlementInProcess, the beneficiary has been credited and settlement is deferred
+	isoAccepted = "ACSP"
+	// isoPending is Pending, the payment is queued
+	isoPending = "PDNG"
+	// isoRejected is Rejected, the payment was not made or has been returned
+	isoRejected = "RJCT"
+)
+
+// pacs008 is an FI to FI customer credit transfer. Only the elements the interbank contract uses are read, from XML
+// or from the JSON mapping, which uses the XML element names as keys
+type pacs008 struct {
+	XMLName  xml.Name                 `xml:"Document" json:"-"`
+	Transfer isoCreditTransferMessage `xml:"FIToFICstmrCdtTrf" json:"FIToFICstmrCdtTrf"`
+}
+
+type isoCreditTransferMessage struct {
+	GroupHeader  isoGroupHeader      `xml:"GrpHdr" json:"GrpHdr"`
+	Transactions []isoCreditTransfer `xml:"CdtTrfTxInf" json:"CdtTrfTxInf"`
+}
+
+// isoGroupHeader identifies a message
+type isoGroupHeader struct {
+	MessageID            string `xml:"MsgId" json:"MsgId"`
+	CreatedAt            string `xml:"CreDtTm" json:"CreDtTm"`
+	NumberOfTransactions string `xml:"NbOfTxs,omitempty" json:"NbOfTxs,omitempty"`
+}
+
+// isoCreditTransfer is a single credit transfer transaction of a pacs.008
+type isoCreditTransfer struct {
+	PaymentID     isoPaymentID   `xml:"PmtId" json:"PmtId"`
+	Amount        isoAmount      `xml:"IntrBkSttlmAmt" json:"IntrBkSttlmAmt"`
+	Debtor        isoParty       `xml:"Dbtr" json:"Dbtr"`
+	DebtorAccount isoAccount     `xml:"DbtrAcct" json:"DbtrAcct"`
+	DebtorAgent   isoAgent       `xml:"DbtrAgt" json:"DbtrAgt"`
+	CreditorAgent isoAgent       `xml:"CdtrAgt" json:"CdtrAgt"`
+	Creditor      isoParty       `xml:"Cdtr" json:"Cdtr"`
+	CreditorAcct  isoAccount     `xml:"CdtrAcct" json:"CdtrAcct"`
+	Remittance    *isoRemittance `xml:"RmtInf,omitempty" json:"RmtInf,omitempty"`
+}
+
+// isoPaymentID holds the references of a transaction, the end to end ID is passed unchanged to the beneficiary
+type isoPaymentID struct {
+	InstructionID string `xml:"InstrId,omitempty" json:"InstrId,omitempty"`
+	EndToEndID    string `xml:"EndToEndId" json:"EndToEndId"`
+	TransactionID string `xml:"TxId,omitempty" json:"TxId,omitempty"`
+	UETR          string `xml:"UETR,omitempty" json:"UETR,omitempty"`
+}
+
+// isoAmount is an amount with its currency as the Ccy attribute, the JSON mapping gives the amount as Amt
+type isoAmount struct {
+	Currency string `xml:"Ccy,attr" json:"Ccy"`
+	Value    string `xml:",chardata" json:"Amt"`
+}
+
+type isoParty struct {
+	Name string `xml:"Nm,omitempty" json:"Nm,omitempty"`
+}
+
+// isoAccount identifies an account by IBAN or by another identifier, such as the account number at the bank
+type isoAccount struct {
+	ID struct {
+		IBAN  string        `xml:"IBAN,omitempty" json:"IBAN,omitempty"`
+		Other *isoGenericID `xml:"Othr,omitempty" json:"Othr,omitempty"`
+	} `xml:"Id" json:"Id"`
+}
+
+type isoGenericID struct {
+	ID string `xml:"Id" json:"Id"`
+}
+
+// number returns the account number, the IBAN if one is given
+func (a *isoAccount) number() string {
+	if a.ID.IBAN != "" {
+		return a.ID.IBAN
+	}
+
+	if a.ID.Other != nil {
+		return a.ID.Other.ID
+	}
+
+	return ""
+}
+
+// isoAgent identifies a bank by its clearing system member ID or its BIC
+type isoAgent struct {
+	Institution struct {
+		BICFI          string `xml:"BICFI,omitempty" json:"BICFI,omitempty"`
+		ClearingMember *struct {
+			MemberID string `xml:"MmbId" json:"MmbId"`
+		} `xml:"ClrSysMmbId,omitempty" json:"ClrSysMmbId,omitempty"`
+	} `xml:"FinInstnId" json:"FinInstnId"`
+}
+
+// bankID returns the ID the bank's route is registered under, its clearing system member ID if one is given
+func (a *isoAgent) bankID() string {
+	if a.Institution.ClearingMember != nil && a.Institution.ClearingMember.MemberID != "" {
+		return a.Institution.ClearingMember.MemberID
+	}
+
+	return a.Institution.BICFI
+}
+
+type isoRemittance struct {
+	Unstructured []string `xml:"Ustrd" json:"Ustrd"`
+}
+
+// pacs002 is the FI to FI payment status report returned for a pacs.008
+type pacs002 struct {
+	XMLName xml.Name         `xml:"Document" json:"-"`
+	Xmlns   string           `xml:"xmlns,attr" json:"-"`
+	Report  isoStatusMessage `xml:"FIToFIPmtStsRpt" json:"FIToFIPmtStsRpt"`
+}
+
+type isoStatusMessage struct {
+	GroupHeader   isoGroupHeader         `xml:"GrpHdr" json:"GrpHdr"`
+	OriginalGroup isoOriginalGroup       `xml:"OrgnlGrpInfAndSts" json:"OrgnlGrpInfAndSts"`
+	Transactions  []isoTransactionStatus `xml:"TxInfAndSts" json:"TxInfAndSts"`
+}
+
+type isoOriginalGroup struct {
+	MessageID   string `xml:"OrgnlMsgId" json:"OrgnlMsgId"`
+	MessageName string `xml:"OrgnlMsgNmId" json:"OrgnlMsgNmId"`
+}
+
+// isoTransactionStatus reports the status of a transaction, ClearingSystemRef is the ID of the transfer record
+type isoTransactionStatus struct {
+	InstructionID     string           `xml:"OrgnlInstrId,omitempty" json:"OrgnlInstrId,omitempty"`
+	EndToEndID        string           `xml:"OrgnlEndToEndId" json:"OrgnlEndToEndId"`
+	TransactionID     string           `xml:"OrgnlTxId,omitempty" json:"OrgnlTxId,omitempty"`
+	UETR              string           `xml:"OrgnlUETR,omitempty" json:"OrgnlUETR,omitempty"`
+	Status            string           `xml:"TxSts" json:"TxSts"`
+	StatusReason      *isoStatusReason `xml:"StsRsnInf,omitempty" json:"StsRsnInf,omitempty"`
+	ClearingSystemRef string           `xml:"ClrSysRef,omitempty" json:"ClrSysRef,omitempty"`
+}
+
+// isoStatusReason explains a status, the reason code is left out for queued payments
+type isoStatusReason struct {
+	Reason         *isoReason `xml:"Rsn,omitempty" json:"Rsn,omitempty"`
+	AdditionalInfo string     `xml:"AddtlInf,omitempty" json:"AddtlInf,omitempty"`
+}
+
+type isoReason struct {
+	Code string `xml:"Cd" json:"Cd"`
+}
+
+//creditTransfer makes the transfer in a pacs.008 message and returns a pacs.002 status report, in XML if the message
+//was XML and otherwise in the JSON mapping. The report is also set as the pacs.002 event. The message must hold a
+//single transaction. The debtor and creditor agents are identified by the bank IDs of their routes, and the debtor
+//and creditor accounts by their account numbers. A message ID is only accepted once from each debtor agent. A
+//payment that is rejected, whether it is a duplicate or its beneficiary account does not exist, is reported with the
+//status RJCT and its reason code rather than failing the transaction
+//Args
+//	message	string	the pacs.008 message
+func (s *InterbankChaincode) creditTransfer(stub shim.ChaincodeStubInterface, message string) sc.Response {
+	isXML := strings.HasPrefix(strings.TrimSpace(message), "<")
+
+	received := &pacs008{}
+	var err error
+	if isXML {
+		err = xml.Unmarshal([]byte(message), received)
+	} else {
+		err = json.Unmarshal([]byte(message), received)
+	}
+
+	if err != nil {
+		return shim.Error("Unable to parse pacs.008 message " + err.Error())
+	}
+
+	request, err := readCreditTransfer(received)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//the sender is authenticated before anything, including a rejection, is reported
+	_, err = checkSender(stub, request.FromBankID)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	messageKey, err := stub.CreateCompositeKey("creditTransferMessage", []string{request.FromBankID, request.MessageID})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, err := stub.GetState(messageKey)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve credit transfer message from ledger " + err.Error())
+	}
+
+	var result *transferResult
+	if existing != nil {
+		err = &rejection{Code: reasonDuplicate, Reason: "Message " + request.MessageID + " has already been received from bank " + request.FromBankID}
+	} else {
+		result, err = s.makeTransfer(stub, request)
+	}
+
+	rejected, isRejection := err.(*rejection)
+
+	if err != nil && !isRejection {
+		return shim.Error(err.Error())
+	}
+
+	if err == nil {
+		err = stub.PutState(messageKey, []byte(result.ID))
+
+		if err != nil {
+			return shim.Error("Unable to commit credit transfer message to ledger " + err.Error())
+		}
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	transaction := received.Transfer.Transactions[0]
+	report := &pacs002{Xmlns: pacs002Namespace}
+	report.Report.GroupHeader = isoGroupHeader{MessageID: stub.GetTxID(), CreatedAt: time.Unix(timestamp.GetSeconds(), 0).UTC().Format(time.RFC3339)}
+	report.Report.OriginalGroup = isoOriginalGroup{MessageID: request.MessageID, MessageName: pacs008Name}
+
+	status := isoTransactionStatus{InstructionID: transaction.PaymentID.InstructionID, EndToEndID: request.EndToEndID,
+		TransactionID: transaction.PaymentID.TransactionID, UETR: request.UETR}
+
+	if isRejection {
+		status.Status = isoRejected
+		status.StatusReason = newStatusReason(rejected.Code, rejected.Reason)
+	} else {
+		status.ClearingSystemRef = result.ID
+
+		switch result.Status {
+		case statusSettled:
+			status.Status = isoSettled
+		case statusAccepted:
+			status.Status = isoAccepted
+		case statusQueued:
+			status.Status = isoPending
+			status.StatusReason = newStatusReason("", result.Reason)
+		case statusReturned:
+			status.Status = isoRejected
+			status.StatusReason = newStatusReason(result.ReasonCode, result.Reason)
+		}
+	}
+
+	report.Report.Transactions = []isoTransactionStatus{status}
+
+	var reportAsBytes []byte
+	if isXML {
+		reportAsBytes, _ = xml.Marshal(report)
+		reportAsBytes = append([]byte(xml.Header), reportAsBytes...)
+	} else {
+		reportAsBytes, _ = json.Marshal(report)
+	}
+
+	err = stub.SetEvent("pacs.002", reportAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to set pacs.002 event " + err.Error())
+	}
+
+	return shim.Success(reportAsBytes)
+}
+
+// readCreditTransfer returns the transfer request in a pacs.008 message, which must hold a single transaction
+func readCreditTransfer(received *pacs008) (*transferRequest, error) {
+	header := received.Transfer.GroupHeader
+
+	if header.MessageID == "" {
+		return nil, errors.New("Invalid pacs.008 message, the group header has no message ID")
+	}
+
+	if len(received.Transfer.Transactions) != 1 {
+		return nil, errors.New("Invalid pacs.008 message, expecting a single credit transfer transaction")
+	}
+
+	transaction := received.Transfer.Transactions[0]
+	request := &transferRequest{ToAccNum: transaction.CreditorAcct.number(), ToBankID: transaction.CreditorAgent.bankID(),
+		Amount: strings.TrimSpace(transaction.Amount.Value), Currency: transaction.Amount.Currency,
+		FromBankID: transaction.DebtorAgent.bankID(), FromAccNum: transaction.DebtorAccount.number(), MessageID: header.MessageID,
+		EndToEndID: transaction.PaymentID.EndToEndID, UETR: transaction.PaymentID.UETR, DebtorName: transaction.Debtor.Name,
+		CreditorName: transaction.Creditor.Name}
+
+	if transaction.Remittance != nil {
+		request.RemittanceInfo = transaction.Remittance.Unstructured
+	}
+
+	missing := []string{}
+	for _, element := range []struct{ name, value string }{{"EndToEndId", request.EndToEndID}, {"IntrBkSttlmAmt", request.Amount},
+		{"Ccy", request.Currency}, {"DbtrAgt", request.FromBankID}, {"DbtrAcct", request.FromAccNum},
+		{"CdtrAgt", request.ToBankID}, {"CdtrAcct", request.ToAccNum}} {
+		if element.value == "" {
+			missing = append(missing, element.name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, errors.New("Invalid pacs.008 message, missing " + strings.Join(missing, ", "))
+	}
+
+	return request, nil
+}
+
+// newStatusReason returns the reason for a status, with an ISO 20022 reason code if one is given
+func newStatusReason(code string, info string) *isoStatusReason {
+	reason := &isoStatusReason{AdditionalInfo: info}
+
+	if code != "" {
+		reason.Reason = &isoReason{Code: code}
+	}
+
+	return reason
+}
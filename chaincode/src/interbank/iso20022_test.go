@@ -0,0 +1,165 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"bank"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+const creditTransferXML = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pacs.008.001.08">
+  <FIToFICstmrCdtTrf>
+    <GrpHdr>
+      <MsgId>MSG-0001</MsgId>
+      <CreDtTm>2019-06-01T10:00:00Z</CreDtTm>
+      <NbOfTxs>1</NbOfTxs>
+    </GrpHdr>
+    <CdtTrfTxInf>
+      <PmtId>
+        <InstrId>INSTR-1</InstrId>
+        <EndToEndId>INVOICE-42</EndToEndId>
+        <UETR>8a562c67-ca16-48ba-b074-65581be6f011</UETR>
+      </PmtId>
+      <IntrBkSttlmAmt Ccy="USD">40.00</IntrBkSttlmAmt>
+      <Dbtr><Nm>Jane Blogs</Nm></Dbtr>
+      <DbtrAcct><Id><Othr><Id>9</Id></Othr></Id></DbtrAcct>
+      <DbtrAgt><FinInstnId><ClrSysMmbId><MmbId>0002</MmbId></ClrSysMmbId></FinInstnId></DbtrAgt>
+      <CdtrAgt><FinInstnId><ClrSysMmbId><MmbId>0001</MmbId></ClrSysMmbId></FinInstnId></CdtrAgt>
+      <Cdtr><Nm>Bob Jones</Nm></Cdtr>
+      <CdtrAcct><Id><Othr><Id>1</Id></Othr></Id></CdtrAcct>
+      <RmtInf><Ustrd>Invoice 42</Ustrd></RmtInf>
+    </CdtTrfTxInf>
+  </FIToFICstmrCdtTrf>
+</Document>`
+
+const creditTransferJSON = `{"FIToFICstmrCdtTrf": {
+  "GrpHdr": {"MsgId": "MSG-0002", "CreDtTm": "2019-06-01T10:05:00Z", "NbOfTxs": "1"},
+  "CdtTrfTxInf": [{
+    "PmtId": {"EndToEndId": "INVOICE-43"},
+    "IntrBkSttlmAmt": {"Ccy": "USD", "Amt": "15"},
+    "Dbtr": {"Nm": "Jane Blogs"},
+    "DbtrAcct": {"Id": {"Othr": {"Id": "9"}}},
+    "DbtrAgt": {"FinInstnId": {"ClrSysMmbId": {"MmbId": "0002"}}},
+    "CdtrAgt": {"FinInstnId": {"ClrSysMmbId": {"MmbId": "0001"}}},
+    "Cdtr": {"Nm": "Nobody"},
+    "CdtrAcct": {"Id": {"Othr": {"Id": "404"}}}
+  }]
+}}`
+
+func TestCreditTransferMessages(t *testing.T) {
+	bankStub := testutil.NewMockStub("bank", new(bank.BankChaincode))
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.MockPeerChaincode("bank", bankStub)
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	bankStub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex"), []byte("ibank")})
+
+	for _, args := range [][]string{{"authorizeInterbankSender", "0002"}, {"createAccount", "Bob Jones", "1", "0", "USD"}} {
+		response := bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	bank2Stub := newTestBank(t, ibankStub, "bank2", "9", "100", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "payment-hub")
+	bankStub.Creator = ibankStub.Creator
+
+	//an XML pacs.008 is paid and reported settled in an XML pacs.002
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", creditTransferXML}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	report := pacs002{}
+	err := xml.Unmarshal(response.GetPayload(), &report)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "MSG-0001", report.Report.OriginalGroup.MessageID, "original message not referenced")
+	assert.Equal(t, 1, len(report.Report.Transactions), "incorrect status report")
+
+	status := report.Report.Transactions[0]
+	assert.Equal(t, isoSettled, status.Status, "transfer not reported settled")
+	assert.Equal(t, "INVOICE-42", status.EndToEndID, "end to end ID not reported")
+	assert.Equal(t, "INSTR-1", status.InstructionID, "instruction ID not reported")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", status.ClearingSystemRef}))
+	record := transferRecord{}
+	json.Unmarshal(response.GetPayload(), &record)
+	assert.Equal(t, "INVOICE-42", record.EndToEndID, "end to end ID not recorded")
+	assert.Equal(t, "Jane Blogs", record.DebtorName, "debtor not recorded")
+	assert.Equal(t, []string{"Invoice 42"}, record.RemittanceInfo, "remittance information not recorded")
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1"}))
+	credited := account{}
+	json.Unmarshal(response.GetPayload(), &credited)
+	assert.Equal(t, "40", credited.Balance.String(), "creditor not credited")
+
+	response = bank2Stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "9"}))
+	debited := account{}
+	json.Unmarshal(response.GetPayload(), &debited)
+	assert.Equal(t, "60", debited.Balance.String(), "debtor not debited")
+
+	//a message is only paid once
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", creditTransferXML}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	report = pacs002{}
+	xml.Unmarshal(response.GetPayload(), &report)
+	assert.Equal(t, isoRejected, report.Report.Transactions[0].Status, "duplicate message not rejected")
+	assert.Equal(t, reasonDuplicate, report.Report.Transactions[0].StatusReason.Reason.Code, "incorrect reason code")
+
+	//the JSON mapping is answered in JSON, a payment to an account that does not exist is rejected
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", creditTransferJSON}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	report = pacs002{}
+	err = json.Unmarshal(response.GetPayload(), &report)
+	if err != nil {
+		panic(err)
+	}
+
+	status = report.Report.Transactions[0]
+	assert.Equal(t, isoRejected, status.Status, "payment to unknown account not rejected")
+	assert.Equal(t, reasonIncorrectAccount, status.StatusReason.Reason.Code, "incorrect reason code")
+	assert.Equal(t, "INVOICE-43", status.EndToEndID, "end to end ID not reported")
+
+	//neither the duplicate nor the rejected payment is debited
+	response = bank2Stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "9"}))
+	json.Unmarshal(response.GetPayload(), &debited)
+	assert.Equal(t, "60", debited.Balance.String(), "debtor debited for a rejected payment")
+
+	//messages are only accepted from a member of the debtor agent's MSP
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "payment-hub")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", creditTransferJSON}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "message accepted from another bank")
+}
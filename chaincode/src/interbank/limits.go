@@ -0,0 +1,373 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+// bilateralLimit caps how much one bank may owe another in a currency, stored under the composite key
+// bilateralLimit~From~To~Currency
+//From - the ID of the paying bank
+//To - the ID of the bank extending credit, which sets the limit
+//Exposure - the current net exposure, filled in when limits are listed
+type bilateralLimit struct {
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Currency string          `json:"currency"`
+	Limit    decimal.Decimal `json:"limit"`
+	Exposure decimal.Decimal `json:"exposure"`
+}
+
+//setBilateralLimit caps the net amount a bank may owe another in a currency, on their correspondent accounts in gross
+//mode or for payments awaiting deferred settlement. Payments settled in reserves under rtgs leave nothing owed, so
+//limits are refused in rtgs mode. Callable by the governance MSP or by the MSP of the bank extending credit
+//Args
+//	From		string	the ID of the paying bank
+//	To			string	the ID of the bank extending credit
+//	Currency	string	the currency of the limit
+//	Limit		string	the largest net amount From may owe To
+func (s *InterbankChaincode) setBilateralLimit(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 4 {
+		return shim.Error("Expecting 4 arguments: paying bank ID, receiving bank ID, currency, limit")
+	}
+
+	limit, err := decimal.NewFromString(args[3])
+
+	if err != nil {
+		return shim.Error("Invalid limit " + err.Error())
+	}
+
+	if limit.IsNegative() {
+		return shim.Error("Limit must not be negative")
+	}
+
+	_, err = getKnownRoute(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkCorrespondentOwner(stub, args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	settlement, err := getSettlementPolicy(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if settlement.Mode == settleRTGS {
+		return shim.Error("Bilateral limits are not enforced in rtgs mode, payments are settled in central bank reserves as they are made")
+	}
+
+	limitKey, err := stub.CreateCompositeKey("bilateralLimit", args[:3])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	limitAsBytes, _ := json.Marshal(bilateralLimit{From: args[0], To: args[1], Currency: args[2], Limit: limit})
+	err = stub.PutState(limitKey, limitAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit bilateral limit to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//removeBilateralLimit lifts the cap on what a bank may owe another in a currency. Callable by the governance MSP or
+//by the MSP of the bank extending credit
+//Args
+//	From		string	the ID of the paying bank
+//	To			string	the ID of the bank extending credit
+//	Currency	string	the currency of the limit
+func (s *InterbankChaincode) removeBilateralLimit(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return shim.Error("Expecting 3 arguments: paying bank ID, receiving bank ID, currency")
+	}
+
+	limit, limitKey, err := getBilateralLimit(stub, args[0], args[1], args[2])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if limit == nil {
+		return shim.Error("No limit is set for " + args[2] + " owed by bank " + args[0] + " to bank " + args[1])
+	}
+
+	err = checkCorrespondentOwner(stub, args[1])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(limitKey)
+
+	if err != nil {
+		return shim.Error("Unable to delete bilateral limit " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//listBilateralLimits returns bilateral limits with the current exposure against each
+//Args
+//	From	string	optional, only return the limits on this paying bank
+func (s *InterbankChaincode) listBilateralLimits(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("bilateralLimit", args)
+
+	if err != nil {
+		return shim.Error("Unable to query bilateral limits " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	limits := []bilateralLimit{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		limit := bilateralLimit{}
+		err = json.Unmarshal(queryResponse.Value, &limit)
+
+		if err != nil {
+			return shim.Error("Unable to unmarshal bilateral limit " + err.Error())
+		}
+
+		owed, _, err := newLedgerBook(stub, "exposure").get(limit.From, limit.To, limit.Currency)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		limit.Exposure = owed.Amount
+		limits = append(limits, limit)
+	}
+
+	limitsAsBytes, _ := json.Marshal(limits)
+	return shim.Success(limitsAsBytes)
+}
+
+// getBilateralLimit returns the limit on what one bank may owe another and its key, or nil if there is no limit
+func getBilateralLimit(stub shim.ChaincodeStubInterface, fromBankID string, toBankID string, currency string) (*bilateralLimit, string, error) {
+	limitKey, err := stub.CreateCompositeKey("bilateralLimit", []string{fromBankID, toBankID, currency})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	limitAsBytes, err := stub.GetState(limitKey)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to retrieve bilateral limit from ledger " + err.Error())
+	}
+
+	if limitAsBytes == nil {
+		return nil, limitKey, nil
+	}
+
+	limit := &bilateralLimit{}
+	err = json.Unmarshal(limitAsBytes, limit)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to unmarshal bilateral limit " + err.Error())
+	}
+
+	return limit, limitKey, nil
+}
+
+// ledgerBook holds amounts banks owe each other, stored under an object type such as obligation or exposure, while
+// a transaction changes them. Fabric does not return a transaction's own writes, so a transaction making several
+// payments reads each key once, accumulates the changes here and commits each key once
+type ledgerBook struct {
+	stub       shim.ChaincodeStubInterface
+	objectType string
+	entries    map[string]*obligation
+	added      map[string]decimal.Decimal
+	keys       []string
+}
+
+func newLedgerBook(stub shim.ChaincodeStubInterface, objectType string) *ledgerBook {
+	return &ledgerBook{stub: stub, objectType: objectType, entries: map[string]*obligation{}, added: map[string]decimal.Decimal{}}
+}
+
+// newExposureBook returns the book of exposures between banks, or nil in rtgs mode, where payments are settled in
+// central bank reserves as they are made and so leave no exposure behind. In gross mode what banks owe each other
+// stays on their correspondent accounts until payments back offset it, in deferred mode until the cycle is settled
+func newExposureBook(stub shim.ChaincodeStubInterface, policy *settlementPolicy) *ledgerBook {
+	if policy.Mode == settleRTGS {
+		return nil
+	}
+
+	return newLedgerBook(stub, "exposure")
+}
+
+// get returns what one bank owes another, zero if nothing has been paid between them
+func (b *ledgerBook) get(fromBankID string, toBankID string, currency string) (*obligation, string, error) {
+	key, err := b.stub.CreateCompositeKey(b.objectType, []string{fromBankID, toBankID, currency})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if owed, found := b.entries[key]; found {
+		return owed, key, nil
+	}
+
+	owedAsBytes, err := b.stub.GetState(key)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to retrieve " + b.objectType + " from ledger " + err.Error())
+	}
+
+	owed := &obligation{From: fromBankID, To: toBankID, Currency: currency, Amount: decimal.Zero}
+	if owedAsBytes != nil {
+		err = json.Unmarshal(owedAsBytes, owed)
+
+		if err != nil {
+			return nil, "", errors.New("Unable to unmarshal " + b.objectType + " " + err.Error())
+		}
+	}
+
+	b.entries[key] = owed
+	b.added[key] = decimal.Zero
+	b.keys = append(b.keys, key)
+
+	return owed, key, nil
+}
+
+// add increases what one bank owes another, a negative amount reduces it
+func (b *ledgerBook) add(fromBankID string, toBankID string, currency string, amount decimal.Decimal) error {
+	owed, key, err := b.get(fromBankID, toBankID, currency)
+
+	if err != nil {
+		return err
+	}
+
+	owed.Amount = owed.Amount.Add(amount)
+	b.added[key] = b.added[key].Add(amount)
+
+	return nil
+}
+
+// commit writes every amount that changed to the ledger
+func (b *ledgerBook) commit() error {
+	if b == nil {
+		return nil
+	}
+
+	for _, key := range b.keys {
+		if b.added[key].IsZero() {
+			continue
+		}
+
+		owedAsBytes, _ := json.Marshal(b.entries[key])
+		err := b.stub.PutState(key, owedAsBytes)
+
+		if err != nil {
+			return errors.New("Unable to commit " + b.objectType + " to ledger " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// addExposures adds the hops of a payment to the exposures between their banks, or removes them when sign is -1.
+// A hop increases what the paying bank owes the receiving bank and reduces what the receiving bank owes it
+func addExposures(exposures *ledgerBook, hops []hop, currency string, sign int64) error {
+	if exposures == nil {
+		return nil
+	}
+
+	for _, leg := range hops {
+		amount := leg.Amount.Mul(decimal.New(sign, 0))
+
+		err := exposures.add(leg.From, leg.To, currency, amount)
+
+		if err != nil {
+			return err
+		}
+
+		err = exposures.add(leg.To, leg.From, currency, amount.Neg())
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findBreach returns the first exposure that has grown past its bilateral limit, with the limit, or nil if every
+// limit is respected. Exposures that have not grown are not checked, so payments can always reduce an exposure
+func findBreach(exposures *ledgerBook) (*obligation, *bilateralLimit, error) {
+	if exposures == nil {
+		return nil, nil, nil
+	}
+
+	for _, key := range exposures.keys {
+		if !exposures.added[key].IsPositive() {
+			continue
+		}
+
+		owed := exposures.entries[key]
+		limit, _, err := getBilateralLimit(exposures.stub, owed.From, owed.To, owed.Currency)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if limit != nil && owed.Amount.GreaterThan(limit.Limit) {
+			return owed, limit, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// releaseExposures removes settled obligations from the exposures between their banks. An obligation increased what
+// the paying bank owes the receiving bank and reduced what the receiving bank owes it, so both are reversed
+func releaseExposures(exposures *ledgerBook, settled []obligation) error {
+	for _, owed := range settled {
+		err := exposures.add(owed.From, owed.To, owed.Currency, owed.Amount.Neg())
+
+		if err != nil {
+			return err
+		}
+
+		err = exposures.add(owed.To, owed.From, owed.Currency, owed.Amount)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return exposures.commit()
+}
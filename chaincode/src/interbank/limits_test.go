@@ -0,0 +1,157 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"centralbank"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestBilateralLimit(t *testing.T) {
+	cbankStub := testutil.NewMockStub("centralbank", new(centralbank.CentralBankChaincode))
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.MockPeerChaincode("centralbank", cbankStub)
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	cbankStub.Creator = testutil.NewIdentity("CentralMSP", "reserves")
+	cbankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	for _, args := range [][]string{{"openReserveAccount", "0001", "Org1MSP", "USD"}, {"openReserveAccount", "0002", "Org2MSP", "USD"},
+		{"fundReserve", "0002", "USD", "1000"}, {"authorizeSettlementAgent", "GovMSP"}} {
+		response := cbankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	bankStub := newTestBank(t, ibankStub, "bank", "1", "0", "USD")
+	newTestBank(t, ibankStub, "bank2", "9", "1000", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	//the bank extending credit sets the limit, the paying bank cannot raise it
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setBilateralLimit", "0002", "0001", "USD", "1000"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "limit set by the paying bank")
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setBilateralLimit", "0002", "0001", "USD", "150"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//in gross mode the exposure is what the banks owe each other on their correspondent accounts
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "100", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//a payment breaching the limit is queued rather than credited
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "60", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	queued := transferResult{}
+	json.Unmarshal(response.GetPayload(), &queued)
+	assert.Equal(t, statusQueued, queued.Status, "payment breaching the limit not queued")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", queued.ID}))
+	record := transferRecord{}
+	json.Unmarshal(response.GetPayload(), &record)
+	assert.Equal(t, "Bank 0002 would exceed its bilateral limit of 150 USD with bank 0001", record.Reason, "unexpected reason")
+
+	exposure := func() string {
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listBilateralLimits", "0002"}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		limits := []bilateralLimit{}
+		err := json.Unmarshal(response.GetPayload(), &limits)
+		if err != nil {
+			panic(err)
+		}
+
+		assert.Equal(t, 1, len(limits), "incorrect limits")
+		return limits[0].Exposure.String()
+	}
+
+	assert.Equal(t, "100", exposure(), "incorrect exposure")
+
+	//a payment back offsets the exposure, and the queued payment is released
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "9", "0002", "40", "USD", "0001", "1"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	assert.Equal(t, "60", exposure(), "payment back not offset")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"resolveQueue"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	resolution := queueResolution{}
+	json.Unmarshal(response.GetPayload(), &resolution)
+	assert.Equal(t, []string{queued.ID}, resolution.Released, "queued payment not released")
+	assert.Equal(t, "120", exposure(), "released payment not added to the exposure")
+
+	//payments settled in reserves under rtgs leave nothing owed, so limits can not be set for it
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "rtgs", "centralbank"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "rtgs settlement set with bilateral limits")
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"removeBilateralLimit", "0002", "0001", "USD"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "rtgs", "centralbank"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setBilateralLimit", "0002", "0001", "USD", "150"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "limit set in rtgs mode")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "deferred", "centralbank"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setBilateralLimit", "0002", "0001", "USD", "150"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//in deferred mode the exposure also holds the obligations awaiting settlement
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "30", "USD", "0002", "9"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	deferred := transferResult{}
+	json.Unmarshal(response.GetPayload(), &deferred)
+	assert.Equal(t, statusAccepted, deferred.Status, "payment within the limit not accepted")
+	assert.Equal(t, "150", exposure(), "incorrect exposure")
+
+	//closing the settlement cycle settles the obligation and only removes it from the exposure
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"closeSettlementCycle"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	assert.Equal(t, "120", exposure(), "settled obligation left in the exposure")
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1"}))
+	credited := account{}
+	json.Unmarshal(response.GetPayload(), &credited)
+	assert.Equal(t, "150", credited.Balance.String(), "payments not credited")
+}
@@ -0,0 +1,843 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"rounding"
+	"sort"
+	"strconv"
+)
+
+// queuedPayment is a transfer that has been routed, priced and converted but would breach a bilateral limit or
+// overdraw the sending bank's reserves, stored under the composite key paymentQueue~ID until resolveQueue releases
+// it. The rest of the transfer is in its record
+//Priority - payments with a higher priority are released first, then the oldest
+//Hops - the hops of the payment, in the currency of the transfer
+//BankContract - the chaincode of the beneficiary bank
+//Residual - what rounding the credited amount left over, booked to the rounding account on release
+type queuedPayment struct {
+	ID           string          `json:"id"`
+	Priority     int             `json:"priority"`
+	QueuedAt     int64           `json:"queuedAt"`
+	Hops         []hop           `json:"hops"`
+	BankContract string          `json:"bankContract"`
+	Residual     decimal.Decimal `json:"residual"`
+}
+
+// queuePolicy is stored on the ledger under the key "queuePolicy"
+//ExpiresAfter - the number of seconds a payment can stay queued before expireQueuedPayments refunds it, 0 for no
+//		expiry
+type queuePolicy struct {
+	ExpiresAfter int64 `json:"expiresAfter"`
+}
+
+// refund returns the amount of a queued payment to the originator, as passed to the bank contract's interbankRefund
+//Bank - the ID of the first bank on the payment's path, whose vostro account was credited when the payment was queued
+type refund struct {
+	Account  string          `json:"account"`
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"`
+	Bank     string          `json:"bank"`
+}
+
+// queueResolution is returned by resolveQueue
+//Released - the payments released one at a time, in the order they were released
+//Gridlock - the payments released together because they offset each other
+//Skipped - the payments left queued because a bank on their path no longer has an active route or their exchange
+//		rate can not be used
+//Queued - the number of payments left in the queue, including those skipped
+type queueResolution struct {
+	Released []string `json:"released"`
+	Gridlock []string `json:"gridlock"`
+	Skipped  []string `json:"skipped"`
+	Queued   int      `json:"queued"`
+}
+
+//listQueuedPayments returns the payments waiting in the queue, in the order they will be tried
+func (s *InterbankChaincode) listQueuedPayments(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	queue, err := getQueue(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	queueAsBytes, _ := json.Marshal(queue)
+	return shim.Success(queueAsBytes)
+}
+
+//setPaymentPriority changes the priority of a queued payment. Must be signed by a member of the sending bank's MSP
+//Args
+//	ID			string	the ID of the transfer
+//	Priority	string	the new priority, payments with a higher priority are released first
+func (s *InterbankChaincode) setPaymentPriority(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Expecting 2 arguments: transfer ID, priority")
+	}
+
+	priority, err := strconv.Atoi(args[1])
+
+	if err != nil {
+		return shim.Error("Invalid priority " + err.Error())
+	}
+
+	record, err := getTransferRecord(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if record.Status != statusQueued {
+		return shim.Error("Transfer " + args[0] + " is not queued")
+	}
+
+	_, err = checkSender(stub, record.OriginatorBank)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	queued, err := getQueuedPayment(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	queued.Priority = priority
+
+	err = putQueuedPayment(stub, queued)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//resolveQueue releases queued payments that no longer breach a bilateral limit in gross and deferred mode or, in rtgs
+//mode, overdraw the sending bank's reserves. Payments are tried one at a time in priority order, repeating while
+//releases free up room. The payments still blocked are then tried together, as payments in opposite directions offset
+//each other, dropping the lowest priority payment behind a breached limit or reserve shortfall until the rest fit. The
+//beneficiaries are credited by the interbank contract on behalf of the sending banks, which were debited when the
+//payments were queued. Payments are checked against the current routes and rates first, a payment whose path passes
+//through a bank without an active route or whose rate can not be used is skipped and left queued with the reason,
+//until it can be released or is cancelled or expired. In rtgs mode the caller's MSP must be a settlement agent at the central bank. Only callable by
+//the governance MSP
+func (s *InterbankChaincode) resolveQueue(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	_, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	queue, err := getQueue(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	records := map[string]*transferRecord{}
+	skipped := []string{}
+	eligible := []queuedPayment{}
+
+	for _, queued := range queue {
+		record, err := getTransferRecord(stub, queued.ID)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		records[queued.ID] = record
+
+		reason, err := recheckPayment(stub, &queued, record)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		if reason != "" {
+			record.Reason = reason
+
+			err = putTransferRecord(stub, record, false)
+
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			skipped = append(skipped, queued.ID)
+			continue
+		}
+
+		eligible = append(eligible, queued)
+	}
+
+	settlement, err := getSettlementPolicy(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	exposures := newExposureBook(stub, settlement)
+	reserves := newReserveBook(stub, settlement)
+	released := []queuedPayment{}
+	blocked := eligible
+
+	for progress := true; progress; {
+		progress = false
+		stillBlocked := []queuedPayment{}
+
+		for _, queued := range blocked {
+			fits, err := tryPayment(exposures, reserves, queued, records[queued.ID].Currency)
+
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			if fits {
+				released = append(released, queued)
+				progress = true
+			} else {
+				stillBlocked = append(stillBlocked, queued)
+			}
+		}
+
+		blocked = stillBlocked
+	}
+
+	//gridlock resolution, add every blocked payment then drop the last one behind each breached limit or reserve
+	//shortfall until all fit
+	gridlock := blocked
+	for _, queued := range gridlock {
+		err = addPayment(exposures, reserves, queued, records[queued.ID].Currency, 1)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	for len(gridlock) > 0 {
+		breach, _, err := findBreach(exposures)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		shortfall := reserves.findShortfall()
+
+		if breach == nil && shortfall == nil {
+			break
+		}
+
+		dropped := -1
+		for i := len(gridlock) - 1; i >= 0 && dropped < 0; i-- {
+			for _, leg := range gridlock[i].Hops {
+				currency := records[gridlock[i].ID].Currency
+
+				if (breach != nil && leg.From == breach.From && leg.To == breach.To && currency == breach.Currency) ||
+					(breach == nil && leg.From == shortfall.BankID && currency == shortfall.Currency) {
+					dropped = i
+					break
+				}
+			}
+		}
+
+		if dropped < 0 && breach != nil {
+			return shim.Error("Unable to resolve gridlock, no queued payment adds to the exposure of bank " + breach.From + " to bank " + breach.To)
+		}
+
+		if dropped < 0 {
+			return shim.Error("Unable to resolve gridlock, no queued payment draws on the reserves of bank " + shortfall.BankID)
+		}
+
+		err = addPayment(exposures, reserves, gridlock[dropped], records[gridlock[dropped].ID].Currency, -1)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		gridlock = append(gridlock[:dropped:dropped], gridlock[dropped+1:]...)
+	}
+
+	resolution := queueResolution{Released: []string{}, Gridlock: []string{}, Skipped: skipped, Queued: len(blocked) - len(gridlock) + len(skipped)}
+	releasing := []queuedPayment{}
+
+	for i, batch := range [][]queuedPayment{released, gridlock} {
+		for _, queued := range batch {
+			releasing = append(releasing, queued)
+
+			if i == 0 {
+				resolution.Released = append(resolution.Released, queued.ID)
+			} else {
+				resolution.Gridlock = append(resolution.Gridlock, queued.ID)
+			}
+		}
+	}
+
+	releasingRecords := []*transferRecord{}
+	for _, queued := range releasing {
+		releasingRecords = append(releasingRecords, records[queued.ID])
+
+		queueKey, err := stub.CreateCompositeKey("paymentQueue", []string{queued.ID})
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = stub.DelState(queueKey)
+
+		if err != nil {
+			return shim.Error("Unable to delete queued payment " + err.Error())
+		}
+	}
+
+	err = s.releasePayments(stub, exposures, releasing, releasingRecords, false)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resolutionAsBytes, _ := json.Marshal(resolution)
+	return shim.Success(resolutionAsBytes)
+}
+
+//cancelQueuedPayment takes a payment out of the queue and refunds the originator through the sending bank's contract.
+//Must be signed by a member of the sending bank's MSP
+//Args
+//	ID	string	the ID of the transfer
+func (s *InterbankChaincode) cancelQueuedPayment(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: the transfer ID")
+	}
+
+	record, err := getTransferRecord(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if record.Status != statusQueued {
+		return shim.Error("Transfer " + args[0] + " is not queued")
+	}
+
+	_, err = checkSender(stub, record.OriginatorBank)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	queued, err := getQueuedPayment(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = refundPayments(stub, []queuedPayment{*queued}, []*transferRecord{record}, statusCancelled, "Cancelled by "+invoker.key())
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//setQueueExpiry sets how long a payment can stay queued before expireQueuedPayments refunds it. Only callable by the
+//governance MSP
+//Args:
+//	Seconds	string	the number of seconds, 0 for queued payments to never expire
+func (s *InterbankChaincode) setQueueExpiry(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect arguments, expecting the number of seconds a payment can stay queued")
+	}
+
+	seconds, err := strconv.ParseInt(args[0], 10, 64)
+
+	if err != nil || seconds < 0 {
+		return shim.Error("Invalid number of seconds " + args[0])
+	}
+
+	_, err = checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policyBytes, _ := json.Marshal(queuePolicy{ExpiresAfter: seconds})
+	err = stub.PutState("queuePolicy", policyBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit queue policy to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//expireQueuedPayments refunds the payments that have been queued for longer than the queue expiry and returns their
+//IDs. Only callable by the governance MSP
+func (s *InterbankChaincode) expireQueuedPayments(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	_, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policy, err := getQueuePolicy(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	queue, err := getQueue(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	expired := []queuedPayment{}
+	records := []*transferRecord{}
+	expiredIDs := []string{}
+
+	for _, queued := range queue {
+		if policy.ExpiresAfter == 0 || queued.QueuedAt+policy.ExpiresAfter > timestamp.GetSeconds() {
+			continue
+		}
+
+		record, err := getTransferRecord(stub, queued.ID)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		expired = append(expired, queued)
+		records = append(records, record)
+		expiredIDs = append(expiredIDs, queued.ID)
+	}
+
+	err = refundPayments(stub, expired, records, statusExpired, "Queued for longer than "+strconv.FormatInt(policy.ExpiresAfter, 10)+" seconds")
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	expiredAsBytes, _ := json.Marshal(expiredIDs)
+	return shim.Success(expiredAsBytes)
+}
+
+// refundPayments takes payments out of the queue, records why and refunds their originators with the sending banks'
+// interbankRefund, under the interbank contract's authority. Each bank contract is invoked once with all of its
+// refunds, as a chaincode does not read its own writes within a transaction
+func refundPayments(stub shim.ChaincodeStubInterface, payments []queuedPayment, records []*transferRecord, status string, reason string) error {
+	refunds := map[string][]refund{}
+	refundOrder := []string{}
+	contracts := map[string]string{}
+
+	for i, queued := range payments {
+		record := records[i]
+
+		queueKey, err := stub.CreateCompositeKey("paymentQueue", []string{queued.ID})
+
+		if err != nil {
+			return err
+		}
+
+		err = stub.DelState(queueKey)
+
+		if err != nil {
+			return errors.New("Unable to delete queued payment " + err.Error())
+		}
+
+		record.Status = status
+		record.Reason = reason
+
+		err = putTransferRecord(stub, record, false)
+
+		if err != nil {
+			return err
+		}
+
+		if _, found := contracts[record.OriginatorBank]; !found {
+			sender, err := getKnownRoute(stub, record.OriginatorBank)
+
+			if err != nil {
+				return err
+			}
+
+			contracts[record.OriginatorBank] = sender.BankContract
+			refundOrder = append(refundOrder, sender.BankContract)
+		}
+
+		contract := contracts[record.OriginatorBank]
+		refunds[contract] = append(refunds[contract], refund{Account: record.OriginatorAccount, Amount: record.Amount,
+			Currency: record.Currency, Bank: queued.Hops[0].To})
+	}
+
+	for _, contract := range refundOrder {
+		refundsAsBytes, _ := json.Marshal(refunds[contract])
+		stringArgs := []string{"interbankRefund", string(refundsAsBytes)}
+		response := stub.InvokeChaincode(contract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+		if response.GetStatus() != shim.OK {
+			return errors.New("Unable to refund the originator " + response.Message)
+		}
+	}
+
+	return nil
+}
+
+// recheckPayment brings a queued payment up to date before it is released, returning why it can not be released yet.
+// Every bank the payment is paid to must still have an active route, and the beneficiary's bank is paid through its
+// current contract. A conversion without a quote is repriced at the current rate, which must not have expired, a quoted
+// conversion keeps the rate locked in when the quote was consumed
+func recheckPayment(stub shim.ChaincodeStubInterface, queued *queuedPayment, record *transferRecord) (string, error) {
+	var toRoute *route
+
+	for _, paid := range queued.Hops {
+		bankRoute, err := getRoute(stub, paid.To)
+
+		if err != nil {
+			return "", err
+		}
+
+		if bankRoute == nil {
+			return "Route to bank " + paid.To + " has been removed", nil
+		}
+
+		if bankRoute.Suspended {
+			return "Route to bank " + paid.To + " is suspended", nil
+		}
+
+		toRoute = bankRoute
+	}
+
+	queued.BankContract = toRoute.BankContract
+
+	if record.Currency == record.CreditedCurrency || record.QuoteID != "" {
+		return "", nil
+	}
+
+	rate, err := currencyConversion(stub, toRoute.ForexContract, record.Currency, record.CreditedCurrency)
+
+	if err != nil {
+		return "Unable to perform currency conversion " + err.Error(), nil
+	}
+
+	policy, err := rounding.GetPolicy(stub)
+
+	if err != nil {
+		return "", err
+	}
+
+	//convert what reaches the beneficiary's bank, after the fees kept along the path
+	last := queued.Hops[len(queued.Hops)-1]
+	convertedAmount := last.Amount.Sub(last.Fee).Mul(rate)
+
+	record.Rate = rate
+	record.CreditedAmount = rounding.Round(convertedAmount, record.CreditedCurrency, policy.Mode)
+	queued.Residual = convertedAmount.Sub(record.CreditedAmount)
+
+	return "", nil
+}
+
+// addPayment adds the hops of a payment to the exposures in gross and deferred mode or the reserves in rtgs mode, or
+// removes them when sign is -1
+func addPayment(exposures *ledgerBook, reserves *reserveBook, queued queuedPayment, currency string, sign int64) error {
+	err := addExposures(exposures, queued.Hops, currency, sign)
+
+	if err != nil {
+		return err
+	}
+
+	return reserves.add(queued.Hops, currency, sign)
+}
+
+// tryPayment adds a payment to the exposures and reserves if that breaches no bilateral limit and overdraws no
+// reserve account, returning whether it was added
+func tryPayment(exposures *ledgerBook, reserves *reserveBook, queued queuedPayment, currency string) (bool, error) {
+	err := addPayment(exposures, reserves, queued, currency, 1)
+
+	if err != nil {
+		return false, err
+	}
+
+	breach, _, err := findBreach(exposures)
+
+	if err != nil {
+		return false, err
+	}
+
+	if breach == nil && reserves.findShortfall() == nil {
+		return true, nil
+	}
+
+	return false, addPayment(exposures, reserves, queued, currency, -1)
+}
+
+// releasePayments completes payments whose exposures have been added to the book. The exposures are committed,
+// obligations are recorded when settlement is deferred, reserves are moved at the central bank in rtgs mode,
+// each bank on the path books its leg and the records are updated.
+// Each bank contract is invoked once with all of its legs and residuals are combined per currency, as a chaincode
+// does not read its own writes within a transaction
+func (s *InterbankChaincode) releasePayments(stub shim.ChaincodeStubInterface, exposures *ledgerBook, payments []queuedPayment, records []*transferRecord, isNew bool) error {
+	err := exposures.commit()
+
+	if err != nil {
+		return err
+	}
+
+	settlement, err := getSettlementPolicy(stub)
+
+	if err != nil {
+		return err
+	}
+
+	cycle, err := getSettlementCycle(stub)
+
+	if err != nil {
+		return err
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return errors.New("Unable to get transaction timestamp " + err.Error())
+	}
+
+	obligations := newLedgerBook(stub, "obligation")
+	legs := map[string][]leg{}
+	legOrder := []string{}
+	contracts := map[string]string{}
+	residuals := map[string]decimal.Decimal{}
+	residualOrder := []string{}
+
+	for i, queued := range payments {
+		record := records[i]
+
+		if settlement.Mode == settleDeferred {
+			for _, leg := range queued.Hops {
+				err = obligations.add(leg.From, leg.To, record.Currency, leg.Amount)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			record.Status = statusAccepted
+			record.Cycle = cycle.Cycle
+		} else {
+			record.Status = statusSettled
+			record.SettledAt = timestamp.GetSeconds()
+		}
+
+		record.Reason = ""
+
+		//each bank on the path books its leg, the beneficiary's bank credits the beneficiary
+		for j, paid := range queued.Hops {
+			bankLeg := leg{From: paid.From, Amount: paid.Amount, Fee: paid.Fee, Currency: record.Currency}
+			contract := queued.BankContract
+
+			if j < len(queued.Hops)-1 {
+				bankLeg.To = queued.Hops[j+1].To
+
+				if _, found := contracts[paid.To]; !found {
+					intermediary, err := getKnownRoute(stub, paid.To)
+
+					if err != nil {
+						return err
+					}
+
+					contracts[paid.To] = intermediary.BankContract
+				}
+
+				contract = contracts[paid.To]
+			} else {
+				bankLeg.Originator = record.OriginatorBank
+				bankLeg.Account = record.BeneficiaryAccount
+				bankLeg.Credit = record.CreditedAmount
+			}
+
+			if _, found := legs[contract]; !found {
+				legOrder = append(legOrder, contract)
+			}
+			legs[contract] = append(legs[contract], bankLeg)
+		}
+
+		if _, found := residuals[record.CreditedCurrency]; !found {
+			residualOrder = append(residualOrder, record.CreditedCurrency)
+		}
+		residuals[record.CreditedCurrency] = residuals[record.CreditedCurrency].Add(queued.Residual)
+
+		err = putTransferRecord(stub, record, isNew)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = obligations.commit()
+
+	if err != nil {
+		return err
+	}
+
+	if settlement.Mode == settleRTGS {
+		err = settleReserves(stub, settlement.CentralBankContract, payments, records)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, contract := range legOrder {
+		legsAsBytes, _ := json.Marshal(legs[contract])
+		stringArgs := []string{"receiveInterbank", string(legsAsBytes)}
+		response := stub.InvokeChaincode(contract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+		if response.GetStatus() != shim.OK {
+			return errors.New("Failed to make payment " + response.Message)
+		}
+	}
+
+	for _, currency := range residualOrder {
+		err = rounding.BookResidual(stub, currency, residuals[currency])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getQueue returns the queued payments, highest priority first and then oldest first
+func getQueue(stub shim.ChaincodeStubInterface) ([]queuedPayment, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("paymentQueue", []string{})
+
+	if err != nil {
+		return nil, errors.New("Unable to query payment queue " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	queue := []queuedPayment{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		queued := queuedPayment{}
+		err = json.Unmarshal(queryResponse.Value, &queued)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal queued payment " + err.Error())
+		}
+
+		queue = append(queue, queued)
+	}
+
+	sort.SliceStable(queue, func(i, j int) bool {
+		if queue[i].Priority != queue[j].Priority {
+			return queue[i].Priority > queue[j].Priority
+		}
+		return queue[i].QueuedAt < queue[j].QueuedAt
+	})
+
+	return queue, nil
+}
+
+// getQueuedPayment reads a payment from the queue
+func getQueuedPayment(stub shim.ChaincodeStubInterface, id string) (*queuedPayment, error) {
+	queueKey, err := stub.CreateCompositeKey("paymentQueue", []string{id})
+
+	if err != nil {
+		return nil, err
+	}
+
+	queuedAsBytes, err := stub.GetState(queueKey)
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve queued payment from ledger " + err.Error())
+	}
+
+	if queuedAsBytes == nil {
+		return nil, errors.New("Transfer " + id + " is not queued")
+	}
+
+	queued := &queuedPayment{}
+	err = json.Unmarshal(queuedAsBytes, queued)
+
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal queued payment " + err.Error())
+	}
+
+	return queued, nil
+}
+
+// getQueuePolicy returns the queue policy on the ledger, with no expiry if none has been set
+func getQueuePolicy(stub shim.ChaincodeStubInterface) (*queuePolicy, error) {
+	policy := &queuePolicy{}
+
+	policyBytes, err := stub.GetState("queuePolicy")
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve queue policy from ledger " + err.Error())
+	}
+
+	if policyBytes != nil {
+		err = json.Unmarshal(policyBytes, policy)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal queue policy " + err.Error())
+		}
+	}
+
+	return policy, nil
+}
+
+func putQueuedPayment(stub shim.ChaincodeStubInterface, queued *queuedPayment) error {
+	queueKey, err := stub.CreateCompositeKey("paymentQueue", []string{queued.ID})
+
+	if err != nil {
+		return err
+	}
+
+	queuedAsBytes, _ := json.Marshal(queued)
+	err = stub.PutState(queueKey, queuedAsBytes)
+
+	if err != nil {
+		return errors.New("Unable to commit queued payment to ledger " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,304 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"forex"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestGridlockResolution(t *testing.T) {
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	//each bank pays from and is paid into its account 1
+	bankStub := newTestBank(t, ibankStub, "bank", "1", "1000", "USD")
+	bank2Stub := newTestBank(t, ibankStub, "bank2", "1", "1000", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	for _, args := range [][]string{{"setSettlementMode", "deferred", "centralbank"}, {"setBilateralLimit", "0001", "0002", "USD", "50"},
+		{"setBilateralLimit", "0002", "0001", "USD", "50"}} {
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	pay := func(toBankID string, amount string, fromBankID string, mspID string) string {
+		ibankStub.Creator = testutil.NewIdentity(mspID, "customer")
+
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", toBankID, amount, "USD", fromBankID, "1"}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		result := transferResult{}
+		json.Unmarshal(response.GetPayload(), &result)
+		assert.Equal(t, statusQueued, result.Status, "payment over the limit not queued")
+		return result.ID
+	}
+
+	//every payment is over the limit on its own
+	first := pay("0002", "100", "0001", "Org1MSP")
+	second := pay("0001", "80", "0002", "Org2MSP")
+	third := pay("0001", "300", "0002", "Org2MSP")
+
+	//only the sending bank can change the priority of a payment
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setPaymentPriority", first, "5"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "priority changed by another bank")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setPaymentPriority", second, "5"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listQueuedPayments"}))
+	queue := []queuedPayment{}
+	json.Unmarshal(response.GetPayload(), &queue)
+	assert.Equal(t, 3, len(queue), "incorrect queue")
+	assert.Equal(t, second, queue[0].ID, "queue not ordered by priority")
+
+	//the first two payments offset each other to within the limit, the third is left queued
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"resolveQueue"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	resolution := queueResolution{}
+	err := json.Unmarshal(response.GetPayload(), &resolution)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, []string{}, resolution.Released, "payment released on its own")
+	assert.Equal(t, []string{second, first}, resolution.Gridlock, "offsetting payments not released")
+	assert.Equal(t, 1, resolution.Queued, "incorrect queue length")
+
+	//payments are debited when they are queued, and credited when they are released
+	for i, stub := range []*testutil.MockStub{bankStub, bank2Stub} {
+		response = stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1"}))
+		credited := account{}
+		json.Unmarshal(response.GetPayload(), &credited)
+		assert.Equal(t, []string{"980", "720"}[i], credited.Balance.String(), "incorrect balance")
+	}
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", third}))
+	record := transferRecord{}
+	json.Unmarshal(response.GetPayload(), &record)
+	assert.Equal(t, statusQueued, record.Status, "blocked payment released")
+}
+
+func TestQueuedPaymentCancellation(t *testing.T) {
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	bankStub := newTestBank(t, ibankStub, "bank", "1", "1000", "USD")
+	newTestBank(t, ibankStub, "bank2", "1", "1000", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	for _, args := range [][]string{{"setSettlementMode", "deferred", "centralbank"}, {"setBilateralLimit", "0001", "0002", "USD", "50"}} {
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	pay := func() string {
+		ibankStub.Creator = testutil.NewIdentity("Org1MSP", "customer")
+
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0002", "100", "USD", "0001", "1"}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		result := transferResult{}
+		json.Unmarshal(response.GetPayload(), &result)
+		assert.Equal(t, statusQueued, result.Status, "payment over the limit not queued")
+		return result.ID
+	}
+
+	balance := func() string {
+		response := bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1"}))
+		payer := account{}
+		json.Unmarshal(response.GetPayload(), &payer)
+		return payer.Balance.String()
+	}
+
+	status := func(id string) string {
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", id}))
+		record := transferRecord{}
+		json.Unmarshal(response.GetPayload(), &record)
+		return record.Status
+	}
+
+	//only the sending bank can cancel a queued payment, which refunds the payer
+	cancelled := pay()
+	assert.Equal(t, "900", balance(), "queued payment not debited")
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"cancelQueuedPayment", cancelled}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "payment cancelled by another bank")
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"cancelQueuedPayment", cancelled}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	assert.Equal(t, "1000", balance(), "cancelled payment not refunded")
+	assert.Equal(t, statusCancelled, status(cancelled), "incorrect status")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"cancelQueuedPayment", cancelled}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "payment cancelled twice")
+
+	//payments queued for longer than the expiry are refunded
+	expiring := pay()
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setQueueExpiry", "3600"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "queue expiry set outside governance")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setQueueExpiry", "3600"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"expireQueuedPayments"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	assert.Equal(t, "[]", string(response.GetPayload()), "payment expired early")
+
+	//age the queued payment past the expiry
+	queueKey, _ := ibankStub.CreateCompositeKey("paymentQueue", []string{expiring})
+	queued := queuedPayment{}
+	json.Unmarshal(ibankStub.State[queueKey], &queued)
+	queued.QueuedAt -= 3600
+	ibankStub.State[queueKey], _ = json.Marshal(queued)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"expireQueuedPayments"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	assert.Equal(t, `["`+expiring+`"]`, string(response.GetPayload()), "payment not expired")
+	assert.Equal(t, "1000", balance(), "expired payment not refunded")
+	assert.Equal(t, statusExpired, status(expiring), "incorrect status")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listQueuedPayments"}))
+	assert.Equal(t, "[]", string(response.GetPayload()), "refunded payments left in the queue")
+}
+
+func TestQueuedPaymentRecheck(t *testing.T) {
+	forexStub := testutil.NewMockStub("forex", new(forex.ForexChaincode))
+	forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
+	forexStub.MockInit(uuid.New().String(), [][]byte{})
+	testutil.RegisterRateProvider(t, forexStub)
+
+	setRate := func(rate string) {
+		forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
+		response := forexStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "USD", "GBP", rate}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	setRate("0.80")
+
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+	ibankStub.MockPeerChaincode("forex", forexStub)
+
+	//bank 0001 pays in USD through bank 0003 to an account in GBP at bank 0002
+	newTestBank(t, ibankStub, "bank", "1", "1000", "USD")
+	bank2Stub := newTestBank(t, ibankStub, "bank2", "1", "0", "GBP")
+	newTestBank(t, ibankStub, "bank3", "1", "0", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.RegisterRoute(t, ibankStub, "0003", "bank3", "forex", "Org3MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0003", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0003", "0002", "0")
+
+	setLimit := func(limit string) {
+		ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setBilateralLimit", "0001", "0003", "USD", limit}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "deferred", "centralbank"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	setLimit("50")
+
+	pay := func() string {
+		ibankStub.Creator = testutil.NewIdentity("Org1MSP", "customer")
+
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0002", "100", "USD", "0001", "1"}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		result := transferResult{}
+		json.Unmarshal(response.GetPayload(), &result)
+		assert.Equal(t, statusQueued, result.Status, "payment over the limit not queued")
+		return result.ID
+	}
+
+	resolve := func() queueResolution {
+		ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"resolveQueue"}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		resolution := queueResolution{}
+		json.Unmarshal(response.GetPayload(), &resolution)
+		return resolution
+	}
+
+	getRecord := func(id string) transferRecord {
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", id}))
+		record := transferRecord{}
+		json.Unmarshal(response.GetPayload(), &record)
+		return record
+	}
+
+	//a released payment is converted at the rate when it is released, not when it was queued
+	repriced := pay()
+	setRate("0.75")
+	setLimit("1000")
+
+	resolution := resolve()
+	assert.Equal(t, []string{repriced}, resolution.Released, "queued payment not released")
+	assert.Equal(t, "0.75", getRecord(repriced).Rate.String(), "payment released at the queued rate")
+
+	response = bank2Stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1"}))
+	credited := account{}
+	json.Unmarshal(response.GetPayload(), &credited)
+	assert.Equal(t, "75", credited.Balance.String(), "payment not credited at the current rate")
+
+	//a payment through a bank whose route has been removed is skipped, without failing the resolution
+	setLimit("50")
+	stranded := pay()
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"removeRoute", "0003"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	setLimit("1000")
+
+	resolution = resolve()
+	assert.Equal(t, []string{}, resolution.Released, "payment released through a removed route")
+	assert.Equal(t, []string{stranded}, resolution.Skipped, "payment not skipped")
+	assert.Equal(t, 1, resolution.Queued, "skipped payment not left queued")
+
+	record := getRecord(stranded)
+	assert.Equal(t, statusQueued, record.Status, "incorrect status")
+	assert.Equal(t, "Route to bank 0003 has been removed", record.Reason, "incorrect reason")
+}
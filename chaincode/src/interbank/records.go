@@ -0,0 +1,266 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// statusQueued transfers are held in the payment queue until resolveQueue can release them
+	statusQueued = "queued"
+	// statusAccepted transfers have credited the beneficiary and wait for their settlement cycle to close
+	statusAccepted = "accepted"
+	// statusSettled transfers have been settled between the banks
+	statusSettled = "settled"
+	// statusReturned transfers were rejected by the beneficiary bank and their funds returned to the originator
+	statusReturned = "returned"
+	// statusCancelled transfers were cancelled by the sending bank while queued and their funds refunded
+	statusCancelled = "cancelled"
+	// statusExpired transfers were queued for longer than the queue expiry and their funds refunded
+	statusExpired = "expired"
+)
+
+// transferRecord is the interbank message kept for every transfer, stored under the composite key
+// interbankTransfer~ID where ID is the ID of the transaction that made the transfer. It is indexed for the
+// originator and beneficiary banks under transferByBank~BankID~CreatedAt~ID
+//Amount, Currency - the amount sent, in the currency of the originator's account
+//CreditedAmount, CreditedCurrency - the amount credited to the beneficiary, in the currency of their account
+//Rate - the exchange rate the amount reaching the beneficiary bank was converted at
+//Cycle - the settlement cycle of a deferred transfer
+//Reason - why a queued transfer could not be released, why a returned transfer was rejected, or who cancelled a
+//	cancelled transfer
+//ReasonCode - the ISO 20022 reason code of a returned transfer
+//MessageID, EndToEndID, UETR - the identification of the pacs.008 message the transfer was made from
+//DebtorName, CreditorName, RemittanceInfo - the details given by a pacs.008 message
+type transferRecord struct {
+	ID                 string          `json:"id"`
+	OriginatorBank     string          `json:"originatorBank"`
+	OriginatorAccount  string          `json:"originatorAccount"`
+	BeneficiaryBank    string          `json:"beneficiaryBank"`
+	BeneficiaryAccount string          `json:"beneficiaryAccount"`
+	Amount             decimal.Decimal `json:"amount"`
+	Currency           string          `json:"currency"`
+	CreditedAmount     decimal.Decimal `json:"creditedAmount"`
+	CreditedCurrency   string          `json:"creditedCurrency"`
+	Rate               decimal.Decimal `json:"rate"`
+	QuoteID            string          `json:"quoteID,omitempty"`
+	Path               []string        `json:"path"`
+	Status             string          `json:"status"`
+	Reason             string          `json:"reason,omitempty"`
+	ReasonCode         string          `json:"reasonCode,omitempty"`
+	Cycle              int64           `json:"cycle,omitempty"`
+	CreatedAt          int64           `json:"createdAt"`
+	SettledAt          int64           `json:"settledAt,omitempty"`
+	MessageID          string          `json:"messageID,omitempty"`
+	EndToEndID         string          `json:"endToEndID,omitempty"`
+	UETR               string          `json:"uetr,omitempty"`
+	DebtorName         string          `json:"debtorName,omitempty"`
+	CreditorName       string          `json:"creditorName,omitempty"`
+	RemittanceInfo     []string        `json:"remittanceInfo,omitempty"`
+}
+
+//getInterbankTransfer returns the record of an interbank transfer
+//Args
+//	ID	string	the ID of the transfer, as returned by interbankTransfer
+func (s *InterbankChaincode) getInterbankTransfer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: the transfer ID")
+	}
+
+	record, err := getTransferRecord(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	recordAsBytes, _ := json.Marshal(record)
+	return shim.Success(recordAsBytes)
+}
+
+//listInterbankTransfersByBank returns the transfers a bank sent or received, oldest first
+//Args
+//	BankID	string	the ID of the bank
+func (s *InterbankChaincode) listInterbankTransfersByBank(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: the bank ID")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("transferByBank", []string{args[0]})
+
+	if err != nil {
+		return shim.Error("Unable to query interbank transfers " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	records := []transferRecord{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		record, err := getTransferRecord(stub, keyParts[2])
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		records = append(records, *record)
+	}
+
+	recordsAsBytes, _ := json.Marshal(records)
+	return shim.Success(recordsAsBytes)
+}
+
+// getTransferRecord reads the record of a transfer from the ledger
+func getTransferRecord(stub shim.ChaincodeStubInterface, transferID string) (*transferRecord, error) {
+	recordKey, err := stub.CreateCompositeKey("interbankTransfer", []string{transferID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	recordAsBytes, err := stub.GetState(recordKey)
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve interbank transfer from ledger " + err.Error())
+	}
+
+	if recordAsBytes == nil {
+		return nil, errors.New("Unknown interbank transfer " + transferID)
+	}
+
+	record := &transferRecord{}
+	err = json.Unmarshal(recordAsBytes, record)
+
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal interbank transfer " + err.Error())
+	}
+
+	return record, nil
+}
+
+// putTransferRecord writes the record of a transfer to the ledger, indexing new records for both banks and, while
+// accepted transfers wait to be settled, for their settlement cycle
+func putTransferRecord(stub shim.ChaincodeStubInterface, record *transferRecord, isNew bool) error {
+	recordKey, err := stub.CreateCompositeKey("interbankTransfer", []string{record.ID})
+
+	if err != nil {
+		return err
+	}
+
+	recordAsBytes, _ := json.Marshal(record)
+	err = stub.PutState(recordKey, recordAsBytes)
+
+	if err != nil {
+		return errors.New("Unable to commit interbank transfer to ledger " + err.Error())
+	}
+
+	indexKeys := []string{}
+	if isNew {
+		for _, bankID := range []string{record.OriginatorBank, record.BeneficiaryBank} {
+			indexKey, err := stub.CreateCompositeKey("transferByBank", []string{bankID, fmt.Sprintf("%020d", record.CreatedAt), record.ID})
+
+			if err != nil {
+				return err
+			}
+
+			indexKeys = append(indexKeys, indexKey)
+		}
+	}
+
+	if record.Status == statusAccepted {
+		indexKey, err := stub.CreateCompositeKey("transferByCycle", []string{fmt.Sprintf("%010d", record.Cycle), record.ID})
+
+		if err != nil {
+			return err
+		}
+
+		indexKeys = append(indexKeys, indexKey)
+	}
+
+	for _, indexKey := range indexKeys {
+		err = stub.PutState(indexKey, []byte{0x00})
+
+		if err != nil {
+			return errors.New("Unable to commit interbank transfer index to ledger " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// settleTransferRecords marks the transfers of a settlement cycle as settled
+func settleTransferRecords(stub shim.ChaincodeStubInterface, cycle int64, settledAt int64) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("transferByCycle", []string{fmt.Sprintf("%010d", cycle)})
+
+	if err != nil {
+		return errors.New("Unable to query interbank transfers " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return err
+		}
+
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		record, err := getTransferRecord(stub, keyParts[1])
+
+		if err != nil {
+			return err
+		}
+
+		record.Status = statusSettled
+		record.SettledAt = settledAt
+
+		err = putTransferRecord(stub, record, false)
+
+		if err != nil {
+			return err
+		}
+
+		err = stub.DelState(queryResponse.Key)
+
+		if err != nil {
+			return errors.New("Unable to delete interbank transfer index " + err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shopspring/decimal"
+)
+
+// reserve is a bank's reserve account as returned by the CentralBankChaincode
+type reserve struct {
+	BankID   string          `json:"bankID"`
+	Currency string          `json:"currency"`
+	Balance  decimal.Decimal `json:"balance"`
+}
+
+// movement moves reserves from one bank to another, as passed to the CentralBankChaincode's settle
+type movement struct {
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Currency string          `json:"currency"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+// reserveBook tracks how the payments being released would change the banks' reserves at the central bank, so a
+// payment the paying bank cannot fund is kept in the queue rather than failing the transaction
+type reserveBook struct {
+	stub     shim.ChaincodeStubInterface
+	contract string
+	entries  map[string]*reserve
+	changes  map[string]decimal.Decimal
+	keys     []string
+}
+
+// newReserveBook returns a book of reserves for rtgs settlement, or nil if payments are not settled in reserves
+func newReserveBook(stub shim.ChaincodeStubInterface, policy *settlementPolicy) *reserveBook {
+	if policy.Mode != settleRTGS {
+		return nil
+	}
+
+	return &reserveBook{stub: stub, contract: policy.CentralBankContract, entries: map[string]*reserve{}, changes: map[string]decimal.Decimal{}}
+}
+
+// get returns a bank's reserve account, retrieved from the central bank the first time it is needed
+func (b *reserveBook) get(bankID string, currency string) (string, error) {
+	key := bankID + "~" + currency
+
+	if _, found := b.entries[key]; found {
+		return key, nil
+	}
+
+	stringArgs := []string{"getReserve", bankID, currency}
+	response := b.stub.InvokeChaincode(b.contract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+	if response.GetStatus() != shim.OK {
+		return "", errors.New("Unable to retrieve reserves from the central bank " + response.Message)
+	}
+
+	account := &reserve{}
+	err := json.Unmarshal(response.GetPayload(), account)
+
+	if err != nil {
+		return "", errors.New("Unable to unmarshal reserve account " + err.Error())
+	}
+
+	b.entries[key] = account
+	b.changes[key] = decimal.Zero
+	b.keys = append(b.keys, key)
+
+	return key, nil
+}
+
+// add moves the hops of a payment between the banks' reserves, or moves them back when sign is -1
+func (b *reserveBook) add(hops []hop, currency string, sign int64) error {
+	if b == nil {
+		return nil
+	}
+
+	for _, leg := range hops {
+		amount := leg.Amount.Mul(decimal.New(sign, 0))
+
+		fromKey, err := b.get(leg.From, currency)
+
+		if err != nil {
+			return err
+		}
+
+		toKey, err := b.get(leg.To, currency)
+
+		if err != nil {
+			return err
+		}
+
+		b.changes[fromKey] = b.changes[fromKey].Sub(amount)
+		b.changes[toKey] = b.changes[toKey].Add(amount)
+	}
+
+	return nil
+}
+
+// findShortfall returns the first reserve account that the payments would overdraw, or nil if every bank can pay
+func (b *reserveBook) findShortfall() *reserve {
+	if b == nil {
+		return nil
+	}
+
+	for _, key := range b.keys {
+		if b.entries[key].Balance.Add(b.changes[key]).IsNegative() {
+			return b.entries[key]
+		}
+	}
+
+	return nil
+}
+
+// settleReserves asks the central bank to move reserves for every hop of the payments, all at once under the
+// transaction ID so the reserves move together with the customer legs
+func settleReserves(stub shim.ChaincodeStubInterface, contract string, payments []queuedPayment, records []*transferRecord) error {
+	movements := []movement{}
+
+	for i, queued := range payments {
+		for _, leg := range queued.Hops {
+			movements = append(movements, movement{From: leg.From, To: leg.To, Currency: records[i].Currency, Amount: leg.Amount})
+		}
+	}
+
+	return moveReserves(stub, contract, stub.GetTxID(), movements)
+}
+
+// moveReserves makes movements between the banks' reserve accounts with the central bank's settle, under a reference
+// that can only be settled once
+func moveReserves(stub shim.ChaincodeStubInterface, contract string, reference string, movements []movement) error {
+	movementsAsBytes, _ := json.Marshal(movements)
+	stringArgs := []string{"settle", reference, string(movementsAsBytes)}
+	response := stub.InvokeChaincode(contract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+	if response.GetStatus() != shim.OK {
+		return errors.New("Unable to settle reserves at the central bank " + response.Message)
+	}
+
+	return nil
+}
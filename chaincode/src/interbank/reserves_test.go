@@ -0,0 +1,145 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"bank"
+	"centralbank"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestRTGSSettlement(t *testing.T) {
+	bankStub := testutil.NewMockStub("bank", new(bank.BankChaincode))
+	cbankStub := testutil.NewMockStub("centralbank", new(centralbank.CentralBankChaincode))
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.MockPeerChaincode("bank", bankStub)
+	ibankStub.MockPeerChaincode("centralbank", cbankStub)
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	bankStub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex"), []byte("ibank")})
+
+	for _, args := range [][]string{{"authorizeInterbankSender", "0002"}, {"createAccount", "Bob Jones", "1", "0", "USD"}} {
+		response := bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	cbankStub.Creator = testutil.NewIdentity("CentralMSP", "reserves")
+	cbankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	for _, args := range [][]string{{"openReserveAccount", "0001", "Org1MSP", "USD"}, {"openReserveAccount", "0002", "Org2MSP", "USD"},
+		{"fundReserve", "0002", "USD", "100"}, {"authorizeSettlementAgent", "GovMSP"}} {
+		response := cbankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	newTestBank(t, ibankStub, "bank2", "9", "1000", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "rtgs"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "rtgs set without a central bank")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "rtgs", "centralbank"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	pay := func(amount string) transferResult {
+		for _, stub := range []*testutil.MockStub{ibankStub, bankStub, cbankStub} {
+			stub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+		}
+
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", amount, "USD", "0002", "9"}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		result := transferResult{}
+		json.Unmarshal(response.GetPayload(), &result)
+		return result
+	}
+
+	reserves := func() []string {
+		response := cbankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listReserves"}))
+		accounts := []reserve{}
+		json.Unmarshal(response.GetPayload(), &accounts)
+
+		balances := []string{}
+		for _, account := range accounts {
+			balances = append(balances, account.Balance.String())
+		}
+		return balances
+	}
+
+	balance := func() string {
+		response := bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1"}))
+		credited := account{}
+		json.Unmarshal(response.GetPayload(), &credited)
+		return credited.Balance.String()
+	}
+
+	//the reserves move with the customer legs
+	settled := pay("60")
+	assert.Equal(t, statusSettled, settled.Status, "payment not settled")
+	assert.Equal(t, []string{"60", "40"}, reserves(), "reserves not moved")
+	assert.Equal(t, "60", balance(), "beneficiary not credited")
+
+	response = cbankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getSettlement", settled.ID}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//a payment the sending bank's reserves cannot cover is queued
+	queued := pay("50")
+	assert.Equal(t, statusQueued, queued.Status, "payment exceeding the reserves not queued")
+	assert.Equal(t, []string{"60", "40"}, reserves(), "reserves moved for a queued payment")
+	assert.Equal(t, "60", balance(), "queued payment credited")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", queued.ID}))
+	record := transferRecord{}
+	json.Unmarshal(response.GetPayload(), &record)
+	assert.Equal(t, "Bank 0002 has insufficient reserves in USD", record.Reason, "unexpected reason")
+
+	//once the bank's reserves are topped up the payment is released, settled by governance as a settlement agent
+	for _, stub := range []*testutil.MockStub{ibankStub, bankStub, cbankStub} {
+		stub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	}
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"resolveQueue"}))
+	resolution := queueResolution{}
+	json.Unmarshal(response.GetPayload(), &resolution)
+	assert.Equal(t, 0, len(resolution.Released), "payment released without reserves")
+
+	cbankStub.Creator = testutil.NewIdentity("CentralMSP", "reserves")
+	response = cbankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"fundReserve", "0002", "USD", "20"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	cbankStub.Creator = ibankStub.Creator
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"resolveQueue"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	json.Unmarshal(response.GetPayload(), &resolution)
+	assert.Equal(t, []string{queued.ID}, resolution.Released, "queued payment not released")
+	assert.Equal(t, []string{"110", "10"}, reserves(), "reserves not moved")
+	assert.Equal(t, "110", balance(), "queued payment not credited")
+}
@@ -0,0 +1,234 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// returnReject refuses a transfer to an invalid beneficiary, failing the transaction
+	returnReject = "reject"
+	// returnPayment accepts a transfer to an invalid beneficiary and returns the funds to the originator
+	returnPayment = "return"
+)
+
+// reasonIncorrectAccount is the ISO 20022 reason code for a beneficiary account that does not exist
+const reasonIncorrectAccount = "AC01"
+
+// returnPolicy is stored on the ledger under the key "returnPolicy"
+//Mode string - reject fails transfers to an invalid beneficiary, return accepts them and returns the funds
+type returnPolicy struct {
+	Mode string `json:"mode"`
+}
+
+// rejection is the error for a transfer the beneficiary bank will not accept, with an ISO 20022 reason code
+type rejection struct {
+	Code   string
+	Reason string
+}
+
+func (r *rejection) Error() string {
+	return "Rejected with reason code " + r.Code + ": " + r.Reason
+}
+
+// paymentReturn returns the funds of a transfer to the originator, mirroring a pacs.004 payment return. It is
+// stored under the composite key paymentReturn~TransferID
+//TransferID - the ID of the returned transfer
+//ReasonCode, Reason - the ISO 20022 reason code and why the beneficiary bank returned the transfer
+//Amount, Currency - the amount returned to the originator's account, in its currency
+type paymentReturn struct {
+	TransferID        string          `json:"transferID"`
+	OriginatorBank    string          `json:"originatorBank"`
+	OriginatorAccount string          `json:"originatorAccount"`
+	ReturningBank     string          `json:"returningBank"`
+	ReasonCode        string          `json:"reasonCode"`
+	Reason            string          `json:"reason"`
+	Amount            decimal.Decimal `json:"amount"`
+	Currency          string          `json:"currency"`
+	ReturnedAt        int64           `json:"returnedAt"`
+}
+
+//setReturnPolicy chooses what happens to transfers to an invalid beneficiary. Only callable by the governance MSP
+//Args:
+//	Mode string reject, to fail the transfer, or return, to accept it and return the funds to the originator
+func (s *InterbankChaincode) setReturnPolicy(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect arguments, expecting the return mode: reject or return")
+	}
+
+	if args[0] != returnReject && args[0] != returnPayment {
+		return shim.Error("Unknown return mode " + args[0] + ", expecting reject or return")
+	}
+
+	_, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policyBytes, _ := json.Marshal(returnPolicy{Mode: args[0]})
+	err = stub.PutState("returnPolicy", policyBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit return policy to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//getPaymentReturn returns the return of a transfer
+//Args
+//	ID	string	the ID of the returned transfer
+func (s *InterbankChaincode) getPaymentReturn(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: the transfer ID")
+	}
+
+	returnKey, err := stub.CreateCompositeKey("paymentReturn", []string{args[0]})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	returnAsBytes, err := stub.GetState(returnKey)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve payment return from ledger " + err.Error())
+	}
+
+	if returnAsBytes == nil {
+		return shim.Error("Interbank transfer " + args[0] + " has not been returned")
+	}
+
+	return shim.Success(returnAsBytes)
+}
+
+// getReturnPolicy returns the return policy on the ledger, defaulting to reject if none has been set
+func getReturnPolicy(stub shim.ChaincodeStubInterface) (*returnPolicy, error) {
+	policy := &returnPolicy{Mode: returnReject}
+
+	policyBytes, err := stub.GetState("returnPolicy")
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve return policy from ledger " + err.Error())
+	}
+
+	if policyBytes != nil {
+		err = json.Unmarshal(policyBytes, policy)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal return policy " + err.Error())
+		}
+	}
+
+	return policy, nil
+}
+
+// getBeneficiary returns the beneficiary's account from their bank, or a rejection if the account does not exist
+func getBeneficiary(stub shim.ChaincodeStubInterface, bankContract string, toBankID string, toAccNum string) (*account, error) {
+	stringArgs := []string{"queryAccount", toAccNum}
+	response := stub.InvokeChaincode(bankContract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+	if response.GetStatus() != shim.OK {
+		return nil, errors.New("Unable to retrieve to account from bank " + toBankID + " " + response.Message)
+	}
+
+	if len(response.GetPayload()) == 0 {
+		return nil, &rejection{Code: reasonIncorrectAccount, Reason: "Account " + toAccNum + " does not exist at bank " + toBankID}
+	}
+
+	toAccount := &account{}
+	err := json.Unmarshal(response.GetPayload(), toAccount)
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve to account from ledger " + err.Error())
+	}
+
+	return toAccount, nil
+}
+
+// checkOriginatorFunds returns an error unless the originator's account exists at the sending bank, holds the
+// transfer's currency and has the funds to pay it
+func checkOriginatorFunds(stub shim.ChaincodeStubInterface, bankContract string, fromBankID string, fromAccNum string, amount decimal.Decimal, currency string) error {
+	stringArgs := []string{"queryAccount", fromAccNum}
+	response := stub.InvokeChaincode(bankContract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+	if response.GetStatus() != shim.OK {
+		return errors.New("Unable to retrieve from account from bank " + fromBankID + " " + response.Message)
+	}
+
+	if len(response.GetPayload()) == 0 {
+		return errors.New("Account " + fromAccNum + " does not exist at bank " + fromBankID)
+	}
+
+	fromAccount := &account{}
+	err := json.Unmarshal(response.GetPayload(), fromAccount)
+
+	if err != nil {
+		return errors.New("Unable to retrieve from account from ledger " + err.Error())
+	}
+
+	if fromAccount.Currency != currency {
+		return errors.New("Account " + fromAccNum + " holds " + fromAccount.Currency + ", not " + currency)
+	}
+
+	if fromAccount.Balance.LessThan(amount) {
+		return errors.New("Account has insufficient funds")
+	}
+
+	return nil
+}
+
+// returnTransfer records a transfer the beneficiary bank has rejected as returned, with the return of its funds to
+// the originator. The originator is not debited and refunded: both would write the same account in one transaction,
+// and a chaincode does not read its own writes within a transaction, so the funds are left where they are once the
+// originator is known to be able to pay
+func returnTransfer(stub shim.ChaincodeStubInterface, record *transferRecord, rejected *rejection) error {
+	record.Status = statusReturned
+	record.ReasonCode = rejected.Code
+	record.Reason = rejected.Reason
+
+	err := putTransferRecord(stub, record, true)
+
+	if err != nil {
+		return err
+	}
+
+	returnKey, err := stub.CreateCompositeKey("paymentReturn", []string{record.ID})
+
+	if err != nil {
+		return err
+	}
+
+	returned := paymentReturn{TransferID: record.ID, OriginatorBank: record.OriginatorBank, OriginatorAccount: record.OriginatorAccount,
+		ReturningBank: record.BeneficiaryBank, ReasonCode: rejected.Code, Reason: rejected.Reason, Amount: record.Amount,
+		Currency: record.Currency, ReturnedAt: record.CreatedAt}
+	returnAsBytes, _ := json.Marshal(returned)
+	err = stub.PutState(returnKey, returnAsBytes)
+
+	if err != nil {
+		return errors.New("Unable to commit payment return to ledger " + err.Error())
+	}
+
+	return nil
+}
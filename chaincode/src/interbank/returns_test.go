@@ -0,0 +1,116 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"bank"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestPaymentReturn(t *testing.T) {
+	bankStub := testutil.NewMockStub("bank", new(bank.BankChaincode))
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.MockPeerChaincode("bank", bankStub)
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	bankStub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex"), []byte("ibank")})
+
+	bank2Stub := newTestBank(t, ibankStub, "bank2", "9", "1000", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	payFrom := func(amount string, fromAccNum string) sc.Response {
+		ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+		return ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "404", "0001", amount, "USD", "0002", fromAccNum}))
+	}
+
+	pay := func() sc.Response {
+		return payFrom("25", "9")
+	}
+
+	//by default a transfer to an account that does not exist is rejected
+	response := pay()
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "transfer to unknown account accepted")
+	assert.Equal(t, "Rejected with reason code AC01: Account 404 does not exist at bank 0001", response.Message, "unexpected error")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setReturnPolicy", "return"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//a transfer is only accepted for return if the originator could have paid it
+	invalid := []struct {
+		amount     string
+		fromAccNum string
+		expected   string
+	}{
+		{"-25", "9", "Amount to transfer must be a positive number"},
+		{"garbage", "9", "Unable to parse amount: garbage"},
+		{"25", "8", "Account 8 does not exist"},
+		{"5000", "9", "Account has insufficient funds"},
+	}
+
+	for _, transfer := range invalid {
+		response = payFrom(transfer.amount, transfer.fromAccNum)
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "invalid transfer returned")
+		assert.Contains(t, response.Message, transfer.expected, "unexpected error")
+	}
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listInterbankTransfersByBank", "0002"}))
+	assert.Equal(t, "[]", string(response.GetPayload()), "invalid transfer recorded")
+
+	//with returns accepted the transfer is recorded and returned to the originator
+	response = pay()
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	result := transferResult{}
+	json.Unmarshal(response.GetPayload(), &result)
+	assert.Equal(t, statusReturned, result.Status, "transfer not returned")
+	assert.Equal(t, reasonIncorrectAccount, result.ReasonCode, "incorrect reason code")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", result.ID}))
+	record := transferRecord{}
+	json.Unmarshal(response.GetPayload(), &record)
+	assert.Equal(t, statusReturned, record.Status, "record not returned")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getPaymentReturn", result.ID}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	returned := paymentReturn{}
+	json.Unmarshal(response.GetPayload(), &returned)
+	assert.Equal(t, "9", returned.OriginatorAccount, "funds returned to the wrong account")
+	assert.Equal(t, "25", returned.Amount.String(), "incorrect amount returned")
+	assert.Equal(t, "0001", returned.ReturningBank, "incorrect returning bank")
+
+	//neither transfer took the funds from the originator
+	response = bank2Stub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "9"}))
+	originator := account{}
+	json.Unmarshal(response.GetPayload(), &originator)
+	assert.Equal(t, "1000", originator.Balance.String(), "originator debited for a returned transfer")
+}
@@ -0,0 +1,104 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"bank"
+	"encoding/json"
+	"forex"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"rounding"
+	"testing"
+	"testutil"
+)
+
+func TestInterbankRounding(t *testing.T) {
+	forexStub := testutil.NewMockStub("forex", new(forex.ForexChaincode))
+	bankStub := testutil.NewMockStub("bank", new(bank.BankChaincode))
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.MockPeerChaincode("forex", forexStub)
+	ibankStub.MockPeerChaincode("bank", bankStub)
+
+	forexStub.Creator = testutil.NewIdentity("Org1MSP", "treasury")
+	forexStub.MockInit(uuid.New().String(), [][]byte{})
+	testutil.RegisterRateProvider(t, forexStub)
+
+	response := forexStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"createUpdateForexPair", "GBP", "USD", "1.2345"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	bankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = bankStub.MockInit(uuid.New().String(), [][]byte{[]byte("CloudBank"), []byte("0001"), []byte("forex"), []byte("ibank")})
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	for _, args := range [][]string{{"authorizeInterbankSender", "0002"}, {"createAccount", "Bob Jones", "1", "0", "USD"}} {
+		response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	newTestBank(t, ibankStub, "bank2", "9", "1000", "GBP")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	//only the governance MSP sets the rounding policy, and only to a known mode
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setRoundingPolicy", "truncate"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "rounding policy set outside the governance MSP")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setRoundingPolicy", "round-down"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "unknown rounding mode accepted")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setRoundingPolicy", "truncate"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//10.01 GBP at 1.2345 is 12.357345 USD, truncated to 12.35
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "10.01", "GBP", "0002", "9"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = bankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"queryAccount", "1"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	toAccount := &account{}
+	err := json.Unmarshal(response.GetPayload(), toAccount)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "12.35", toAccount.Balance.String(), "incorrect balance")
+
+	//the residual is booked under the same key as the bank contract's rounding accounts
+	roundingKey, _ := rounding.AccountKey(ibankStub, "USD")
+	roundingAccount := &rounding.Account{}
+	err = json.Unmarshal(ibankStub.State[roundingKey], roundingAccount)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "ROUNDING-USD", roundingAccount.AccNumber, "rounding account number mismatch")
+	assert.Equal(t, "USD", roundingAccount.Currency, "rounding account currency mismatch")
+	assert.Equal(t, "0.007345", roundingAccount.Balance.String(), "incorrect rounding residual")
+}
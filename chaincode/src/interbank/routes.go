@@ -0,0 +1,554 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+//registerRoute proposes a route to a bank, the route takes effect once accepted by the bank's MSP with acceptRoute.
+//Only callable by the governance MSP
+//Args
+//	ID            string 	the ID of the bank, analogue of SWIFT, IBAN, routing code, etc
+//	BankContract  string 	the name of the chaincode for the bank
+//	ForexContract string 	the name of the contract to provide forex services
+//	BankMSP       string 	the MSP ID of the bank's organization
+func (s *InterbankChaincode) registerRoute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 4 {
+		return shim.Error("Expecting 4 arguments: bank ID, name of BankChaincode, name of ForexContract, MSP ID of the bank")
+	}
+
+	proposer, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, err := getRoute(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if existing != nil {
+		return shim.Error("A route to bank " + args[0] + " is already registered, use updateRoute to change it")
+	}
+
+	err = putRouteProposal(stub, route{ID: args[0], BankContract: args[1], ForexContract: args[2], BankMSP: args[3], RegisteredBy: proposer.key()}, false, "")
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//updateRoute proposes new contracts for a registered route, the change takes effect once accepted by the bank's MSP
+//with acceptRoute. Moving the route to another MSP must be accepted by both the currently registered MSP and the
+//new MSP. The route keeps its suspension, as it stands when the change is accepted. Only callable by the governance MSP
+//Args
+//	ID            string 	the ID of the bank
+//	BankContract  string 	the name of the chaincode for the bank
+//	ForexContract string 	the name of the contract to provide forex services
+//	BankMSP       string 	optional, the MSP ID of the bank's organization. Defaults to the MSP that accepted the route
+func (s *InterbankChaincode) updateRoute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Expecting 3 or 4 arguments: bank ID, name of BankChaincode, name of ForexContract and optionally the MSP ID of the bank")
+	}
+
+	proposer, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, err := getKnownRoute(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	currentMSP := existing.BankMSP
+
+	existing.BankContract = args[1]
+	existing.ForexContract = args[2]
+	existing.RegisteredBy = proposer.key()
+	existing.ApprovedBy = ""
+
+	if len(args) == 4 {
+		existing.BankMSP = args[3]
+	}
+
+	if existing.BankMSP == "" {
+		return shim.Error("The route to bank " + args[0] + " has no bank MSP, give the MSP ID of the bank")
+	}
+
+	if currentMSP == existing.BankMSP {
+		currentMSP = ""
+	}
+
+	err = putRouteProposal(stub, *existing, true, currentMSP)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//acceptRoute accepts the proposed route to a bank, putting it into effect. Only callable by the bank's MSP. A
+//proposal moving the route to another MSP takes effect once accepted by both the current and the new MSP. A change
+//to a registered route keeps the route's current suspension, and is refused if the route has been removed
+//Args
+//	ID string 	the ID of the bank
+func (s *InterbankChaincode) acceptRoute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: bank ID")
+	}
+
+	proposal, proposalKey, err := getRouteProposal(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, err := getRoute(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if proposal.Update && existing == nil {
+		return shim.Error("The route to bank " + args[0] + " has been removed since the change was proposed")
+	}
+
+	if !proposal.Update && existing != nil {
+		return shim.Error("A route to bank " + args[0] + " is already registered, use updateRoute to change it")
+	}
+
+	approver, err := getCaller(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if approver.MSPID == proposal.CurrentMSP {
+		proposal.CurrentMSPApprovedBy = approver.key()
+	} else if approver.MSPID == proposal.Route.BankMSP {
+		proposal.Route.ApprovedBy = approver.key()
+	} else if proposal.CurrentMSP != "" {
+		return shim.Error("The route to bank " + args[0] + " must be accepted by members of " + proposal.CurrentMSP + " and " + proposal.Route.BankMSP + ", not " + approver.key())
+	} else {
+		return shim.Error("The route to bank " + args[0] + " must be accepted by a member of " + proposal.Route.BankMSP + ", not " + approver.key())
+	}
+
+	if proposal.Route.ApprovedBy == "" || (proposal.CurrentMSP != "" && proposal.CurrentMSPApprovedBy == "") {
+		//still awaiting the other MSP
+		proposalAsBytes, _ := json.Marshal(proposal)
+		err = stub.PutState(proposalKey, proposalAsBytes)
+
+		if err != nil {
+			return shim.Error("Unable to commit route proposal to ledger " + err.Error())
+		}
+
+		return shim.Success(proposalAsBytes)
+	}
+
+	//the route may have been suspended or resumed while the change awaited acceptance
+	if existing != nil {
+		proposal.Route.Suspended = existing.Suspended
+		proposal.Route.SuspendedReason = existing.SuspendedReason
+	}
+
+	err = putRoute(stub, &proposal.Route)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(proposalKey)
+
+	if err != nil {
+		return shim.Error("Unable to remove route proposal from ledger " + err.Error())
+	}
+
+	routeAsBytes, _ := json.Marshal(proposal.Route)
+	return shim.Success(routeAsBytes)
+}
+
+//rejectRoute discards the proposed route to a bank. Callable by the governance MSP, the bank's MSP or, for a
+//proposal moving the route to another MSP, the currently registered MSP
+//Args
+//	ID string 	the ID of the bank
+func (s *InterbankChaincode) rejectRoute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: bank ID")
+	}
+
+	proposal, proposalKey, err := getRouteProposal(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	invoker, err := getCaller(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if invoker.MSPID != proposal.Route.BankMSP && invoker.MSPID != proposal.CurrentMSP {
+		_, err = checkGovernance(stub)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = stub.DelState(proposalKey)
+
+	if err != nil {
+		return shim.Error("Unable to remove route proposal from ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//listRouteProposals returns the routes awaiting acceptance
+func (s *InterbankChaincode) listRouteProposals(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("routeProposal", []string{})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	proposals := []routeProposal{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		proposal := routeProposal{}
+		err = json.Unmarshal(queryResponse.Value, &proposal)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		proposals = append(proposals, proposal)
+	}
+
+	proposalsAsBytes, _ := json.Marshal(proposals)
+	return shim.Success(proposalsAsBytes)
+}
+
+//suspendRoute stops transfers being routed to a bank until the route is resumed, only callable by the governance MSP
+//Args
+//	ID     string 	the ID of the bank
+//	Reason string 	optional, why the route was suspended
+func (s *InterbankChaincode) suspendRoute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Expecting 1 or 2 arguments: bank ID and optionally the reason for the suspension")
+	}
+
+	_, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, err := getKnownRoute(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing.Suspended = true
+	existing.SuspendedReason = ""
+
+	if len(args) == 2 {
+		existing.SuspendedReason = args[1]
+	}
+
+	err = putRoute(stub, existing)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//resumeRoute lifts the suspension of a route, only callable by the governance MSP
+//Args
+//	ID string 	the ID of the bank
+func (s *InterbankChaincode) resumeRoute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: bank ID")
+	}
+
+	_, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, err := getKnownRoute(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing.Suspended = false
+	existing.SuspendedReason = ""
+
+	err = putRoute(stub, existing)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//removeRoute removes the route to a bank and discards any change proposed to it, only callable by the governance MSP
+//Args
+//	ID string 	the ID of the bank
+func (s *InterbankChaincode) removeRoute(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: bank ID")
+	}
+
+	_, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = getKnownRoute(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	routeKey, err := stub.CreateCompositeKey("route", []string{args[0]})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(routeKey)
+
+	if err != nil {
+		return shim.Error("Unable to remove route from ledger " + err.Error())
+	}
+
+	proposalKey, err := stub.CreateCompositeKey("routeProposal", []string{args[0]})
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.DelState(proposalKey)
+
+	if err != nil {
+		return shim.Error("Unable to remove route proposal from ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//getRouteByID returns the route to a bank
+//Args
+//	ID string 	the ID of the bank
+func (s *InterbankChaincode) getRouteByID(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: bank ID")
+	}
+
+	existing, err := getKnownRoute(stub, args[0])
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	routeAsBytes, _ := json.Marshal(existing)
+	return shim.Success(routeAsBytes)
+}
+
+//listRoutes returns every registered route, including suspended routes, ordered by bank ID
+func (s *InterbankChaincode) listRoutes(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	routes, err := getRoutes(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	routesAsBytes, _ := json.Marshal(routes)
+	return shim.Success(routesAsBytes)
+}
+
+// getRoutes reads every registered route from the ledger, ordered by bank ID
+func getRoutes(stub shim.ChaincodeStubInterface) ([]route, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("route", []string{})
+
+	if err != nil {
+		return nil, errors.New("Unable to query routes " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	routes := []route{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		bankRoute := route{}
+		err = json.Unmarshal(queryResponse.Value, &bankRoute)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal route " + err.Error())
+		}
+
+		routes = append(routes, bankRoute)
+	}
+
+	return routes, nil
+}
+
+// getRoute reads the route to a bank, stored under the composite key route~ID, returning nil if no route is registered
+func getRoute(stub shim.ChaincodeStubInterface, bankID string) (*route, error) {
+	routeKey, err := stub.CreateCompositeKey("route", []string{bankID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	routeAsBytes, err := stub.GetState(routeKey)
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve route from ledger " + err.Error())
+	}
+
+	if routeAsBytes == nil {
+		return nil, nil
+	}
+
+	bankRoute := &route{}
+	err = json.Unmarshal(routeAsBytes, bankRoute)
+
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal route " + err.Error())
+	}
+
+	return bankRoute, nil
+}
+
+// getKnownRoute reads the route to a bank from the ledger, returning an error if no route is registered
+func getKnownRoute(stub shim.ChaincodeStubInterface, bankID string) (*route, error) {
+	bankRoute, err := getRoute(stub, bankID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bankRoute == nil {
+		return nil, errors.New("Unknown bank " + bankID + ", no route is registered")
+	}
+
+	return bankRoute, nil
+}
+
+// getRouteProposal returns the proposed route to a bank and its key, or an error if there is no proposal
+func getRouteProposal(stub shim.ChaincodeStubInterface, bankID string) (*routeProposal, string, error) {
+	proposalKey, err := stub.CreateCompositeKey("routeProposal", []string{bankID})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	proposalAsBytes, err := stub.GetState(proposalKey)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to retrieve route proposal from ledger " + err.Error())
+	}
+
+	if proposalAsBytes == nil {
+		return nil, "", errors.New("No route to bank " + bankID + " has been proposed")
+	}
+
+	proposal := &routeProposal{}
+	err = json.Unmarshal(proposalAsBytes, proposal)
+
+	if err != nil {
+		return nil, "", errors.New("Unable to unmarshal route proposal " + err.Error())
+	}
+
+	return proposal, proposalKey, nil
+}
+
+// putRouteProposal stores a proposed route, update is true when it changes a registered route and currentMSP names the
+// MSP registered for the route when the proposal moves it to another MSP and is empty otherwise
+func putRouteProposal(stub shim.ChaincodeStubInterface, proposed route, update bool, currentMSP string) error {
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return errors.New("Unable to get transaction timestamp " + err.Error())
+	}
+
+	proposalKey, err := stub.CreateCompositeKey("routeProposal", []string{proposed.ID})
+
+	if err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(routeProposal{Route: proposed, ProposedAt: timestamp.GetSeconds(), Update: update, CurrentMSP: currentMSP})
+	err = stub.PutState(proposalKey, proposalAsBytes)
+
+	if err != nil {
+		return errors.New("Unable to commit route proposal to ledger " + err.Error())
+	}
+
+	return nil
+}
+
+func putRoute(stub shim.ChaincodeStubInterface, bankRoute *route) error {
+	routeKey, err := stub.CreateCompositeKey("route", []string{bankRoute.ID})
+
+	if err != nil {
+		return err
+	}
+
+	routeAsBytes, _ := json.Marshal(bankRoute)
+	err = stub.PutState(routeKey, routeAsBytes)
+
+	if err != nil {
+		return errors.New("Unable to commit route to ledger " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestRoutes(t *testing.T) {
+	ibankStub := testutil.NewMockStub("interbank", new(InterbankChaincode))
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex2", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setRoundingPolicy", "half-up"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"registerRoute", "0001", "other", "forex", "Org1MSP"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "existing route overwritten")
+
+	//a route suspended while a change awaits acceptance stays suspended once the change is accepted
+	for _, args := range [][]string{{"updateRoute", "0002", "bank2v2", "forex2"}, {"suspendRoute", "0002", "under investigation"}} {
+		response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"acceptRoute", "0002"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listRoutes"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	routes := []route{}
+	err := json.Unmarshal(response.GetPayload(), &routes)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, []route{{ID: "0001", BankContract: "bank", ForexContract: "forex", BankMSP: "Org1MSP", RegisteredBy: "GovMSP/governance", ApprovedBy: "Org1MSP/operations"},
+		{ID: "0002", BankContract: "bank2v2", ForexContract: "forex2", Suspended: true, SuspendedReason: "under investigation",
+			BankMSP: "Org2MSP", RegisteredBy: "GovMSP/governance", ApprovedBy: "Org2MSP/operations"}}, routes, "routes mismatch")
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0002", "10", "USD", "0001", "9"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "transfer routed to suspended bank")
+	assert.Equal(t, "Route to bank 0002 is suspended: under investigation", response.Message, "unexpected error")
+
+	//removing a route discards the change proposed to it, so accepting the change can not recreate the route
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	for _, args := range [][]string{{"updateRoute", "0002", "bank2v3", "forex2"}, {"removeRoute", "0002"}} {
+		response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listRouteProposals"}))
+	assert.Equal(t, "[]", string(response.GetPayload()), "proposal left for a removed route")
+
+	ibankStub.Creator = testutil.NewIdentity("Org2MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"acceptRoute", "0002"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "removed route recreated")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getRoute", "0002"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "removed route returned")
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "customer")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0002", "10", "USD", "0001", "9"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "transfer routed to unknown bank")
+	assert.Equal(t, "Unknown bank 0002, no route is registered", response.Message, "unexpected error")
+}
+
+func TestRouteAccessControl(t *testing.T) {
+	ibankStub := testutil.NewMockStub("interbank", new(InterbankChaincode))
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	//only the governance MSP can propose routes
+	ibankStub.Creator = testutil.NewIdentity("Org3MSP", "attacker")
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"registerRoute", "0001", "evil", "forex", "Org3MSP"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "route proposed outside the governance MSP")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"registerRoute", "0001", "bank", "forex", "Org1MSP"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	//a proposed route cannot be used, or accepted by anyone but the bank's MSP
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getRoute", "0001"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "route in effect before acceptance")
+
+	for _, creator := range [][]byte{testutil.NewIdentity("GovMSP", "governance"), testutil.NewIdentity("Org3MSP", "attacker")} {
+		ibankStub.Creator = creator
+		response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"acceptRoute", "0001"}))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "route accepted outside the bank's MSP")
+	}
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"rejectRoute", "0001"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"acceptRoute", "0001"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "rejected route accepted")
+
+	//moving a route to another MSP must be accepted by the currently registered MSP as well as the new one
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"updateRoute", "0001", "bank", "forex", "Org3MSP"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = testutil.NewIdentity("Org3MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"acceptRoute", "0001"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getRoute", "0001"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	bankRoute := route{}
+	err := json.Unmarshal(response.GetPayload(), &bankRoute)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "Org1MSP", bankRoute.BankMSP, "route moved without acceptance by the current MSP")
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"acceptRoute", "0001"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	err = json.Unmarshal(response.GetPayload(), &bankRoute)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "Org3MSP", bankRoute.BankMSP, "route not moved once accepted by both MSPs")
+	assert.Equal(t, "Org3MSP/operations", bankRoute.ApprovedBy, "incorrect approver")
+}
+
+func TestSenderAuthentication(t *testing.T) {
+	ibankStub := testutil.NewMockStub("interbank", new(InterbankChaincode))
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+
+	//a transfer claiming to come from a bank must be signed by a member of that bank's MSP
+	for _, args := range [][]string{{"interbankTransfer", "1", "0001", "10", "USD", "0001", "9"}, {"interbankTransfer", "1", "0001", "10", "USD", "0009", "9"}} {
+		ibankStub.Creator = testutil.NewIdentity("Org3MSP", "attacker")
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.ERROR, response.GetStatus(), "transfer accepted from unauthenticated sender")
+	}
+
+	ibankStub.Creator = testutil.NewIdentity("Org3MSP", "attacker")
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", "0001", "10", "USD", "0001", "9"}))
+	assert.Equal(t, "Org3MSP/attacker is not a member of Org1MSP, the MSP of bank 0001", response.Message, "unexpected error")
+}
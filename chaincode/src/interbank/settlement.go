@@ -0,0 +1,428 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strconv"
+)
+
+const (
+	settleGross    = "gross"
+	settleDeferred = "deferred"
+	settleRTGS     = "rtgs"
+)
+
+// settlementPolicy is stored on the ledger under the key "settlementPolicy"
+//Mode string - gross settles each payment as it is made, deferred accumulates bilateral obligations until the
+//		settlement cycle is closed and settles the net positions in reserves at the central bank, rtgs settles each
+//		payment by moving reserves at the central bank as it is made
+//CentralBankContract string - the CentralBankChaincode holding the banks' reserve accounts, used in deferred and rtgs
+//		mode
+type settlementPolicy struct {
+	Mode                string `json:"mode"`
+	CentralBankContract string `json:"centralBankContract,omitempty"`
+}
+
+// settlementCycle is the open cycle, stored on the ledger under the key "settlementCycle"
+type settlementCycle struct {
+	Cycle    int64 `json:"cycle"`
+	OpenedAt int64 `json:"openedAt"`
+}
+
+// netPosition is what a bank receives, when positive, or pays, when negative, to settle a cycle in a currency
+type netPosition struct {
+	BankID   string          `json:"bankID"`
+	Currency string          `json:"currency"`
+	Net      decimal.Decimal `json:"net"`
+}
+
+// settlementReport records a closed cycle, stored under the composite key settlementReport~Cycle
+//Obligations - the bilateral obligations accumulated during the cycle
+//Positions - the multilateral net position of each bank in each currency
+//Movements - the reserves moved at the central bank to settle the positions, under the reference cycle-Cycle
+type settlementReport struct {
+	Cycle       int64         `json:"cycle"`
+	OpenedAt    int64         `json:"openedAt"`
+	ClosedAt    int64         `json:"closedAt"`
+	ClosedBy    string        `json:"closedBy"`
+	Obligations []obligation  `json:"obligations"`
+	Positions   []netPosition `json:"positions"`
+	Movements   []movement    `json:"movements"`
+}
+
+//setSettlementMode chooses how interbank payments are settled. Deferred settlement cannot be left, or moved to
+//another central bank, until the obligations of the open cycle have been settled. Bilateral limits are not enforced
+//in rtgs mode, so they must be removed before moving to it. Only callable by the governance MSP
+//Args:
+//	Mode				string	gross, deferred or rtgs
+//	CentralBankContract	string	the name of the CentralBankChaincode holding the banks' reserves, required for
+//								deferred and rtgs
+func (s *InterbankChaincode) setSettlementMode(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect arguments, expecting the settlement mode: gross, deferred or rtgs, and the central bank contract for deferred and rtgs")
+	}
+
+	if args[0] != settleGross && args[0] != settleDeferred && args[0] != settleRTGS {
+		return shim.Error("Unknown settlement mode " + args[0] + ", expecting gross, deferred or rtgs")
+	}
+
+	policy := settlementPolicy{Mode: args[0]}
+	if args[0] != settleGross {
+		if len(args) != 2 || args[1] == "" {
+			return shim.Error(args[0] + " settlement requires the name of the central bank contract")
+		}
+
+		policy.CentralBankContract = args[1]
+	} else if len(args) == 2 {
+		return shim.Error("A central bank contract is only used for deferred and rtgs settlement")
+	}
+
+	_, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	current, err := getSettlementPolicy(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if current.Mode == settleDeferred && (policy.Mode != settleDeferred || policy.CentralBankContract != current.CentralBankContract) {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey("obligation", []string{})
+
+		if err != nil {
+			return shim.Error("Unable to query obligations " + err.Error())
+		}
+		defer resultsIterator.Close()
+
+		if resultsIterator.HasNext() {
+			return shim.Error("The open settlement cycle must be closed before leaving deferred settlement with " + current.CentralBankContract)
+		}
+	}
+
+	if policy.Mode == settleRTGS {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey("bilateralLimit", []string{})
+
+		if err != nil {
+			return shim.Error("Unable to query bilateral limits " + err.Error())
+		}
+		defer resultsIterator.Close()
+
+		if resultsIterator.HasNext() {
+			return shim.Error("Bilateral limits must be removed before moving to rtgs settlement, they are not enforced in rtgs mode")
+		}
+	}
+
+	policyBytes, _ := json.Marshal(policy)
+	err = stub.PutState("settlementPolicy", policyBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit settlement policy to ledger " + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+//closeSettlementCycle nets the obligations accumulated since the last cycle was closed into a position for each
+//bank and currency and settles the positions by moving reserves at the central bank, under the reference
+//cycle-Cycle. It then marks the cycle's transfers settled, removes the settled obligations from the bilateral
+//exposures, records the report and opens the next cycle. Only callable by the governance MSP, which the central bank
+//must authorize as a settlement agent
+func (s *InterbankChaincode) closeSettlementCycle(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	closer, err := checkGovernance(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cycle, err := getSettlementCycle(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	policy, err := getSettlementPolicy(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	report := settlementReport{Cycle: cycle.Cycle, OpenedAt: cycle.OpenedAt, ClosedAt: timestamp.GetSeconds(), ClosedBy: closer.key(),
+		Obligations: []obligation{}}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("obligation", []string{})
+
+	if err != nil {
+		return shim.Error("Unable to query obligations " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	positions := map[string]*netPosition{}
+	position := func(bankID string, currency string) *netPosition {
+		key := bankID + "~" + currency
+		if _, ok := positions[key]; !ok {
+			positions[key] = &netPosition{BankID: bankID, Currency: currency, Net: decimal.Zero}
+		}
+		return positions[key]
+	}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		owed := obligation{}
+		err = json.Unmarshal(queryResponse.Value, &owed)
+
+		if err != nil {
+			return shim.Error("Unable to unmarshal obligation " + err.Error())
+		}
+
+		report.Obligations = append(report.Obligations, owed)
+		position(owed.From, owed.Currency).Net = position(owed.From, owed.Currency).Net.Sub(owed.Amount)
+		position(owed.To, owed.Currency).Net = position(owed.To, owed.Currency).Net.Add(owed.Amount)
+
+		err = stub.DelState(queryResponse.Key)
+
+		if err != nil {
+			return shim.Error("Unable to delete settled obligation " + err.Error())
+		}
+	}
+
+	report.Positions = []netPosition{}
+	for _, net := range positions {
+		report.Positions = append(report.Positions, *net)
+	}
+
+	sort.Slice(report.Positions, func(i, j int) bool {
+		if report.Positions[i].BankID != report.Positions[j].BankID {
+			return report.Positions[i].BankID < report.Positions[j].BankID
+		}
+		return report.Positions[i].Currency < report.Positions[j].Currency
+	})
+
+	report.Movements = settlementMovements(report.Positions)
+
+	if len(report.Movements) > 0 {
+		err = moveReserves(stub, policy.CentralBankContract, "cycle-"+strconv.FormatInt(cycle.Cycle, 10), report.Movements)
+
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = settleTransferRecords(stub, cycle.Cycle, timestamp.GetSeconds())
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = releaseExposures(newLedgerBook(stub, "exposure"), report.Obligations)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reportKey, err := settlementReportKey(stub, cycle.Cycle)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reportAsBytes, _ := json.Marshal(report)
+	err = stub.PutState(reportKey, reportAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit settlement report to ledger " + err.Error())
+	}
+
+	cycleAsBytes, _ := json.Marshal(settlementCycle{Cycle: cycle.Cycle + 1, OpenedAt: timestamp.GetSeconds()})
+	err = stub.PutState("settlementCycle", cycleAsBytes)
+
+	if err != nil {
+		return shim.Error("Unable to commit settlement cycle to ledger " + err.Error())
+	}
+
+	return shim.Success(reportAsBytes)
+}
+
+//getSettlementReport returns the report of a closed settlement cycle
+//Args:
+//	Cycle string the number of the cycle
+func (s *InterbankChaincode) getSettlementReport(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Expecting 1 argument: the cycle number")
+	}
+
+	cycle, err := strconv.ParseInt(args[0], 10, 64)
+
+	if err != nil {
+		return shim.Error("Invalid cycle number " + err.Error())
+	}
+
+	reportKey, err := settlementReportKey(stub, cycle)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reportAsBytes, err := stub.GetState(reportKey)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve settlement report from ledger " + err.Error())
+	}
+
+	if reportAsBytes == nil {
+		return shim.Error("Settlement cycle " + args[0] + " has not been closed")
+	}
+
+	return shim.Success(reportAsBytes)
+}
+
+//getSettlementCycle returns the open settlement cycle, the settlement mode and the central bank contract
+func (s *InterbankChaincode) getSettlementCycle(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	cycle, err := getSettlementCycle(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policy, err := getSettlementPolicy(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cycleAsBytes, _ := json.Marshal(struct {
+		settlementCycle
+		Mode                string `json:"mode"`
+		CentralBankContract string `json:"centralBankContract,omitempty"`
+	}{*cycle, policy.Mode, policy.CentralBankContract})
+
+	return shim.Success(cycleAsBytes)
+}
+
+// settlementMovements works out the reserve movements that settle net positions. In each currency the banks with a
+// negative position pay those with a positive position, in bank ID order, so the movements are deterministic
+func settlementMovements(positions []netPosition) []movement {
+	movements := []movement{}
+	payers := map[string][]netPosition{}
+	receivers := map[string][]netPosition{}
+	currencies := []string{}
+
+	for _, position := range positions {
+		if _, found := payers[position.Currency]; !found {
+			currencies = append(currencies, position.Currency)
+			payers[position.Currency] = []netPosition{}
+		}
+
+		if position.Net.IsNegative() {
+			position.Net = position.Net.Neg()
+			payers[position.Currency] = append(payers[position.Currency], position)
+		} else if position.Net.IsPositive() {
+			receivers[position.Currency] = append(receivers[position.Currency], position)
+		}
+	}
+
+	sort.Strings(currencies)
+
+	for _, currency := range currencies {
+		paying, receiving := payers[currency], receivers[currency]
+
+		for i, j := 0, 0; i < len(paying) && j < len(receiving); {
+			amount := decimal.Min(paying[i].Net, receiving[j].Net)
+			movements = append(movements, movement{From: paying[i].BankID, To: receiving[j].BankID, Currency: currency, Amount: amount})
+
+			paying[i].Net = paying[i].Net.Sub(amount)
+			receiving[j].Net = receiving[j].Net.Sub(amount)
+
+			if paying[i].Net.IsZero() {
+				i++
+			}
+
+			if receiving[j].Net.IsZero() {
+				j++
+			}
+		}
+	}
+
+	return movements
+}
+
+// getSettlementPolicy returns the settlement policy on the ledger, defaulting to gross if none has been set
+func getSettlementPolicy(stub shim.ChaincodeStubInterface) (*settlementPolicy, error) {
+	policy := &settlementPolicy{Mode: settleGross}
+
+	policyBytes, err := stub.GetState("settlementPolicy")
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve settlement policy from ledger " + err.Error())
+	}
+
+	if policyBytes != nil {
+		err = json.Unmarshal(policyBytes, policy)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal settlement policy " + err.Error())
+		}
+	}
+
+	return policy, nil
+}
+
+// getSettlementCycle returns the open settlement cycle, the first cycle is open from the start of the ledger
+func getSettlementCycle(stub shim.ChaincodeStubInterface) (*settlementCycle, error) {
+	cycle := &settlementCycle{Cycle: 1}
+
+	cycleAsBytes, err := stub.GetState("settlementCycle")
+
+	if err != nil {
+		return nil, errors.New("Unable to retrieve settlement cycle from ledger " + err.Error())
+	}
+
+	if cycleAsBytes != nil {
+		err = json.Unmarshal(cycleAsBytes, cycle)
+
+		if err != nil {
+			return nil, errors.New("Unable to unmarshal settlement cycle " + err.Error())
+		}
+	}
+
+	return cycle, nil
+}
+
+// settlementReportKey pads the cycle number so reports are listed in cycle order
+func settlementReportKey(stub shim.ChaincodeStubInterface, cycle int64) (string, error) {
+	return stub.CreateCompositeKey("settlementReport", []string{fmt.Sprintf("%010d", cycle)})
+}
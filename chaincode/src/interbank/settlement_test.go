@@ -0,0 +1,142 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package interbank
+
+import (
+	"centralbank"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testutil"
+)
+
+func TestDeferredNetSettlement(t *testing.T) {
+	cbankStub := testutil.NewMockStub("centralbank", new(centralbank.CentralBankChaincode))
+	ibankStub := testutil.NewMockStub("ibank", new(InterbankChaincode))
+	ibankStub.MockPeerChaincode("centralbank", cbankStub)
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	ibankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	cbankStub.Creator = testutil.NewIdentity("CentralMSP", "reserves")
+	cbankStub.MockInit(uuid.New().String(), [][]byte{})
+
+	for _, args := range [][]string{{"openReserveAccount", "0001", "Org1MSP", "USD"}, {"openReserveAccount", "0002", "Org2MSP", "USD"},
+		{"fundReserve", "0002", "USD", "100"}, {"authorizeSettlementAgent", "GovMSP"}} {
+		response := cbankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs(args))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+	}
+
+	//each bank pays from and is paid into its account 1
+	newTestBank(t, ibankStub, "bank", "1", "1000", "USD")
+	newTestBank(t, ibankStub, "bank2", "1", "1000", "USD")
+
+	testutil.RegisterRoute(t, ibankStub, "0001", "bank", "forex", "Org1MSP")
+	testutil.RegisterRoute(t, ibankStub, "0002", "bank2", "forex", "Org2MSP")
+	testutil.SetCorrespondent(t, ibankStub, "0001", "0002", "0")
+	testutil.SetCorrespondent(t, ibankStub, "0002", "0001", "0")
+
+	pay := func(toBankID string, amount string, fromBankID string, mspID string) transferResult {
+		ibankStub.Creator = testutil.NewIdentity(mspID, "customer")
+
+		response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"interbankTransfer", "1", toBankID, amount, "USD", fromBankID, "1"}))
+		assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+		result := transferResult{}
+		json.Unmarshal(response.GetPayload(), &result)
+		return result
+	}
+
+	//gross payments leave nothing to settle
+	assert.EqualValues(t, 0, pay("0001", "10", "0002", "Org2MSP").Cycle, "gross payment deferred")
+
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getObligations"}))
+	assert.Equal(t, "[]", string(response.GetPayload()), "obligation recorded for gross payment")
+
+	ibankStub.Creator = testutil.NewIdentity("Org1MSP", "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "deferred", "centralbank"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "settlement mode set outside governance")
+
+	//net positions are settled in reserves at the central bank
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "deferred"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "deferred settlement set without a central bank")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "deferred", "centralbank"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	deferred := pay("0001", "100", "0002", "Org2MSP")
+	assert.EqualValues(t, 1, deferred.Cycle, "payment not deferred")
+	pay("0001", "25", "0002", "Org2MSP")
+	pay("0002", "30", "0001", "Org1MSP")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", deferred.ID}))
+	record := transferRecord{}
+	json.Unmarshal(response.GetPayload(), &record)
+	assert.Equal(t, statusAccepted, record.Status, "deferred transfer settled before the cycle closed")
+
+	//deferred settlement is not left with obligations outstanding
+	ibankStub.Creator = testutil.NewIdentity("GovMSP", "governance")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setSettlementMode", "gross"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "deferred settlement left with obligations outstanding")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"closeSettlementCycle"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	report := settlementReport{}
+	err := json.Unmarshal(response.GetPayload(), &report)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.EqualValues(t, 1, report.Cycle, "incorrect cycle")
+	assert.Equal(t, 2, len(report.Obligations), "incorrect obligations")
+	assert.Equal(t, 2, len(report.Positions), "incorrect positions")
+	assert.Equal(t, "0001", report.Positions[0].BankID, "positions not ordered by bank")
+	assert.Equal(t, "95", report.Positions[0].Net.String(), "incorrect net position")
+	assert.Equal(t, "-95", report.Positions[1].Net.String(), "incorrect net position")
+	assert.Equal(t, []movement{{From: "0002", To: "0001", Currency: "USD", Amount: report.Positions[0].Net}}, report.Movements, "incorrect movements")
+
+	response = cbankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"listReserves"}))
+	reserves := []reserve{}
+	json.Unmarshal(response.GetPayload(), &reserves)
+	if assert.Equal(t, 2, len(reserves), "incorrect reserve accounts") {
+		assert.Equal(t, "95", reserves[0].Balance.String(), "net position not received")
+		assert.Equal(t, "5", reserves[1].Balance.String(), "net position not paid")
+	}
+
+	response = cbankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getSettlement", "cycle-1"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getObligations"}))
+	assert.Equal(t, "[]", string(response.GetPayload()), "settled obligations not cleared")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getInterbankTransfer", deferred.ID}))
+	record = transferRecord{}
+	json.Unmarshal(response.GetPayload(), &record)
+	assert.Equal(t, statusSettled, record.Status, "deferred transfer not settled when the cycle closed")
+
+	assert.EqualValues(t, 2, pay("0002", "5", "0001", "Org1MSP").Cycle, "next cycle not opened")
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getSettlementReport", "1"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"getSettlementReport", "2"}))
+	assert.EqualValues(t, shim.ERROR, response.GetStatus(), "report returned for open cycle")
+}
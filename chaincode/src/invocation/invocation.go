@@ -0,0 +1,74 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+
+// Package invocation identifies how a transaction reached a chaincode, so that a contract can tell whether it was
+// called through another contract
+package invocation
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/common"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// TopLevelChaincode returns the name of the chaincode invoked by the transaction's signed proposal. Fabric does not
+// expose which chaincode made a chaincode to chaincode call, but every chaincode it calls sees the same proposal. The
+// name is read from the chaincode header extension, which is the chaincode the endorser actually runs. The invocation
+// spec in the proposal payload is written by the client and is not checked against it, so it can not be trusted
+func TopLevelChaincode(stub shim.ChaincodeStubInterface) (string, error) {
+	signedProposal, err := stub.GetSignedProposal()
+
+	if err != nil || signedProposal == nil {
+		return "", errors.New("Unable to retrieve the signed proposal")
+	}
+
+	proposal := &sc.Proposal{}
+	err = proto.Unmarshal(signedProposal.ProposalBytes, proposal)
+
+	if err != nil {
+		return "", errors.New("Unable to unmarshal proposal " + err.Error())
+	}
+
+	header := &common.Header{}
+	err = proto.Unmarshal(proposal.Header, header)
+
+	if err != nil {
+		return "", errors.New("Unable to unmarshal proposal header " + err.Error())
+	}
+
+	channelHeader := &common.ChannelHeader{}
+	err = proto.Unmarshal(header.ChannelHeader, channelHeader)
+
+	if err != nil {
+		return "", errors.New("Unable to unmarshal channel header " + err.Error())
+	}
+
+	extension := &sc.ChaincodeHeaderExtension{}
+	err = proto.Unmarshal(channelHeader.Extension, extension)
+
+	if err != nil {
+		return "", errors.New("Unable to unmarshal chaincode header extension " + err.Error())
+	}
+
+	if extension.ChaincodeId == nil || extension.ChaincodeId.Name == "" {
+		return "", errors.New("The proposal does not name a chaincode")
+	}
+
+	return extension.ChaincodeId.Name, nil
+}
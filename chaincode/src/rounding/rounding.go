@@ -0,0 +1,161 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+// Package rounding holds the currency precisions and rounding modes shared by the bank and interbank contracts
+package rounding
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	HalfEven = "half-even"
+	HalfUp   = "half-up"
+	Truncate = "truncate"
+)
+
+// AccountType is the type of the accounts holding the rounding residuals for a currency. They are stored like the
+// bank contract's other internal accounts, under the composite key internalAccount~ROUNDING~Currency, and numbered
+// by currency code, e.g. ROUNDING-USD
+const AccountType = "ROUNDING"
+
+// Account is a rounding account, in the form of a bank account
+type Account struct {
+	Name      string          `json:"name"`
+	AccNumber string          `json:"id"`
+	Balance   decimal.Decimal `json:"balance"`
+	Currency  string          `json:"currency"`
+}
+
+// Policy is stored on the ledger under the key "roundingPolicy"
+//Mode string - the rounding mode applied to converted amounts: half-even, half-up or truncate
+type Policy struct {
+	Mode string `json:"mode"`
+}
+
+// currencyPrecision lists the currencies whose minor unit is not two decimal places
+var currencyPrecision = map[string]int32{
+	"BHD": 3, "CLP": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0,
+}
+
+// Precision returns the number of decimal places used by a currency
+func Precision(currency string) int32 {
+	if places, ok := currencyPrecision[currency]; ok {
+		return places
+	}
+	return 2
+}
+
+// Round rounds an amount to the precision of the given currency using the rounding mode
+func Round(amount decimal.Decimal, currency string, mode string) decimal.Decimal {
+	places := Precision(currency)
+
+	switch mode {
+	case HalfUp:
+		return amount.Round(places)
+	case Truncate:
+		return amount.Truncate(places)
+	default:
+		return amount.RoundBank(places)
+	}
+}
+
+// PutPolicy validates a rounding mode and stores it as the rounding policy. Callers check the invoker is allowed
+// to change the policy
+func PutPolicy(stub shim.ChaincodeStubInterface, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Incorrect arguments, expecting the rounding mode: half-even, half-up or truncate")
+	}
+
+	if args[0] != HalfEven && args[0] != HalfUp && args[0] != Truncate {
+		return errors.New("Unknown rounding mode " + args[0] + ", expecting half-even, half-up or truncate")
+	}
+
+	policyBytes, _ := json.Marshal(Policy{Mode: args[0]})
+	err := stub.PutState("roundingPolicy", policyBytes)
+
+	if err != nil {
+		return errors.New("Unable to commit rounding policy to ledger " + err.Error())
+	}
+
+	return nil
+}
+
+// GetPolicy returns the rounding policy on the ledger, defaulting to half-even if none has been set
+func GetPolicy(stub shim.ChaincodeStubInterface) (*Policy, error) {
+	policy := &Policy{Mode: HalfEven}
+
+	policyBytes, err := stub.GetState("roundingPolicy")
+	if err != nil {
+		return nil, errors.New("Unable to retrieve rounding policy from ledger " + err.Error())
+	}
+
+	if policyBytes == nil {
+		return policy, nil
+	}
+
+	err = json.Unmarshal(policyBytes, policy)
+	if err != nil {
+		return nil, errors.New("Unable to unmarshal rounding policy " + err.Error())
+	}
+
+	return policy, nil
+}
+
+// AccountKey returns the key of the rounding account for a currency
+func AccountKey(stub shim.ChaincodeStubInterface, currency string) (string, error) {
+	return stub.CreateCompositeKey("internalAccount", []string{AccountType, currency})
+}
+
+// BookResidual adds the difference between an exact converted amount and the amount actually credited to the
+// rounding account for the currency, creating the account if it does not yet exist
+func BookResidual(stub shim.ChaincodeStubInterface, currency string, residual decimal.Decimal) error {
+	if residual.IsZero() {
+		return nil
+	}
+
+	key, err := AccountKey(stub, currency)
+	if err != nil {
+		return err
+	}
+
+	accountAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Unable to retrieve rounding account from ledger " + err.Error())
+	}
+
+	acc := &Account{Name: "Rounding " + currency, AccNumber: AccountType + "-" + currency, Balance: decimal.Zero, Currency: currency}
+	if accountAsBytes != nil {
+		err = json.Unmarshal(accountAsBytes, acc)
+		if err != nil {
+			return errors.New("Unable to unmarshal rounding account " + err.Error())
+		}
+	}
+
+	acc.Balance = acc.Balance.Add(residual)
+
+	accAsBytes, _ := json.Marshal(acc)
+	err = stub.PutState(key, accAsBytes)
+	if err != nil {
+		return errors.New("Error trying to commit rounding account to ledger " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package rounding
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRound(t *testing.T) {
+	amount, _ := decimal.NewFromString("12.345")
+
+	assert.Equal(t, "12.34", Round(amount, "USD", HalfEven).String(), "half-even mismatch")
+	assert.Equal(t, "12.35", Round(amount, "USD", HalfUp).String(), "half-up mismatch")
+	assert.Equal(t, "12.34", Round(amount, "USD", Truncate).String(), "truncate mismatch")
+	assert.Equal(t, "12", Round(amount, "JPY", HalfUp).String(), "JPY precision mismatch")
+	assert.Equal(t, "12.345", Round(amount, "KWD", HalfUp).String(), "KWD precision mismatch")
+}
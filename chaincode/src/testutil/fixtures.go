@@ -0,0 +1,59 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package testutil
+
+import (
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// RegisterRateProvider registers the forex stub's creator, Org1MSP/treasury, as a rate provider. The stub must
+// have been initialized by that creator, making Org1MSP the administrator
+func RegisterRateProvider(t *testing.T, forexStub *MockStub) {
+	response := forexStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"registerRateProvider", "Org1MSP", "treasury"}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+}
+
+// RegisterRoute proposes a route as GovMSP/governance and accepts it as a member of the bank's MSP. The interbank
+// stub must have been initialized by a member of GovMSP, making it the governance MSP
+func RegisterRoute(t *testing.T, ibankStub *MockStub, bankID string, bankContract string, forexContract string, bankMSP string) {
+	creator := ibankStub.Creator
+
+	ibankStub.Creator = NewIdentity("GovMSP", "governance")
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"registerRoute", bankID, bankContract, forexContract, bankMSP}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = NewIdentity(bankMSP, "operations")
+	response = ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"acceptRoute", bankID}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = creator
+}
+
+// SetCorrespondent lets a bank receive payments from another as GovMSP/governance, restoring the stub's creator
+func SetCorrespondent(t *testing.T, ibankStub *MockStub, fromBankID string, toBankID string, fee string) {
+	creator := ibankStub.Creator
+
+	ibankStub.Creator = NewIdentity("GovMSP", "governance")
+	response := ibankStub.MockInvoke(uuid.New().String(), util.ArrayToChaincodeArgs([]string{"setCorrespondent", fromBankID, toBankID, fee}))
+	assert.EqualValues(t, shim.OK, response.GetStatus(), response.Message)
+
+	ibankStub.Creator = creator
+}
@@ -0,0 +1,160 @@
+/*
+# Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License").
+# You may not use this file except in compliance with the License.
+# A copy of the License is located at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# or in the "license" file accompanying this file. This file is distributed
+# on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+# express or implied. See the License for the specific language governing
+# permissions and limitations under the License.
+#
+*/
+
+package testutil
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// MockStub wraps shim.MockStub so that tests can set the identity returned by GetCreator, which the Fabric mock
+// leaves empty. Peer chaincodes registered with MockPeerChaincode are invoked through their own MockStub, so that
+// they see the same creator and the signed proposal of the top level chaincode, as they would on a peer
+type MockStub struct {
+	*shim.MockStub
+	Creator  []byte
+	cc       shim.Chaincode
+	args     [][]byte
+	proposal *pb.SignedProposal
+	peers    map[string]*MockStub
+}
+
+// NewMockStub returns a MockStub for the chaincode, with no creator
+func NewMockStub(name string, cc shim.Chaincode) *MockStub {
+	return &MockStub{MockStub: shim.NewMockStub(name, cc), cc: cc, peers: make(map[string]*MockStub)}
+}
+
+// MockPeerChaincode registers a peer chaincode that can be invoked with InvokeChaincode
+func (stub *MockStub) MockPeerChaincode(name string, peer *MockStub) {
+	stub.peers[name] = peer
+}
+
+// MockInit initializes the chaincode as the top level chaincode of a transaction
+func (stub *MockStub) MockInit(uuid string, args [][]byte) pb.Response {
+	return stub.execute(uuid, args, NewProposal(stub.Name, args), stub.cc.Init)
+}
+
+// MockInvoke invokes the chaincode as the top level chaincode of a transaction
+func (stub *MockStub) MockInvoke(uuid string, args [][]byte) pb.Response {
+	return stub.execute(uuid, args, NewProposal(stub.Name, args), stub.cc.Invoke)
+}
+
+// MockInvokeWithProposal invokes the chaincode as the top level chaincode of a transaction with the given proposal
+func (stub *MockStub) MockInvokeWithProposal(uuid string, args [][]byte, proposal *pb.SignedProposal) pb.Response {
+	return stub.execute(uuid, args, proposal, stub.cc.Invoke)
+}
+
+// InvokeChaincode invokes a peer chaincode in the same transaction, with the same creator and signed proposal
+func (stub *MockStub) InvokeChaincode(name string, args [][]byte, channel string) pb.Response {
+	if channel != "" {
+		name = name + "/" + channel
+	}
+
+	peer, ok := stub.peers[name]
+	if !ok {
+		return shim.Error("Chaincode " + name + " is not registered")
+	}
+
+	peer.Creator = stub.Creator
+	return peer.execute(stub.TxID, args, stub.proposal, peer.cc.Invoke)
+}
+
+func (stub *MockStub) execute(uuid string, args [][]byte, proposal *pb.SignedProposal, call func(shim.ChaincodeStubInterface) pb.Response) pb.Response {
+	stub.args = args
+	stub.MockTransactionStart(uuid)
+	stub.proposal = proposal
+
+	response := call(stub)
+
+	stub.MockTransactionEnd(uuid)
+	stub.proposal = nil
+	return response
+}
+
+func (stub *MockStub) GetCreator() ([]byte, error) {
+	return stub.Creator, nil
+}
+
+func (stub *MockStub) GetSignedProposal() (*pb.SignedProposal, error) {
+	return stub.proposal, nil
+}
+
+func (stub *MockStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *MockStub) GetStringArgs() []string {
+	args := make([]string, 0, len(stub.args))
+	for _, arg := range stub.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (stub *MockStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+// NewProposal returns a signed proposal invoking the named chaincode, without a signature
+func NewProposal(name string, args [][]byte) *pb.SignedProposal {
+	return NewForgedProposal(name, name, args)
+}
+
+// NewForgedProposal returns a signed proposal whose header runs the named chaincode, while the invocation spec a
+// client writes names another, without a signature
+func NewForgedProposal(name string, specName string, args [][]byte) *pb.SignedProposal {
+	extension, err := proto.Marshal(&pb.ChaincodeHeaderExtension{ChaincodeId: &pb.ChaincodeID{Name: name}})
+	if err != nil {
+		panic(err)
+	}
+
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), Extension: extension})
+	if err != nil {
+		panic(err)
+	}
+
+	header, err := proto.Marshal(&common.Header{ChannelHeader: channelHeader})
+	if err != nil {
+		panic(err)
+	}
+
+	spec := &pb.ChaincodeInvocationSpec{ChaincodeSpec: &pb.ChaincodeSpec{ChaincodeId: &pb.ChaincodeID{Name: specName},
+		Input: &pb.ChaincodeInput{Args: args}}}
+
+	input, err := proto.Marshal(spec)
+	if err != nil {
+		panic(err)
+	}
+
+	payload, err := proto.Marshal(&pb.ChaincodeProposalPayload{Input: input})
+	if err != nil {
+		panic(err)
+	}
+
+	proposal, err := proto.Marshal(&pb.Proposal{Header: header, Payload: payload})
+	if err != nil {
+		panic(err)
+	}
+
+	return &pb.SignedProposal{ProposalBytes: proposal}
+}
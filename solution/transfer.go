@@ -7,6 +7,7 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
 	"github.com/shopspring/decimal"
+	"rounding"
 )
 
 type transferEvent struct {
@@ -15,17 +16,24 @@ type transferEvent struct {
 	ToAccNumber   string `json:"ToAccNumber"`
 	ToBankID      string `json:"ToBankID"`
 	Amount        string `json:"Amount"`
+	Rate          string `json:"Rate,omitempty"`
+	Markup        string `json:"Markup,omitempty"`
 }
 
 // Transfer funds from one account to another given four arguments: Payers account Id, Payees bank,
 // Payees account Id, and the amount to transfer. The amount must be a positive number.
-// If the payer and payee accounts belong to the same bank this will perform an intrabank transfer
-// otherwise this will initiate an interbank transfer
+// The payer and payee accounts must belong to this bank. Payments to another bank are made with the interbank
+// contract's interbankTransfer, which takes the funds from the payer with interbankDebit
 // Transferring between accounts at the same bank, but with different currencies requires a Forex contract
-// params: fromAccount, toBank, toAccount, amount
+// An optional fifth argument, the ID of a quote created with the Forex contract's createQuote, converts at
+// the quoted rate instead of the current rate. The quote can only be used once and must not have expired.
+// Conversions use the forex bid rate less the markup for the payer's customer segment, the markup is booked to
+// the bank's FX income account for the payee's currency. A quoted rate is the rate offered to the payer, so it is
+// used as it stands. A record of the transfer, with the rate and markup, is kept under the transaction ID.
+// params: fromAccount, toBank, toAccount, amount, quoteID
 func (s *BankChaincode) transfer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	if len(args) != 4 {
-		return shim.Error("Incorret number of args. Expecting 4: fromAccount, toBank, toAccount, amount")
+	if len(args) != 4 && len(args) != 5 {
+		return shim.Error("Incorret number of args. Expecting 4 or 5: fromAccount, toBank, toAccount, amount and optionally a quote ID")
 	}
 
 	//sorting arguments
@@ -35,6 +43,11 @@ func (s *BankChaincode) transfer(stub shim.ChaincodeStubInterface, args []string
 	amountAsString := args[3]
 	amount, err := decimal.NewFromString(args[3])
 
+	quoteID := ""
+	if len(args) == 5 {
+		quoteID = args[4]
+	}
+
 	//validate amount is actually a number
 	if err != nil {
 		return shim.Error("Unable to parse amount: " + amountAsString)
@@ -73,34 +86,7 @@ func (s *BankChaincode) transfer(stub shim.ChaincodeStubInterface, args []string
 
 	// is this an interbank transfer? check if recipient bank is not this bank
 	if toBankID != thisBank.ID {
-		// initiate interbank transfer
-
-		if thisBank.InterbankContract == "" {
-			return shim.Error("Unable to perform interbank transfer - no interbankchaincode provided")
-		}
-
-		stringArgs := []string{"interbankTransfer", toAccNum, toBankID, amountAsString, fromAccount.Currency}
-
-		response := stub.InvokeChaincode(thisBank.InterbankContract, util.ArrayToChaincodeArgs(stringArgs), "")
-
-		if response.Status != shim.OK {
-			return shim.Error("Unable to invoke interbank transfer contract" + err.Error())
-		}
-
-		fromAccount.Balance = fromAccount.Balance.Sub(amount)
-
-		fromAccountAsByes, _ = json.Marshal(fromAccount)
-		err = stub.PutState(fromAccNum, fromAccountAsByes)
-		if err != nil {
-			return shim.Error("Error trying to commit account to ledger" + err.Error())
-		}
-
-		//write out an event of the transfer
-		event := &transferEvent{FromAccNumber: fromAccount.AccNumber, FromBankID: thisBank.ID, ToBankID: toBankID, ToAccNumber: toAccNum, Amount: amount.String()}
-		eventBytes, _ := json.Marshal(event)
-		stub.SetEvent("transfer-event", eventBytes)
-
-		return (shim.Success(nil))
+		return shim.Error("Payments to bank " + toBankID + " must be made with interbankTransfer on the interbank contract")
 	}
 
 	//if not inter bank transfer, perform an intra bank transfer
@@ -115,21 +101,58 @@ func (s *BankChaincode) transfer(stub shim.ChaincodeStubInterface, args []string
 	//check if from and to accounts use the same currency
 	var exchangeRate decimal.Decimal
 	if fromAccount.Currency == toAccount.Currency {
+		if quoteID != "" {
+			return shim.Error("A quote can only be used to transfer between accounts with different currencies")
+		}
+
 		exchangeRate = decimal.NewFromFloat(1.0)
+	} else if quoteID != "" {
+		// invoke the Forex chaincode to use the locked rate of the quote
+		rate, err := getQuotedConversion(stub, thisBank.ForexContract, quoteID, fromAccount.Currency, toAccount.Currency, thisBank.ID, fromAccNum, amount)
+
+		if err != nil {
+			return shim.Error("Unable to perform currency conversion:" + err.Error())
+		}
+
+		exchangeRate = rate
 	} else {
 		// call handler function to invoke Forex chaincode
-		exchangeRateAsFloat, err := getCurrencyConversion(stub, thisBank.ForexContract, fromAccount.Currency, toAccount.Currency)
+		rate, err := getCurrencyConversion(stub, thisBank.ForexContract, fromAccount.Currency, toAccount.Currency)
 
 		if err != nil {
 			return shim.Error("Unable to perform currency conversion:" + err.Error())
 		}
 
-		exchangeRate = decimal.NewFromFloat(exchangeRateAsFloat)
+		exchangeRate = rate
+	}
+
+	//apply the payer's markup to the current rate, a quoted rate already is the payer's rate, and round the converted
+	//amount to the precision of the recipient currency
+	customerRate := exchangeRate
+
+	if fromAccount.Currency != toAccount.Currency && quoteID == "" {
+		customerRate, err = applyMarkup(stub, exchangeRate, segmentOf(fromAccount))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	convertedAmount := amount.Mul(customerRate)
+	markupAmount := amount.Mul(exchangeRate).Sub(convertedAmount)
+	creditAmount := convertedAmount
+
+	if fromAccount.Currency != toAccount.Currency {
+		policy, err := rounding.GetPolicy(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		creditAmount = rounding.Round(convertedAmount, toAccount.Currency, policy.Mode)
 	}
 
 	//update balances
 	fromAccount.Balance = fromAccount.Balance.Sub(amount)
-	toAccount.Balance = toAccount.Balance.Add(amount.Mul(exchangeRate))
+	toAccount.Balance = toAccount.Balance.Add(creditAmount)
 
 	// write changes to ledger
 	fromAccountAsByes, _ = json.Marshal(fromAccount)
@@ -144,36 +167,190 @@ func (s *BankChaincode) transfer(stub shim.ChaincodeStubInterface, args []string
 		return shim.Error("Error trying to commit account to ledger" + err.Error())
 	}
 
+	err = s.bookRoundingResidual(stub, toAccount.Currency, convertedAmount.Sub(creditAmount))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = s.bookFXIncome(stub, toAccount.Currency, markupAmount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("Unable to get transaction timestamp " + err.Error())
+	}
+
+	record := &transferRecord{ID: stub.GetTxID(), FromAccNumber: fromAccNum, ToAccNumber: toAccNum, Amount: amount, Currency: fromAccount.Currency,
+		Credited: creditAmount, CreditedCurrency: toAccount.Currency, Rate: customerRate, Markup: markupAmount, QuoteID: quoteID,
+		CreatedAt: timestamp.GetSeconds()}
+
+	err = putTransferRecord(stub, record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	//write out an event of the transfer
 	event := &transferEvent{FromAccNumber: fromAccount.AccNumber, FromBankID: thisBank.ID, ToBankID: toBankID, ToAccNumber: toAccNum, Amount: amount.String()}
+
+	if fromAccount.Currency != toAccount.Currency {
+		event.Rate = customerRate.String()
+		event.Markup = markupAmount.String()
+	}
+
 	eventBytes, _ := json.Marshal(event)
 	stub.SetEvent("transfer-event", eventBytes)
 	return (shim.Success(nil))
 }
 
-// }
+// interbankDebit takes the funds for an interbank transfer from the payer's account. It is invoked by the interbank
+// contract once it has accepted the transfer, in the same transaction. The funds are owed to the first bank on the
+// payment's path, which may pass them on to further banks, so they are credited to that bank's vostro account. The transaction must have been submitted to this bank's
+// interbank contract and signed by a member of the bank's administrator MSP, the interbank contract checks that it
+// is the MSP registered for this bank's route
+//Args:
+//	fromAccount	string	the account to debit
+//	amount		string	the amount to debit
+//	currency	string	the currency of the amount, which must be the currency of the account
+//	toBankID	string	the ID of the first bank on the payment's path, which the funds are paid to
+func (s *BankChaincode) interbankDebit(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of args. Expecting 4: fromAccount, amount, currency, toBank")
+	}
+
+	bankAsBytes, err := stub.GetState("bank")
+
+	if err != nil {
+		return shim.Error("Unable to retrieve bank from ledger " + err.Error())
+	}
+
+	thisBank := &bank{}
+	err = json.Unmarshal(bankAsBytes, thisBank)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve bank from ledger " + err.Error())
+	}
+
+	err = checkInterbankContract(stub, thisBank.InterbankContract)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkAdmin(stub)
+
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	amount, err := decimal.NewFromString(args[1])
+
+	if err != nil {
+		return shim.Error("Unable to parse amount: " + args[1])
+	}
 
-func getCurrencyConversion(stub shim.ChaincodeStubInterface, forexContract string, baseCurrency string, counterCurrency string) (float64, error) {
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return shim.Error("Amount to debit must be a positive number")
+	}
+
+	//get the fromAccount
+	fromAccountAsByes := s.queryAccount(stub, []string{args[0]}).Payload
+	fromAccount := &account{}
+	err = json.Unmarshal(fromAccountAsByes, fromAccount)
+
+	if err != nil {
+		return shim.Error("Unable to retrieve from account from ledger " + err.Error())
+	}
+
+	if fromAccount.Currency != args[2] {
+		return shim.Error("Account " + args[0] + " holds " + fromAccount.Currency + ", not " + args[2])
+	}
+
+	// check if funds are available
+	if fromAccount.Balance.Cmp(amount) == -1 {
+		return shim.Error("Account has insufficient funds")
+	}
+
+	fromAccount.Balance = fromAccount.Balance.Sub(amount)
+
+	fromAccountAsByes, _ = json.Marshal(fromAccount)
+	err = stub.PutState(fromAccount.AccNumber, fromAccountAsByes)
+	if err != nil {
+		return shim.Error("Error trying to commit account to ledger" + err.Error())
+	}
+
+	//the payment is owed to the receiving bank, credit its vostro account
+	err = postToVostro(stub, args[3], fromAccount.Currency, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func getCurrencyConversion(stub shim.ChaincodeStubInterface, forexContract string, baseCurrency string, counterCurrency string) (decimal.Decimal, error) {
 
 	if forexContract == "" {
-		return 0.0, errors.New("Forex contract is empty, unable to complete transaction")
+		return decimal.Zero, errors.New("Forex contract is empty, unable to complete transaction")
 	}
 
-	// invoke the forex contract to get the exchange rate for the pair
-	stringArgs := []string{"getForexPair", baseCurrency, counterCurrency}
+	// invoke the forex contract to get the rate for the pair. The payer sells the base currency to the bank for the
+	// counter currency, so the bank buys it at the bid rate and keeps the spread. The forex contract swaps the bid and
+	// ask of a pair stored the other way round, so the bid is the right side in either direction. The markup for the
+	// customer's segment is applied over it by the caller
+	stringArgs := []string{"getForexPair", baseCurrency, counterCurrency, "bid"}
 	args := util.ArrayToChaincodeArgs(stringArgs)
 	response := stub.InvokeChaincode(forexContract, args, "")
 
 	if response.Status != shim.OK {
-		return 0.0, errors.New("Unable to get exchange rate from Forex Contract" + response.Message)
+		return decimal.Zero, errors.New("Unable to get exchange rate from Forex Contract" + response.Message)
 	}
 
 	responseForex := &forexPair{}
 	err := json.Unmarshal(response.GetPayload(), responseForex)
 
 	if err != nil {
-		return 0.0, errors.New("Unable to unmarshal exchange rate from Forex Contract" + err.Error())
+		return decimal.Zero, errors.New("Unable to unmarshal exchange rate from Forex Contract" + err.Error())
+	}
+
+	// reject the rate if it has expired, so payments never execute on outdated prices
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		return decimal.Zero, errors.New("Unable to get transaction timestamp " + err.Error())
+	}
+
+	if responseForex.ValidFor > 0 && timestamp.GetSeconds() >= responseForex.Timestamp+responseForex.ValidFor {
+		return decimal.Zero, errors.New("Stale rate for " + responseForex.Pair + ", the rate has expired")
 	}
 
 	return responseForex.Rate, nil
 }
+
+// getQuotedConversion uses a quote created by the forex contract for the debited account, returning its locked rate.
+// The forex contract rejects quotes that are for another pair or account, are for less than the amount, have
+// expired or have already been used.
+func getQuotedConversion(stub shim.ChaincodeStubInterface, forexContract string, quoteID string, baseCurrency string, counterCurrency string,
+	bankID string, accNum string, amount decimal.Decimal) (decimal.Decimal, error) {
+
+	if forexContract == "" {
+		return decimal.Zero, errors.New("Forex contract is empty, unable to complete transaction")
+	}
+
+	stringArgs := []string{"consumeQuote", quoteID, baseCurrency, counterCurrency, bankID, accNum, amount.String()}
+	response := stub.InvokeChaincode(forexContract, util.ArrayToChaincodeArgs(stringArgs), "")
+
+	if response.Status != shim.OK {
+		return decimal.Zero, errors.New("Unable to use quote from Forex Contract " + response.Message)
+	}
+
+	responseQuote := &forexQuote{}
+	err := json.Unmarshal(response.GetPayload(), responseQuote)
+
+	if err != nil {
+		return decimal.Zero, errors.New("Unable to unmarshal quote from Forex Contract" + err.Error())
+	}
+
+	return responseQuote.Rate, nil
+}